@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"netflix-household-validator/internal/config"
+	"netflix-household-validator/internal/health"
+)
+
+// runHealthcheck queries the readiness endpoint of the running validator and returns the process exit code.
+// It allows a Docker HEALTHCHECK without curl or wget.
+func runHealthcheck() int {
+	cfg, err := config.Load("config.yaml")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration file: %v\n", err)
+		return 1
+	}
+	if cfg.HTTP.Listen == "" {
+		fmt.Fprintln(os.Stderr, "HTTP listener disabled (http.listen / HTTP_LISTEN), nothing to check")
+		return 1
+	}
+
+	url, err := readinessURL(cfg.HTTP.Listen)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := health.Probe(url, 5*time.Second); err != nil {
+		fmt.Fprintf(os.Stderr, "Not ready: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// readinessURL builds the local /readyz URL from the listen address (e.g. ":9090" or "0.0.0.0:9090")
+func readinessURL(listen string) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", fmt.Errorf("invalid HTTP listen address %q: %w", listen, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/readyz", nil
+}
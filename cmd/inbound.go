@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"netflix-household-validator/internal/health"
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/metrics"
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/smtpd"
+)
+
+// inboxSize is the number of received emails an account may have waiting to be processed. When it is full,
+// the inbound listener (or webhook) answers with a temporary failure and the sender retries later.
+const inboxSize = 64
+
+// inboundEnabled reports whether the SMTP or LMTP listener, or the webhook, is configured
+func inboundEnabled(cfg *models.Config) bool {
+	return cfg.Inbound.SMTP != "" || cfg.Inbound.LMTP != "" || cfg.Webhook.Listen != ""
+}
+
+// inboundRouter dispatches the received messages to the account workers by envelope recipient
+type inboundRouter struct {
+	workers map[string]*accountWorker
+	spool   string
+	// mu serializes the deliveries, so that the inbox room is checked for all the recipients at once
+	mu sync.Mutex
+}
+
+// newInboundRouter maps the recipients of the accounts to their worker
+func newInboundRouter(cfg *models.Config, workers []*accountWorker) (*inboundRouter, error) {
+	router := &inboundRouter{workers: make(map[string]*accountWorker), spool: cfg.Inbound.Spool}
+	for _, w := range workers {
+		for _, rcpt := range w.cfg.Recipients {
+			router.workers[strings.ToLower(rcpt)] = w
+		}
+	}
+	if len(router.workers) == 0 {
+		return nil, errors.New("no account has inbound recipients")
+	}
+
+	if router.spool != "" {
+		if err := os.MkdirAll(router.spool, 0o700); err != nil {
+			return nil, fmt.Errorf("spool: %w", err)
+		}
+	}
+	return router, nil
+}
+
+// startInbound starts the SMTP and LMTP listeners, which run until ctx is cancelled. The listener state is
+// reported to the readiness check.
+func startInbound(ctx context.Context, cfg *models.Config, router *inboundRouter, state *health.State) error {
+	server, err := smtpd.New(cfg.Inbound, router.accept, router.deliverSMTP)
+	if err != nil {
+		return err
+	}
+
+	listeners := []struct {
+		addr string
+		lmtp bool
+	}{{cfg.Inbound.SMTP, false}, {cfg.Inbound.LMTP, true}}
+	for _, listener := range listeners {
+		if listener.addr == "" {
+			continue
+		}
+		l, err := smtpd.Listen(listener.addr)
+		if err != nil {
+			_ = server.Close()
+			return err
+		}
+		if err := server.CheckListener(l); err != nil {
+			_ = l.Close()
+			_ = server.Close()
+			return err
+		}
+
+		protocol := "SMTP"
+		if listener.lmtp {
+			protocol = "LMTP"
+		}
+		logging.Log.Infof("Inbound %s listener on %s", protocol, listener.addr)
+
+		go func() {
+			if err := server.Serve(l, listener.lmtp); err != nil {
+				logging.Log.Errorf("Inbound %s listener error: %v", protocol, err)
+				state.Set(err)
+			}
+		}()
+	}
+	state.Set(nil)
+
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	return nil
+}
+
+// accept reports whether the recipient belongs to an account
+func (r *inboundRouter) accept(rcpt string) bool {
+	_, ok := r.workers[strings.ToLower(rcpt)]
+	return ok
+}
+
+// deliverSMTP hands over a message received by the SMTP or LMTP listener
+func (r *inboundRouter) deliverSMTP(msg *smtpd.Message) error {
+	return r.deliver(msg.From, msg.Recipients, msg.Raw, msg.Received)
+}
+
+// deliver spools the message, then queues it for every account among its recipients. An error makes the
+// sender retry later, so nothing is acknowledged before it is spooled nor queued for only some accounts.
+func (r *inboundRouter) deliver(from string, recipients []string, raw []byte, received time.Time) error {
+	if err := r.writeSpool(raw, received); err != nil {
+		return fmt.Errorf("spool: %w", err)
+	}
+
+	email, err := mailparse.ParseRaw(raw)
+	if err != nil {
+		// Retrying would not help: the message is dropped (it is kept in the spool)
+		logging.Log.Errorf("Error parsing inbound message from %s: %v", from, err)
+		return nil
+	}
+	// The validity window starts when the message is received, as with the IMAP internal date
+	email.InternalDate = received
+
+	var targets []*accountWorker
+	for _, rcpt := range recipients {
+		w := r.workers[strings.ToLower(rcpt)]
+		if w != nil && !slices.Contains(targets, w) {
+			targets = append(targets, w)
+		}
+	}
+
+	// The message is queued for all the accounts or none: a retry after a partial delivery would duplicate it.
+	// The workers only drain their inbox, so the room checked under the lock is still there when queuing.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, w := range targets {
+		if len(w.inbox) == cap(w.inbox) {
+			return fmt.Errorf("inbox of account %s is full", w.name)
+		}
+	}
+	for _, w := range targets {
+		w.inbox <- email
+		w.log.WithField("trace_id", email.TraceID).Infof("Inbound email received from %s", from)
+	}
+	return nil
+}
+
+// writeSpool writes the message to the audit spool, if enabled
+func (r *inboundRouter) writeSpool(raw []byte, received time.Time) error {
+	if r.spool == "" {
+		return nil
+	}
+
+	f, err := os.CreateTemp(r.spool, received.UTC().Format("20060102T150405")+"-*.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(raw); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runInbox processes the emails received by the inbound listener or webhook for the account until ctx is cancelled
+func (w *accountWorker) runInbox(ctx context.Context) {
+	processor := w.newProcessor(nil)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case email := <-w.inbox:
+			outcome := processor.Process(email)
+			metrics.ObserveEmail(w.name, outcome)
+			w.log.WithField("trace_id", email.TraceID).Infof("Inbound email processed: %s", outcome)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"netflix-household-validator/internal/emailprocessor"
+)
+
+// maildirRetryDelay is the wait before watching a Maildir again after an error
+const maildirRetryDelay = 30 * time.Second
+
+// runMaildir processes the messages delivered to the Maildir of the account, waking up on each delivery,
+// until ctx is cancelled
+func (w *accountWorker) runMaildir(ctx context.Context) {
+	for ctx.Err() == nil {
+		watcher, err := w.maildir.Watch()
+		if err != nil {
+			w.log.Errorf("Maildir watch error: %v", err)
+			w.waitBeforeRetry(ctx)
+			continue
+		}
+		w.watching.Store(true)
+		w.log.Infof("Watching Maildir %s", w.maildir.Path())
+
+		for {
+			// Process the messages delivered before or since the last wake-up
+			w.processMaildir()
+
+			if err = watcher.Wait(ctx); err != nil {
+				break
+			}
+		}
+
+		w.watching.Store(false)
+		_ = watcher.Close()
+
+		if errors.Is(err, context.Canceled) {
+			w.log.Info("Account watcher stopped")
+			return
+		}
+		w.log.Errorf("Maildir watch error: %v", err)
+		w.waitBeforeRetry(ctx)
+	}
+}
+
+// processMaildir processes the recent messages of new/
+func (w *accountWorker) processMaildir() {
+	names, err := w.maildir.List(emailprocessor.EmailValidityWindow)
+	if err != nil {
+		w.log.Errorf("Error listing Maildir messages: %v", err)
+		return
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	w.log.Infof("Found %d new email(s) to process", len(names))
+	w.processAll(w.maildir, names)
+}
+
+// waitBeforeRetry waits maildirRetryDelay, or until ctx is cancelled
+func (w *accountWorker) waitBeforeRetry(ctx context.Context) {
+	timer := time.NewTimer(maildirRetryDelay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
@@ -3,114 +3,513 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"netflix-household-validator/internal/config"
 	"netflix-household-validator/internal/emailprocessor"
+	"netflix-household-validator/internal/health"
+	"netflix-household-validator/internal/history"
 	imapclient "netflix-household-validator/internal/imap"
 	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/mailauth"
+	"netflix-household-validator/internal/maildir"
+	"netflix-household-validator/internal/mailer"
+	"netflix-household-validator/internal/metrics"
 	"netflix-household-validator/internal/models"
 	"netflix-household-validator/internal/netflix"
+	"netflix-household-validator/internal/notify"
+	"netflix-household-validator/internal/oauth2"
+	"netflix-household-validator/internal/pop3"
+
+	"github.com/sirupsen/logrus"
 )
 
-var imapFailureCount atomic.Int32
+const (
+	failureSleepDuration = 30 * time.Minute
+	// maxIdleAge is how long an IMAP session may go without (re-)issuing IDLE before it is reported not ready.
+	// IDLE is re-issued every 25 minutes.
+	maxIdleAge = 30 * time.Minute
+	// selfTestInterval is how often the browser self-test is run for the readiness check
+	selfTestInterval = time.Hour
+)
 
-const failureSleepDuration = 30 * time.Minute
+// accountWorker supervises a single mailbox: its IMAP session (or Maildir watcher, POP3 poller), the emails received by the
+// inbound listener, Netflix service and reconnect/backoff state
+type accountWorker struct {
+	name             string
+	cfg              *models.Config
+	client           *imapclient.StandardClient
+	tokens           *oauth2.TokenSource
+	uidValidity      map[string]uint32
+	maildir          *maildir.Maildir
+	watching         atomic.Bool
+	pop3             *pop3.Mailbox
+	lastPoll         atomic.Int64
+	netflixService   *netflix.Service
+	verifier         *mailauth.Verifier
+	history          *history.Store
+	notifier         *notify.Notifier
+	mailer           *mailer.Mailer
+	inbox            chan *models.Email
+	authenticated    atomic.Bool
+	imapFailureCount atomic.Int32
+	log              *logrus.Entry
+
+	// downSince is when the IMAP connection was lost, zero while connected; downNotified records that
+	// the imap_down notification was sent for this outage
+	downSince    time.Time
+	downNotified bool
+
+	// mailboxes are the watched mailboxes of the IMAP session, special-use aliases resolved; notifying records
+	// that the server reports their changes with NOTIFY (they are polled otherwise)
+	mailboxes []string
+	notifying bool
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "healthcheck":
+			os.Exit(runHealthcheck())
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "pipe":
+			os.Exit(runPipe(os.Args[2:]))
+		}
+	}
+
+	dryRun := flag.Bool("dry-run", false, "log the links that would be opened without opening them or marking emails as seen")
+	flag.Parse()
+
 	cfg, err := config.Load("config.yaml")
 	if err != nil {
 		logging.Log.Fatalf("Error reading configuration file: %v", err)
 	}
+	if *dryRun {
+		cfg.DryRun = true
+	}
 
-	logging.Log.Info("Starting Netflix email verification process (IMAP IDLE mode)")
+	logging.Log.Infof("Starting Netflix email verification process (%d account(s))", len(cfg.Accounts))
+	if cfg.DryRun {
+		logging.Log.Warn("Dry-run mode: no link will be opened and no email will be marked as seen")
+	}
+	if cfg.Authentication.ModeDefaulted {
+		logging.Log.Warn("Authentication mode not configured, defaulting to enforce: emails that cannot be authenticated " +
+			"(e.g. forwarders or gateways rewriting DKIM) are rejected. Set authentication.mode to report to only log them.")
+	}
 
 	// Start background cleanup for Rod temp directories
 	netflix.StartCleanup()
 
-	// Initialize Netflix service
+	// The browser is stateless (fresh profile per attempt) and can be shared by all accounts
 	browser := netflix.NewRodBrowser()
-	netflixService := netflix.NewService(browser, cfg)
+
+	// The history is shared by all accounts, so a link forwarded to several mailboxes is only opened once
+	var store *history.Store
+	if !cfg.History.Disabled {
+		store, err = history.Open(cfg.History.Path)
+		if err != nil {
+			logging.Log.Fatalf("Error opening history database: %v", err)
+		}
+		defer store.Close()
+	}
+
+	notifier, err := notify.New(cfg.Notifications)
+	if err != nil {
+		logging.Log.Fatalf("Error configuring notifications: %v", err)
+	}
+	defer notifier.Close()
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
-	// Create persistent IMAP client
-	client := imapclient.NewStandardClient()
+	checker := health.NewChecker()
+
+	workers := make([]*accountWorker, 0, len(cfg.Accounts))
+	for _, account := range cfg.Accounts {
+		worker, err := newAccountWorker(account, cfg, browser, store, notifier)
+		if err != nil {
+			logging.Log.Fatalf("Error configuring account %s: %v", account.Name, err)
+		}
+		defer worker.mailer.Close()
+		if worker.client != nil || worker.maildir != nil || worker.pop3 != nil {
+			checker.Register(worker.checkName(), worker.ready)
+		}
+		workers = append(workers, worker)
+	}
+
+	if inboundEnabled(cfg) {
+		router, err := newInboundRouter(cfg, workers)
+		if err != nil {
+			logging.Log.Fatalf("Error configuring inbound delivery: %v", err)
+		}
+		if cfg.Inbound.SMTP != "" || cfg.Inbound.LMTP != "" {
+			var inboundState health.State
+			checker.Register("inbound", inboundState.Check)
+			if err := startInbound(ctx, cfg, router, &inboundState); err != nil {
+				logging.Log.Fatalf("Error starting inbound listener: %v", err)
+			}
+		}
+		if cfg.Webhook.Listen != "" {
+			var webhookState health.State
+			checker.Register("webhook", webhookState.Check)
+			if err := startWebhook(ctx, cfg, router, &webhookState); err != nil {
+				logging.Log.Fatalf("Error starting inbound webhook: %v", err)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, worker := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker.run(ctx)
+		}()
+	}
+
+	if cfg.HTTP.Listen != "" {
+		var browserState health.State
+		checker.Register("browser", browserState.Check)
+		go runBrowserSelfTest(ctx, browser, &browserState)
+
+		metrics.RegisterActiveSessions(netflix.ActiveSessions)
+		startHTTPServer(ctx, cfg.HTTP.Listen, checker)
+	}
+
+	wg.Wait()
+	logging.Log.Info("Shutting down gracefully")
+}
+
+// newAccountWorker creates the worker for the given account with its own IMAP client (or Maildir, POP3 mailbox) and
+// Netflix service. An account without IMAP server, Maildir nor POP3 server only receives emails from the inbound listener.
+func newAccountWorker(account models.AccountConfig, cfg *models.Config, browser netflix.Browser, store *history.Store,
+	notifier *notify.Notifier) (*accountWorker, error) {
+	accountCfg := cfg.ForAccount(account)
+
+	// The access tokens are shared by the IMAP connection and the replies: a rotated refresh token is only valid once
+	var tokens *oauth2.TokenSource
+	if account.Email.OAuth2.Enabled() {
+		tokens = oauth2.NewTokenSource(account.Email.OAuth2)
+	}
+
+	replies, err := mailer.New(account.Name, accountCfg, store, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("reply mailer: %w", err)
+	}
+
+	var serviceOpts []netflix.Option
+	if accountCfg.DryRun {
+		// Nothing is contacted in dry-run mode, not even the link wrappers
+		serviceOpts = append(serviceOpts, netflix.WithRedirectResolver(nil))
+	}
+
+	w := &accountWorker{
+		name:           account.Name,
+		cfg:            accountCfg,
+		netflixService: netflix.NewService(browser, accountCfg, serviceOpts...),
+		verifier:       mailauth.NewVerifier(accountCfg.Authentication, nil),
+		history:        store,
+		notifier:       notifier,
+		mailer:         replies,
+		log:            logging.Log.WithField("account", account.Name),
+	}
+
+	inbound := inboundEnabled(cfg) && len(account.Recipients) > 0
+	if inbound {
+		w.inbox = make(chan *models.Email, inboxSize)
+	}
+
+	switch {
+	case account.Maildir.Path != "":
+		if w.maildir, err = maildir.New(account.Maildir.Path, account.Maildir.Flags); err != nil {
+			return nil, err
+		}
+	case account.POP3.Server != "":
+		// A nil *history.Store must not become a non-nil interface
+		var handled pop3.HandledStore
+		if store != nil {
+			handled = store
+		}
+		w.pop3 = pop3.New(account.POP3, account.Email.Login, account.Email.Password, handled)
+	case account.Email.Imap == "" && inbound:
+	default:
+		w.client = imapclient.NewStandardClient()
+		w.uidValidity = make(map[string]uint32)
+		w.tokens = tokens
+	}
+	return w, nil
+}
+
+// checkName returns the name of the readiness check of the worker
+func (w *accountWorker) checkName() string {
+	switch {
+	case w.maildir != nil:
+		return "maildir:" + w.name
+	case w.pop3 != nil:
+		return "pop3:" + w.name
+	}
+	return "imap:" + w.name
+}
+
+// findAccount returns the account with the given name, or the first one when name is empty (used by the subcommands)
+func findAccount(cfg *models.Config, name string) (models.AccountConfig, error) {
+	if name == "" {
+		return cfg.Accounts[0], nil
+	}
+	for _, account := range cfg.Accounts {
+		if account.Name == name {
+			return account, nil
+		}
+	}
+	return models.AccountConfig{}, fmt.Errorf("unknown account %q", name)
+}
+
+// run drives the IMAP IDLE loop (or the Maildir watcher) of the account, and processes the emails received by
+// the inbound listener, until ctx is cancelled
+func (w *accountWorker) run(ctx context.Context) {
+	if w.inbox != nil {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			w.runInbox(ctx)
+		}()
+		defer func() { <-done }()
+	}
+
+	switch {
+	case w.maildir != nil:
+		w.runMaildir(ctx)
+	case w.pop3 != nil:
+		w.runPOP3(ctx)
+	case w.client != nil:
+		w.runIMAP(ctx)
+	}
+}
+
+// runIMAP drives the IMAP IDLE loop of the account until ctx is cancelled
+func (w *accountWorker) runIMAP(ctx context.Context) {
 	connected := false
 
-	for {
+	for ctx.Err() == nil {
 		if !connected {
-			if err := connectAndAuthenticate(client, cfg); err != nil {
-				handleIMAPFailure(err)
+			if err := w.connectAndAuthenticate(); err != nil {
+				w.handleIMAPFailure(ctx, err)
+				continue
+			}
+			if err := w.watchMailboxes(); err != nil {
+				_ = w.client.Close()
+				w.handleIMAPFailure(ctx, err)
 				continue
 			}
 			connected = true
-			imapFailureCount.Store(0)
+			w.authenticated.Store(true)
+			w.imapFailureCount.Store(0)
+			w.downSince, w.downNotified = time.Time{}, false
+			metrics.SetIDLEStarted(w.name)
 		}
 
 		// Process any emails that arrived before or during connection setup
-		fetchAndProcessEmails(client, netflixService, cfg)
+		w.fetchAndProcessEmails()
 
-		// Ensure mailbox is selected before entering IDLE (fetchAndProcessEmails may have failed early)
-		if err := client.SelectMailbox(cfg.Email.MailBox); err != nil {
-			logging.Log.Errorf("Failed to select mailbox: %v", err)
+		// Select the first mailbox before entering IDLE (fetchAndProcessEmails leaves the last one selected)
+		if err := w.client.SelectMailbox(w.mailboxes[0]); err != nil {
+			w.log.Errorf("Failed to select mailbox: %v", err)
 			connected = false
-			_ = client.Close()
+			w.authenticated.Store(false)
+			metrics.SetIDLEStopped(w.name)
+			_ = w.client.Close()
 			continue
 		}
 
-		// Block until the server notifies us of new mail via IMAP IDLE
-		if err := client.WaitForNewMail(ctx); err != nil {
+		// Block until the server notifies us of new mail via IMAP IDLE (or NOTIFY), or until the other
+		// mailboxes must be polled
+		if err := w.waitForNewMail(ctx); err != nil {
 			connected = false
-			_ = client.Close()
+			w.authenticated.Store(false)
+			metrics.SetIDLEStopped(w.name)
+			_ = w.client.Close()
 			if errors.Is(err, context.Canceled) {
-				logging.Log.Info("Shutting down gracefully")
+				w.log.Info("Account watcher stopped")
 				return
 			}
-			handleIMAPFailure(err)
+			w.handleIMAPFailure(ctx, err)
 			continue
 		}
 
 		// New mail signalled - loop back to fetch and process
 	}
+
+	if connected {
+		_ = w.client.Close()
+	}
+}
+
+// waitForNewMail waits for new mail in the watched mailboxes. Without NOTIFY only the selected mailbox is watched
+// with IDLE, which is interrupted every poll interval so that the other ones are checked. With NOTIFY the poll is
+// kept as a safety net: the events received while the mailboxes are processed are lost.
+func (w *accountWorker) waitForNewMail(ctx context.Context) error {
+	if len(w.mailboxes) == 1 {
+		return w.client.WaitForNewMail(ctx)
+	}
+
+	pollCtx, cancel := context.WithTimeout(ctx, time.Duration(w.cfg.Email.PollSeconds)*time.Second)
+	defer cancel()
+	err := w.client.WaitForNewMail(pollCtx)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil
+	}
+	return err
+}
+
+// ready reports whether the account has an authenticated IMAP session that recently (re-)issued IDLE,
+// a running Maildir watcher, or a recent successful POP3 poll
+func (w *accountWorker) ready() error {
+	if w.maildir != nil {
+		if !w.watching.Load() {
+			return errors.New("Maildir not watched")
+		}
+		return nil
+	}
+	if w.pop3 != nil {
+		return w.pop3Ready()
+	}
+	if !w.authenticated.Load() {
+		return errors.New("IMAP session not authenticated")
+	}
+	last := w.client.LastIdleRefresh()
+	if last.IsZero() || time.Since(last) > maxIdleAge {
+		return fmt.Errorf("no IMAP IDLE refresh since %s", last.Format(time.RFC3339))
+	}
+	return nil
 }
 
 // connectAndAuthenticate establishes connection and authenticates with IMAP server
-func connectAndAuthenticate(client *imapclient.StandardClient, cfg *models.Config) error {
+func (w *accountWorker) connectAndAuthenticate() error {
 	// Connect
-	if err := client.Connect(cfg.Email.Imap); err != nil {
+	if err := w.client.Connect(w.cfg.Email.Imap); err != nil {
 		return err
 	}
 
 	// Login
-	if err := client.Login(cfg.Email.Login, cfg.Email.Password); err != nil {
-		_ = client.Close()
+	if err := w.authenticate(); err != nil {
+		_ = w.client.Close()
 		return err
 	}
 
-	logging.Log.Info("IMAP connection established successfully")
+	w.log.Info("IMAP connection established successfully")
 	return nil
 }
 
-// fetchAndProcessEmails retrieves unseen emails and processes them using the existing connection
-func fetchAndProcessEmails(client *imapclient.StandardClient, netflixService *netflix.Service, cfg *models.Config) {
-	startTime := time.Now()
+// watchMailboxes resolves the watched mailboxes of the new IMAP session and, when there are several, subscribes to
+// their changes with NOTIFY if the server supports it
+func (w *accountWorker) watchMailboxes() error {
+	mailboxes, err := w.resolveMailboxes()
+	if err != nil {
+		return err
+	}
+	w.mailboxes, w.notifying = mailboxes, false
+
+	if len(mailboxes) > 1 {
+		w.notifying, err = w.client.WatchMailboxes(mailboxes)
+		if err != nil {
+			w.log.Warnf("NOTIFY failed, polling the mailboxes instead: %v", err)
+		}
+		if !w.notifying {
+			w.log.Infof("Watching %s with IDLE, polling the other mailboxes every %ds", mailboxes[0], w.cfg.Email.PollSeconds)
+		}
+	}
+	w.log.Infof("Watching mailbox(es): %s", strings.Join(mailboxes, ", "))
+	return nil
+}
 
+// resolveMailboxes returns the names of the mailboxes to watch, with the special-use aliases (\Junk...) resolved
+// with LIST. Aliases the server does not know are left out.
+func (w *accountWorker) resolveMailboxes() ([]string, error) {
+	var special map[string]string
+	mailboxes := make([]string, 0, len(w.cfg.Email.Mailboxes))
+	for _, name := range w.cfg.Email.Mailboxes {
+		if strings.HasPrefix(name, `\`) {
+			if special == nil {
+				var err error
+				if special, err = w.client.SpecialUseMailboxes(); err != nil {
+					return nil, err
+				}
+			}
+			resolved, ok := special[name]
+			if !ok {
+				w.log.Warnf("No %s mailbox on the server (SPECIAL-USE), not watched", name)
+				continue
+			}
+			name = resolved
+		}
+		if !slices.Contains(mailboxes, name) {
+			mailboxes = append(mailboxes, name)
+		}
+	}
+	if len(mailboxes) == 0 {
+		return nil, errors.New("no mailbox to watch")
+	}
+	return mailboxes, nil
+}
+
+// authenticate logs in with the password, or with an OAuth2 access token when configured. A rejected token is
+// refreshed and tried once more, as it may have been revoked before its expiry.
+func (w *accountWorker) authenticate() error {
+	if w.tokens == nil {
+		return w.client.Login(w.cfg.Email.Login, w.cfg.Email.Password)
+	}
+
+	mechanism := w.cfg.Email.OAuth2.Mechanism
+	for attempt := 1; ; attempt++ {
+		token, err := w.tokens.Token()
+		if err != nil {
+			return fmt.Errorf("OAuth2 token: %w", err)
+		}
+		err = w.client.Authenticate(w.cfg.Email.Login, token, mechanism)
+		if err == nil {
+			return nil
+		}
+		w.tokens.Invalidate()
+		if attempt == 2 {
+			return err
+		}
+		w.log.Warnf("OAuth2 authentication failed, refreshing the access token: %v", err)
+	}
+}
+
+// fetchAndProcessEmails retrieves the emails not processed yet from every watched mailbox and processes them using
+// the existing connection
+func (w *accountWorker) fetchAndProcessEmails() {
+	for _, mailbox := range w.mailboxes {
+		w.fetchAndProcessMailbox(mailbox)
+	}
+}
+
+// fetchAndProcessMailbox retrieves the emails not processed yet from the mailbox and processes them
+func (w *accountWorker) fetchAndProcessMailbox(mailbox string) {
 	// Select mailbox
-	if err := client.SelectMailbox(cfg.Email.MailBox); err != nil {
-		logging.Log.Errorf("Folder selection error: %v", err)
+	if err := w.client.SelectMailbox(mailbox); err != nil {
+		w.log.Errorf("Folder selection error (%s): %v", mailbox, err)
 		return
 	}
+	w.checkUIDValidity(mailbox)
 
-	// List unseen emails from last 15 minutes
-	uids, err := client.ListUnseenUIDs(emailprocessor.EmailValidityWindow)
+	// List the emails from last 15 minutes not processed yet
+	senders, subject := w.netflixService.SearchTerms()
+	uids, err := w.client.ListCandidateUIDs(emailprocessor.EmailValidityWindow, senders, subject)
 	if err != nil {
-		logging.Log.Errorf("Error searching for recent emails: %v", err)
+		w.log.Errorf("Error searching for recent emails: %v", err)
 		return
 	}
 
@@ -118,73 +517,247 @@ func fetchAndProcessEmails(client *imapclient.StandardClient, netflixService *ne
 		return
 	}
 
-	logging.Log.Infof("Found %d unseen email(s) to process", len(uids))
+	w.log.Infof("Found %d email(s) to process in %s", len(uids), mailbox)
+
+	source := emailprocessor.IMAPSource(w.client, emailprocessor.IMAPOptions{
+		Mailbox:  w.mailboxKey(mailbox),
+		MarkSeen: w.cfg.Email.MarkSeen,
+		// DKIM signatures cover the whole message
+		TextOnly: w.cfg.Authentication.Mode == models.AuthModeOff,
+	})
+	w.processAll(source, emailprocessor.IMAPIDs(uids))
+}
+
+// mailboxKey identifies an IMAP mailbox of the account in the history
+func (w *accountWorker) mailboxKey(mailbox string) string {
+	return "imap://" + w.cfg.Email.Login + "@" + w.cfg.Email.Imap + "/" + mailbox
+}
+
+// checkUIDValidity compares the UIDVALIDITY of the selected mailbox with the one last seen, which is kept in the
+// history across restarts. When it changed, the server reassigned the UIDs and the UIDs recorded in the history
+// for the mailbox are cleared.
+func (w *accountWorker) checkUIDValidity(mailbox string) {
+	validity := w.client.UIDValidity()
+	if validity == 0 || validity == w.uidValidity[mailbox] {
+		return
+	}
+
+	key := w.mailboxKey(mailbox)
+	previous := w.uidValidity[mailbox]
+	if previous == 0 && w.history != nil {
+		stored, err := w.history.UIDValidity(key)
+		if err != nil {
+			w.log.Errorf("Error reading the UIDVALIDITY of %s: %v", mailbox, err)
+			return
+		}
+		previous = stored
+	}
+
+	if previous != 0 && previous != validity {
+		w.log.Warnf("UIDVALIDITY of %s changed from %d to %d: the UIDs were reassigned by the server",
+			mailbox, previous, validity)
+	}
+	if previous != validity && w.history != nil {
+		if err := w.history.ResetUIDValidity(key, validity); err != nil {
+			w.log.Errorf("Error recording the UIDVALIDITY of %s: %v", mailbox, err)
+			return
+		}
+	}
+	w.uidValidity[mailbox] = validity
+}
+
+// processAll processes the given messages of the source and logs the statistics of the cycle
+func (w *accountWorker) processAll(source emailprocessor.Source, ids []string) {
+	startTime := time.Now()
 
 	// Initialize statistics
 	stats := emailprocessor.ProcessingStats{
-		Total: len(uids),
+		Total: len(ids),
 	}
 
-	// Create email processor
-	processor := emailprocessor.NewProcessor(client, netflixService)
+	processor := w.newProcessor(source)
 
-	// Process all unseen emails
-	for _, uid := range uids {
-		handled, ignored, err := processor.ProcessEmail(uid)
+	// Process all emails
+	for _, id := range ids {
+		outcome, err := processor.ProcessEmail(id)
 		if err != nil {
-			logging.Log.Errorf("Error processing email UID %d: %v", uid, err)
+			w.log.Errorf("Error processing email %s: %v", id, err)
+			metrics.ObserveEmail(w.name, "error")
 			stats.Failed++
 			continue
 		}
+		metrics.ObserveEmail(w.name, outcome)
 
-		if handled {
+		switch {
+		case outcome.Handled():
 			stats.Processed++
-		} else if ignored {
+		case outcome == models.OutcomeUnauthenticated:
+			stats.Unauthenticated++
+		case outcome == models.OutcomeTooOld:
+			stats.TooOld++
+		case outcome == models.OutcomeDryRun:
+			stats.DryRun++
+		case outcome == models.OutcomeNoLink || outcome == models.OutcomeRejectedLink:
+			stats.NoMatchingLink++
+			stats.Ignored++
+		default:
 			stats.Ignored++
 		}
 	}
 
 	// Log summary
 	duration := time.Since(startTime)
-	logging.Log.Infof(
-		"Processing cycle completed in %v - Total: %d | Processed: %d | Ignored: %d | Failed: %d",
+	w.log.Infof(
+		"Processing cycle completed in %v - Total: %d | Processed: %d | Ignored: %d | No matching link: %d | "+
+			"Too old: %d | Unauthenticated: %d | Dry run: %d | Failed: %d",
 		duration.Round(time.Millisecond),
 		stats.Total,
 		stats.Processed,
 		stats.Ignored,
+		stats.NoMatchingLink,
+		stats.TooOld,
+		stats.Unauthenticated,
+		stats.DryRun,
 		stats.Failed,
 	)
 }
 
-// handleIMAPFailure increments the failure count and implements an exponential backoff strategy.
-// First failure reconnects immediately; subsequent failures use exponential backoff.
-func handleIMAPFailure(err error) {
-	failures := imapFailureCount.Add(1)
-	logging.Log.Errorf("IMAP connection error: %v", err)
+// newProcessor creates the email processor of the account for the given source, nil for the inbound emails
+func (w *accountWorker) newProcessor(source emailprocessor.Source) *emailprocessor.Processor {
+	opts := []emailprocessor.Option{
+		emailprocessor.WithAccount(w.name),
+		emailprocessor.WithVerifier(w.verifier, w.cfg.Authentication.Mode),
+		emailprocessor.WithNotifier(w.notifier),
+		emailprocessor.WithMailer(w.mailer),
+		emailprocessor.WithDryRun(w.cfg.DryRun),
+		emailprocessor.WithActions(w.cfg.Email.Actions),
+	}
+	if w.history != nil {
+		opts = append(opts, emailprocessor.WithHistory(w.history))
+	}
+	return emailprocessor.NewProcessor(source, w.netflixService, opts...)
+}
+
+// handleIMAPFailure increments the account failure count and implements an exponential backoff strategy.
+// First failure reconnects immediately; subsequent failures use exponential backoff. The wait is cut short
+// when ctx is cancelled.
+func (w *accountWorker) handleIMAPFailure(ctx context.Context, err error) {
+	failures := w.imapFailureCount.Add(1)
+	w.log.Errorf("IMAP connection error: %v", err)
+
+	if w.downSince.IsZero() {
+		w.downSince = time.Now()
+	}
+	w.notifyIMAPDown()
 
 	if failures == 1 {
-		logging.Log.Warnf("IMAP failed, reconnecting immediately...")
+		metrics.ObserveIMAPReconnect(w.name, 0)
+		w.log.Warnf("IMAP failed, reconnecting immediately...")
+		return
+	}
+
+	backoff := imapBackoff(failures)
+	metrics.ObserveIMAPReconnect(w.name, backoff)
+
+	w.log.Warnf("IMAP failed %d times, waiting %s before next attempt", failures, backoff)
+
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+
+	// Wake up during the wait if the outage reaches the notification threshold
+	var downTimer <-chan time.Time
+	if after := w.notifier.IMAPDownAfter(); after > 0 && !w.downNotified {
+		t := time.NewTimer(max(0, after-time.Since(w.downSince)))
+		defer t.Stop()
+		downTimer = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case <-downTimer:
+			w.notifyIMAPDown()
+		}
+	}
+}
+
+// notifyIMAPDown sends the imap_down notification once per outage, when it lasts longer than the configured threshold
+func (w *accountWorker) notifyIMAPDown() {
+	after := w.notifier.IMAPDownAfter()
+	if after == 0 || w.downNotified || time.Since(w.downSince) < after {
 		return
 	}
 
-	var backoff time.Duration
+	w.downNotified = true
+	w.notifier.Notify(notify.Event{
+		Type:    notify.EventIMAPDown,
+		Account: w.name,
+		Detail:  w.downSince.Format(time.RFC3339),
+	})
+}
+
+// imapBackoff returns the wait duration before the next reconnect attempt for the given failure count
+func imapBackoff(failures int32) time.Duration {
 	if failures < 5 {
-		backoff = 10 * time.Second
-	} else {
-		base := 5 * time.Minute
-		maxSteps := int32(10)
+		return 10 * time.Second
+	}
+
+	base := 5 * time.Minute
+	maxSteps := int32(10)
 
-		n := failures - 5
-		if n > maxSteps {
-			n = maxSteps
+	n := failures - 5
+	if n > maxSteps {
+		n = maxSteps
+	}
+
+	backoff := base * time.Duration(1<<n)
+	if backoff > failureSleepDuration {
+		backoff = failureSleepDuration
+	}
+	return backoff
+}
+
+// runBrowserSelfTest checks at startup, then every selfTestInterval, that Chromium can be launched
+func runBrowserSelfTest(ctx context.Context, browser *netflix.RodBrowser, state *health.State) {
+	ticker := time.NewTicker(selfTestInterval)
+	defer ticker.Stop()
+
+	for {
+		err := browser.SelfTest()
+		if err != nil {
+			logging.Log.WithError(err).Error("Browser self-test failed")
 		}
+		state.Set(err)
 
-		backoff = base * time.Duration(1<<n)
-		if backoff > failureSleepDuration {
-			backoff = failureSleepDuration
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
 	}
+}
 
-	logging.Log.Warnf("IMAP failed %d times, waiting %s before next attempt", failures, backoff)
-	time.Sleep(backoff)
+// startHTTPServer serves the metrics and health endpoints on addr until ctx is cancelled
+func startHTTPServer(ctx context.Context, addr string, checker *health.Checker) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", checker.ReadinessHandler())
+
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logging.Log.Infof("HTTP server listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Log.Errorf("HTTP server error: %v", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
 }
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"netflix-household-validator/internal/config"
+	"netflix-household-validator/internal/emailprocessor"
+	"netflix-household-validator/internal/history"
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/mailauth"
+	"netflix-household-validator/internal/mailer"
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/netflix"
+	"netflix-household-validator/internal/notify"
+	"netflix-household-validator/internal/oauth2"
+)
+
+// maxPipeMessageSize bounds the message read from stdin
+const maxPipeMessageSize = 32 << 20
+
+// Exit codes of the pipe subcommand. The errors use the sysexits.h values understood by MTAs.
+const (
+	exitValidated       = 0
+	exitExpired         = 2
+	exitAborted         = 3
+	exitFailed          = 4
+	exitIgnored         = 5
+	exitUnauthenticated = 6
+	exitDataErr         = 65 // EX_DATAERR: the message cannot be parsed
+	exitIOErr           = 74 // EX_IOERR: stdin cannot be read
+	exitTempFail        = 75 // EX_TEMPFAIL: the MTA should retry later
+	exitConfig          = 78 // EX_CONFIG: invalid configuration
+)
+
+// runPipe implements the `pipe` subcommand: a single RFC 5322 message is read from stdin (procmail, Sieve
+// `execute :pipe`, Postfix pipe transport) and processed like the daemon does, sharing its history and
+// notifications. It returns the exit code matching the outcome.
+func runPipe(args []string) int {
+	fset := flag.NewFlagSet("pipe", flag.ContinueOnError)
+	configPath := fset.String("config", "config.yaml", "configuration file")
+	accountName := fset.String("account", "", "account whose filters and settings are used (default: the first one)")
+	dryRun := fset.Bool("dry-run", false, "log the link that would be opened without opening it")
+	exitZero := fset.Bool("exit-zero", false, "exit with 0 for every outcome, only errors are reported (Postfix)")
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "Usage: validator pipe [flags] < message.eml")
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return exitConfig
+	}
+
+	// The MTA may keep stdout (e.g. in bounces), logs go to stderr
+	logging.Log.SetOutput(os.Stderr)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		logging.Log.Errorf("Error reading configuration file: %v", err)
+		return exitConfig
+	}
+	if *dryRun {
+		cfg.DryRun = true
+	}
+
+	account, err := findAccount(cfg, *accountName)
+	if err != nil {
+		logging.Log.Error(err)
+		return exitConfig
+	}
+	accountCfg := cfg.ForAccount(account)
+
+	received := time.Now()
+	raw, err := io.ReadAll(io.LimitReader(os.Stdin, maxPipeMessageSize+1))
+	if err != nil {
+		logging.Log.Errorf("Error reading message from stdin: %v", err)
+		return exitIOErr
+	}
+	if len(raw) > maxPipeMessageSize {
+		logging.Log.Errorf("Message larger than %d bytes, refused", maxPipeMessageSize)
+		return exitDataErr
+	}
+
+	email, err := mailparse.ParseRaw(raw)
+	if err != nil {
+		logging.Log.Errorf("Error parsing message: %v", err)
+		return exitDataErr
+	}
+	// The validity window applies from the Date header, as the message may be piped long after its reception
+	// (e.g. formail over a mailbox); without one, from the time it was piped
+	email.InternalDate = email.Date
+	if email.InternalDate.IsZero() || email.InternalDate.After(received) {
+		email.InternalDate = received
+	}
+
+	var store *history.Store
+	if !cfg.History.Disabled {
+		store, err = history.Open(cfg.History.Path)
+		if err != nil {
+			logging.Log.Errorf("Error opening history database: %v", err)
+			return exitTempFail
+		}
+		defer store.Close()
+	}
+
+	notifier, err := notify.New(cfg.Notifications)
+	if err != nil {
+		logging.Log.Errorf("Error configuring notifications: %v", err)
+		return exitConfig
+	}
+	defer notifier.Close()
+
+	var tokens *oauth2.TokenSource
+	if account.Email.OAuth2.Enabled() {
+		tokens = oauth2.NewTokenSource(account.Email.OAuth2)
+	}
+	replies, err := mailer.New(account.Name, accountCfg, store, tokens)
+	if err != nil {
+		logging.Log.Errorf("Error configuring reply mailer: %v", err)
+		return exitConfig
+	}
+	defer replies.Close()
+
+	var browser netflix.Browser
+	var serviceOpts []netflix.Option
+	if !cfg.DryRun {
+		browser = netflix.NewRodBrowser()
+	} else {
+		serviceOpts = append(serviceOpts, netflix.WithRedirectResolver(nil))
+	}
+
+	opts := []emailprocessor.Option{
+		emailprocessor.WithAccount(account.Name),
+		emailprocessor.WithVerifier(mailauth.NewVerifier(accountCfg.Authentication, nil), accountCfg.Authentication.Mode),
+		emailprocessor.WithNotifier(notifier),
+		emailprocessor.WithMailer(replies),
+		emailprocessor.WithDryRun(cfg.DryRun),
+	}
+	if store != nil {
+		opts = append(opts, emailprocessor.WithHistory(store))
+	}
+	processor := emailprocessor.NewProcessor(nil, netflix.NewService(browser, accountCfg, serviceOpts...), opts...)
+
+	outcome := processor.Process(email)
+	logging.Log.WithField("trace_id", email.TraceID).Infof("Piped message processed: %s", outcome)
+
+	if *exitZero {
+		return exitValidated
+	}
+	return pipeExitCode(outcome)
+}
+
+// pipeExitCode maps the outcome of the piped message to the exit code of the subcommand
+func pipeExitCode(outcome models.Outcome) int {
+	switch outcome {
+	case models.OutcomeValidated, models.OutcomeDuplicate, models.OutcomeDryRun:
+		return exitValidated
+	case models.OutcomeExpired:
+		return exitExpired
+	case models.OutcomeAborted:
+		return exitAborted
+	case models.OutcomeFailed, models.OutcomeBrowserError:
+		return exitFailed
+	case models.OutcomeUnauthenticated:
+		return exitUnauthenticated
+	default:
+		return exitIgnored
+	}
+}
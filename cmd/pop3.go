@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"netflix-household-validator/internal/emailprocessor"
+)
+
+// pop3MissedPolls is the number of consecutive failed polls after which the account is reported not ready
+const pop3MissedPolls = 3
+
+// runPOP3 polls the POP3 mailbox of the account every configured interval until ctx is cancelled
+func (w *accountWorker) runPOP3(ctx context.Context) {
+	ticker := time.NewTicker(w.pop3Interval())
+	defer ticker.Stop()
+
+	w.log.Infof("Polling POP3 mailbox %s every %s", w.pop3.Server(), w.pop3Interval())
+	for {
+		w.pollPOP3()
+
+		select {
+		case <-ctx.Done():
+			w.log.Info("Account watcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollPOP3 processes the new messages of the POP3 mailbox in a single session
+func (w *accountWorker) pollPOP3() {
+	if err := w.pop3.Open(); err != nil {
+		w.log.Errorf("POP3 connection error: %v", err)
+		return
+	}
+
+	uids, err := w.pop3.List(emailprocessor.EmailValidityWindow)
+	if err != nil {
+		w.log.Errorf("Error listing POP3 messages: %v", err)
+		_ = w.pop3.Close()
+		return
+	}
+
+	if len(uids) > 0 {
+		w.log.Infof("Found %d new email(s) to process", len(uids))
+		w.processAll(w.pop3, uids)
+	}
+
+	// Ending the session commits the deletions
+	if err := w.pop3.Close(); err != nil {
+		w.log.Errorf("Error closing POP3 session: %v", err)
+		return
+	}
+	w.lastPoll.Store(time.Now().Unix())
+}
+
+// pop3Interval returns the interval between two polls
+func (w *accountWorker) pop3Interval() time.Duration {
+	return time.Duration(w.cfg.POP3.PollSeconds) * time.Second
+}
+
+// pop3Ready reports whether the last successful poll is recent
+func (w *accountWorker) pop3Ready() error {
+	last := w.lastPoll.Load()
+	if last == 0 {
+		return errors.New("no successful POP3 poll yet")
+	}
+	if since := time.Since(time.Unix(last, 0)); since > pop3MissedPolls*w.pop3Interval() {
+		return fmt.Errorf("no successful POP3 poll since %s", time.Unix(last, 0).Format(time.RFC3339))
+	}
+	return nil
+}
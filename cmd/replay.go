@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"netflix-household-validator/internal/config"
+	"netflix-household-validator/internal/emailprocessor"
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/mailauth"
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/netflix"
+)
+
+// Replay browsers
+const (
+	replayBrowserDryRun = "dry-run"
+	replayBrowserRod    = "rod"
+)
+
+// verdict is the replay result of a single .eml file, printed as one JSON line
+type verdict struct {
+	File           string         `json:"file"`
+	From           string         `json:"from,omitempty"`
+	To             []string       `json:"to,omitempty"`
+	Subject        string         `json:"subject,omitempty"`
+	ForwardedBy    string         `json:"forwardedBy,omitempty"`
+	Links          []string       `json:"links,omitempty"`
+	Authentication string         `json:"authentication,omitempty"`
+	Reason         string         `json:"reason,omitempty"`
+	Link           string         `json:"link,omitempty"`
+	Outcome        models.Outcome `json:"outcome,omitempty"`
+	AgeCheck       string         `json:"ageCheck,omitempty"`
+	TraceID        string         `json:"traceId,omitempty"`
+	Error          string         `json:"error,omitempty"`
+}
+
+// replayer runs .eml files through the parser and the processor of one account, without history nor notifications
+type replayer struct {
+	processor *emailprocessor.Processor
+}
+
+// runReplay implements the `replay` subcommand: every .eml file given (or found in the given directories) is parsed
+// and evaluated with the current configuration, and a verdict is printed per file. Links are only opened with
+// -browser=rod. It returns the process exit code.
+func runReplay(args []string) int {
+	fset := flag.NewFlagSet("replay", flag.ContinueOnError)
+	configPath := fset.String("config", "config.yaml", "configuration file")
+	accountName := fset.String("account", "", "account whose filters are used (default: the first one)")
+	browserName := fset.String("browser", replayBrowserDryRun, "browser used for matching emails: dry-run or rod")
+	authMode := fset.String("auth", "", "authentication mode: enforce, report or off (default: the account's mode; "+
+		"enforce and report need DNS for DKIM keys)")
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "Usage: validator replay [flags] <file.eml|directory>...")
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return 2
+	}
+
+	// Verdicts are written to stdout, logs go to stderr
+	logging.Log.SetOutput(os.Stderr)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading configuration file: %v\n", err)
+		return 1
+	}
+
+	account, err := findAccount(cfg, *accountName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	accountCfg := cfg.ForAccount(account)
+
+	mode := accountCfg.Authentication.Mode
+	switch *authMode {
+	case "":
+	case models.AuthModeEnforce, models.AuthModeReport, models.AuthModeOff:
+		mode = *authMode
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown authentication mode %q (enforce, report or off)\n", *authMode)
+		return 2
+	}
+
+	// Replays never resolve the link wrappers over the network (Mimecast): the verdict only depends on the files
+	var service *netflix.Service
+	dryRun := false
+	switch *browserName {
+	case replayBrowserDryRun:
+		dryRun = true
+		service = netflix.NewService(nil, accountCfg, netflix.WithRedirectResolver(nil))
+	case replayBrowserRod:
+		service = netflix.NewService(netflix.NewRodBrowser(), accountCfg, netflix.WithRedirectResolver(nil))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown browser %q (dry-run or rod)\n", *browserName)
+		return 2
+	}
+	opts := []emailprocessor.Option{emailprocessor.WithAccount(account.Name), emailprocessor.WithDryRun(dryRun)}
+	if mode != models.AuthModeOff {
+		verifier := mailauth.NewVerifier(accountCfg.Authentication, nil)
+		opts = append(opts, emailprocessor.WithVerifier(verifier, mode))
+	}
+	r := &replayer{processor: emailprocessor.NewProcessor(nil, service, opts...)}
+
+	files, err := replayFiles(fset.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	code := 0
+	for _, file := range files {
+		v := r.replay(file)
+		if v.Error != "" {
+			code = 1
+		}
+		if err := encoder.Encode(v); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return code
+}
+
+// replay parses and evaluates a single file
+func (r *replayer) replay(file string) verdict {
+	v := verdict{File: file}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		v.Error = err.Error()
+		return v
+	}
+
+	email, err := mailparse.ParseRaw(raw)
+	if err != nil {
+		v.Error = fmt.Sprintf("parse error: %v", err)
+		return v
+	}
+
+	v.From = email.From
+	v.To = email.To
+	v.Subject = email.Subject
+	v.ForwardedBy = email.ForwardedBy
+	v.TraceID = email.TraceID
+	// Saved emails have no reception date: the validity window is not applied
+	v.AgeCheck = "skipped"
+	for _, link := range email.Links {
+		v.Links = append(v.Links, netflix.SanitizeURL(link))
+	}
+
+	result := r.processor.ProcessDetailed(email)
+	v.Outcome = result.Outcome
+	v.Reason = result.Reason
+	if result.Link != "" {
+		v.Link = netflix.SanitizeURL(result.Link)
+	}
+	if auth := result.Authentication; auth != nil {
+		if auth.Pass {
+			v.Authentication = fmt.Sprintf("pass (%s, %s)", auth.Method, auth.Domain)
+		} else {
+			v.Authentication = "fail: " + auth.Reason
+		}
+	}
+	return v
+}
+
+// replayFiles expands the arguments into the list of files to replay: files are kept as is,
+// directories are walked for *.eml files
+func replayFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ".eml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
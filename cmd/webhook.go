@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"netflix-household-validator/internal/health"
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/webhook"
+)
+
+// startWebhook starts the HTTP endpoint receiving the emails posted by cloud email-routing services, which runs
+// until ctx is cancelled. The listener state is reported to the readiness check.
+func startWebhook(ctx context.Context, cfg *models.Config, router *inboundRouter, state *health.State) error {
+	handler, err := webhook.New(cfg.Webhook, router.accept, router.deliverWebhook)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", cfg.Webhook.Listen)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logging.Log.Infof("Inbound webhook listening on %s", cfg.Webhook.Listen)
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Log.Errorf("Inbound webhook error: %v", err)
+			state.Set(err)
+		}
+	}()
+	state.Set(nil)
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	return nil
+}
+
+// deliverWebhook hands over a message posted to the webhook
+func (r *inboundRouter) deliverWebhook(msg *webhook.Message) error {
+	return r.deliver(msg.From, msg.Recipients, msg.Raw, msg.Received)
+}
@@ -1,13 +1,47 @@
 package config
 
 import (
+	"fmt"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
 	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/netflix"
 
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	// defaultMailbox is used for accounts that do not specify a mailbox
+	defaultMailbox = "INBOX"
+	// defaultAuthDomain is the domain Netflix emails are signed for
+	defaultAuthDomain = "netflix.com"
+	// defaultHistoryPath is the processing history database file
+	defaultHistoryPath = "history.db"
+	// defaultMaildirFlags are set on the Maildir messages that were handled
+	defaultMaildirFlags = "S"
+	// defaultPOP3PollSeconds is the interval between two polls of a POP3 mailbox
+	defaultPOP3PollSeconds = 60
+	// defaultIMAPPollSeconds is the interval between two checks of the watched mailboxes when there are several
+	defaultIMAPPollSeconds = 60
+)
+
+// specialUseMailboxes are the special-use attributes (RFC 6154) accepted as mailbox names
+var specialUseMailboxes = []string{`\All`, `\Archive`, `\Drafts`, `\Flagged`, `\Junk`, `\Sent`, `\Trash`}
+
+// oauth2TokenURLs are the token endpoints of the known OAuth2 providers
+var oauth2TokenURLs = map[string]string{
+	models.OAuth2ProviderGoogle:    "https://oauth2.googleapis.com/token",
+	models.OAuth2ProviderMicrosoft: "https://login.microsoftonline.com/common/oauth2/v2.0/token",
+}
+
+// maildirFlagsRe matches valid Maildir info flags (standard uppercase flags and Dovecot lowercase keywords)
+var maildirFlagsRe = regexp.MustCompile(`^[A-Za-z]+$`)
+
 // Load reads the configuration from the specified YAML file and returns a Config struct
 func Load(filepath string) (*models.Config, error) {
 	var cfg models.Config
@@ -27,6 +61,17 @@ func Load(filepath string) (*models.Config, error) {
 	// Override with environment variables if set
 	overrideFromEnv(&cfg)
 
+	// Build the account list (legacy single-mailbox configs become one account)
+	if err := normalizeAccounts(&cfg); err != nil {
+		return nil, err
+	}
+
+	applyDefaults(&cfg)
+
+	if err := validate(&cfg); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
 
@@ -34,11 +79,268 @@ func Load(filepath string) (*models.Config, error) {
 func overrideFromEnv(cfg *models.Config) {
 	setString(&cfg.TargetFrom, "TARGET_FROM")
 	setString(&cfg.TargetSubject, "TARGET_SUBJECT")
+	setList(&cfg.SubjectLocales, "SUBJECT_LOCALES")
+	setList(&cfg.TrustedForwarders, "TRUSTED_FORWARDERS")
+	setString(&cfg.Authentication.Mode, "AUTH_MODE")
+	setList(&cfg.AllowedLinkHosts, "ALLOWED_LINK_HOSTS")
+	setString(&cfg.History.Path, "HISTORY_PATH")
+	setString(&cfg.HTTP.Listen, "HTTP_LISTEN")
+	for _, url := range models.SplitList(os.Getenv("NOTIFY_URLS")) {
+		cfg.Notifications.Targets = append(cfg.Notifications.Targets, models.NotificationTarget{URL: url})
+	}
+	setString(&cfg.Reply.SMTP, "REPLY_SMTP")
+	setString(&cfg.Reply.Language, "REPLY_LANGUAGE")
+	setBool(&cfg.DryRun, "DRY_RUN")
 
 	setString(&cfg.Email.Imap, "EMAIL_IMAP")
 	setString(&cfg.Email.Login, "EMAIL_LOGIN")
 	setString(&cfg.Email.Password, "EMAIL_PASSWORD")
 	setString(&cfg.Email.MailBox, "EMAIL_MAILBOX")
+	setList(&cfg.Email.Mailboxes, "EMAIL_MAILBOXES")
+	setBool(&cfg.Email.MarkSeen, "EMAIL_MARK_SEEN")
+	setString(&cfg.Email.OAuth2.Provider, "EMAIL_OAUTH2_PROVIDER")
+	setString(&cfg.Email.OAuth2.TokenURL, "EMAIL_OAUTH2_TOKEN_URL")
+	setString(&cfg.Email.OAuth2.ClientID, "EMAIL_OAUTH2_CLIENT_ID")
+	setString(&cfg.Email.OAuth2.ClientSecret, "EMAIL_OAUTH2_CLIENT_SECRET")
+	setString(&cfg.Email.OAuth2.RefreshToken, "EMAIL_OAUTH2_REFRESH_TOKEN")
+	setString(&cfg.Maildir.Path, "MAILDIR_PATH")
+	setString(&cfg.POP3.Server, "POP3_SERVER")
+	setList(&cfg.Recipients, "INBOUND_RECIPIENTS")
+	setString(&cfg.Inbound.SMTP, "INBOUND_SMTP")
+	setString(&cfg.Inbound.LMTP, "INBOUND_LMTP")
+	setString(&cfg.Webhook.Listen, "WEBHOOK_LISTEN")
+	setString(&cfg.Webhook.Mailgun.SigningKey, "WEBHOOK_MAILGUN_SIGNING_KEY")
+	setString(&cfg.Webhook.Cloudflare.Secret, "WEBHOOK_CLOUDFLARE_SECRET")
+}
+
+// normalizeAccounts fills the account list: when no accounts are configured, the top-level email settings
+// become a single "default" account. Accounts inherit targetFrom/targetSubject from the top level when unset.
+func normalizeAccounts(cfg *models.Config) error {
+	if len(cfg.Accounts) == 0 {
+		cfg.Accounts = []models.AccountConfig{{
+			Name:       "default",
+			Email:      cfg.Email,
+			Maildir:    cfg.Maildir,
+			POP3:       cfg.POP3,
+			Recipients: cfg.Recipients,
+		}}
+	}
+
+	seen := make(map[string]struct{}, len(cfg.Accounts))
+	for i := range cfg.Accounts {
+		account := &cfg.Accounts[i]
+
+		if account.Name == "" {
+			account.Name = fmt.Sprintf("account-%d", i+1)
+		}
+		if _, dup := seen[account.Name]; dup {
+			return fmt.Errorf("duplicate account name %q", account.Name)
+		}
+		seen[account.Name] = struct{}{}
+
+		if account.TargetFrom == "" {
+			account.TargetFrom = cfg.TargetFrom
+		}
+		if account.TargetSubject == "" {
+			account.TargetSubject = cfg.TargetSubject
+		}
+		if account.Email.MailBox == "" {
+			account.Email.MailBox = defaultMailbox
+		}
+		if err := normalizeMailboxes(&account.Email); err != nil {
+			return fmt.Errorf("account %q: %w", account.Name, err)
+		}
+		if account.Maildir.Flags == "" {
+			account.Maildir.Flags = defaultMaildirFlags
+		}
+		if !maildirFlagsRe.MatchString(account.Maildir.Flags) {
+			return fmt.Errorf("invalid Maildir flags %q for account %q", account.Maildir.Flags, account.Name)
+		}
+		if account.POP3.Security == "" {
+			account.POP3.Security = models.SMTPSecurityTLS
+		}
+		switch account.POP3.Security {
+		case models.SMTPSecurityTLS, models.SMTPSecuritySTARTTLS, models.SMTPSecurityNone:
+		default:
+			return fmt.Errorf("invalid POP3 security %q for account %q", account.POP3.Security, account.Name)
+		}
+		if account.POP3.PollSeconds <= 0 {
+			account.POP3.PollSeconds = defaultPOP3PollSeconds
+		}
+		if err := normalizeActions(account.Email.Actions); err != nil {
+			return fmt.Errorf("account %q: %w", account.Name, err)
+		}
+		if err := normalizeOAuth2(&account.Email.OAuth2); err != nil {
+			return fmt.Errorf("account %q: %w", account.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// normalizeMailboxes defaults the watched mailboxes to the mailbox setting and spells the special-use aliases as
+// in RFC 6154
+func normalizeMailboxes(email *models.EmailConfig) error {
+	if len(email.Mailboxes) == 0 {
+		email.Mailboxes = models.StringList{email.MailBox}
+	}
+	if email.PollSeconds <= 0 {
+		email.PollSeconds = defaultIMAPPollSeconds
+	}
+
+	for i, name := range email.Mailboxes {
+		if strings.HasPrefix(name, `\`) {
+			j := slices.IndexFunc(specialUseMailboxes, func(alias string) bool { return strings.EqualFold(alias, name) })
+			if j < 0 {
+				return fmt.Errorf("unknown special-use mailbox %q", name)
+			}
+			email.Mailboxes[i] = specialUseMailboxes[j]
+		}
+		if slices.Contains(email.Mailboxes[:i], email.Mailboxes[i]) {
+			return fmt.Errorf("duplicate mailbox %q", name)
+		}
+	}
+	return nil
+}
+
+// normalizeActions replaces the browser result names of the mailbox actions by the outcomes they map to
+func normalizeActions(actions map[string]models.MailboxAction) error {
+	for _, name := range slices.Collect(maps.Keys(actions)) {
+		action := actions[name]
+		outcome, ok := models.ParseOutcome(name)
+		if !ok {
+			return fmt.Errorf("unknown outcome %q in mailbox actions", name)
+		}
+		if action.Move != "" && action.Delete {
+			return fmt.Errorf("mailbox action of %q cannot both move and delete", name)
+		}
+		if string(outcome) != name {
+			if _, dup := actions[string(outcome)]; dup {
+				return fmt.Errorf("mailbox actions of %q and %q are both set", name, outcome)
+			}
+			delete(actions, name)
+			actions[string(outcome)] = action
+		}
+	}
+	return nil
+}
+
+// normalizeOAuth2 resolves the token endpoint of the provider and reads the secrets from their files
+func normalizeOAuth2(oauth *models.OAuth2Config) error {
+	if !oauth.Enabled() {
+		return nil
+	}
+
+	if oauth.Mechanism == "" {
+		oauth.Mechanism = models.OAuth2MechanismXOAUTH2
+	}
+	switch oauth.Mechanism {
+	case models.OAuth2MechanismXOAUTH2, models.OAuth2MechanismOAUTHBEARER:
+	default:
+		return fmt.Errorf("invalid OAuth2 mechanism %q", oauth.Mechanism)
+	}
+
+	if oauth.TokenURL == "" {
+		url, ok := oauth2TokenURLs[oauth.Provider]
+		if !ok {
+			return fmt.Errorf("OAuth2 needs a tokenUrl or a known provider, got %q", oauth.Provider)
+		}
+		oauth.TokenURL = url
+	}
+
+	if oauth.ClientSecret == "" && oauth.ClientSecretFile != "" {
+		secret, err := readSecret(oauth.ClientSecretFile)
+		if err != nil {
+			return fmt.Errorf("OAuth2 client secret: %w", err)
+		}
+		oauth.ClientSecret = secret
+	}
+	if oauth.RefreshToken == "" && oauth.RefreshTokenFile != "" {
+		token, err := readSecret(oauth.RefreshTokenFile)
+		if err != nil {
+			return fmt.Errorf("OAuth2 refresh token: %w", err)
+		}
+		oauth.RefreshToken = token
+	}
+	if oauth.RefreshToken == "" {
+		return fmt.Errorf("OAuth2 needs a refresh token")
+	}
+	return nil
+}
+
+// readSecret reads a secret file, without its surrounding whitespace
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// applyDefaults fills the optional settings that have a non-zero default
+func applyDefaults(cfg *models.Config) {
+	if cfg.Authentication.Mode == "" {
+		cfg.Authentication.Mode = models.AuthModeEnforce
+		cfg.Authentication.ModeDefaulted = true
+	}
+	if cfg.Authentication.Domain == "" {
+		cfg.Authentication.Domain = defaultAuthDomain
+	}
+	if cfg.History.Path == "" {
+		cfg.History.Path = defaultHistoryPath
+	}
+}
+
+// validate checks the configuration values that cannot be verified by YAML decoding alone
+func validate(cfg *models.Config) error {
+	catalog := netflix.CatalogLocales()
+	for _, locale := range cfg.SubjectLocales {
+		locale = strings.ToLower(strings.TrimSpace(locale))
+		if locale != netflix.AllLocales && !slices.Contains(catalog, locale) {
+			return fmt.Errorf("unknown subject locale %q (available: %s, %s)", locale, netflix.AllLocales,
+				strings.Join(catalog, ", "))
+		}
+	}
+
+	for _, pattern := range cfg.SubjectPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid subject pattern %q: %w", pattern, err)
+		}
+	}
+
+	switch cfg.Authentication.Mode {
+	case models.AuthModeEnforce, models.AuthModeReport, models.AuthModeOff:
+	default:
+		return fmt.Errorf("invalid authentication mode %q", cfg.Authentication.Mode)
+	}
+
+	for _, network := range cfg.Inbound.AllowedNetworks {
+		if _, err := models.ParseNetwork(network); err != nil {
+			return fmt.Errorf("invalid inbound allowed network %q: %w", network, err)
+		}
+	}
+	if (cfg.Inbound.TLSCert == "") != (cfg.Inbound.TLSKey == "") {
+		return fmt.Errorf("inbound tlsCert and tlsKey must be set together")
+	}
+
+	if cfg.Webhook.Listen != "" {
+		sendgrid := cfg.Webhook.SendGrid
+		if (sendgrid.Username == "") != (sendgrid.Password == "") {
+			return fmt.Errorf("webhook sendgrid username and password must be set together")
+		}
+		if cfg.Webhook.Mailgun.SigningKey == "" && sendgrid.Password == "" && sendgrid.PublicKey == "" &&
+			cfg.Webhook.Cloudflare.Secret == "" {
+			return fmt.Errorf("webhook listen is set but no provider is configured")
+		}
+	}
+
+	switch cfg.Reply.Security {
+	case "", models.SMTPSecurityTLS, models.SMTPSecuritySTARTTLS, models.SMTPSecurityNone:
+	default:
+		return fmt.Errorf("invalid reply SMTP security %q", cfg.Reply.Security)
+	}
+
+	return nil
 }
 
 // setString checks if the specified environment variable is set and not empty, and if so, assigns its value to the provided string pointer
@@ -47,3 +349,19 @@ func setString(field *string, envKey string) {
 		*field = v
 	}
 }
+
+// setBool checks if the specified environment variable is set to a valid boolean, and if so, assigns it to the provided bool pointer
+func setBool(field *bool, envKey string) {
+	if v, ok := os.LookupEnv(envKey); ok && v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			*field = b
+		}
+	}
+}
+
+// setList checks if the specified environment variable is set and not empty, and if so, assigns its comma-separated items to the provided list
+func setList(field *models.StringList, envKey string) {
+	if v, ok := os.LookupEnv(envKey); ok && v != "" {
+		*field = models.SplitList(v)
+	}
+}
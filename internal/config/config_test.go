@@ -2,7 +2,11 @@ package config
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"netflix-household-validator/internal/models"
 )
 
 func TestLoad(t *testing.T) {
@@ -40,4 +44,290 @@ targetSubject: "Test Subject"
 	if cfg.TargetFrom != "info@test.com" {
 		t.Errorf("Expected targetFrom 'info@test.com', got '%s'", cfg.TargetFrom)
 	}
+
+	if cfg.Authentication.Mode != "enforce" || !cfg.Authentication.ModeDefaulted {
+		t.Errorf("Expected defaulted authentication mode 'enforce', got '%s' (defaulted: %v)",
+			cfg.Authentication.Mode, cfg.Authentication.ModeDefaulted)
+	}
+	cfg = loadFromString(t, "authentication:\n  mode: enforce\n")
+	if cfg.Authentication.ModeDefaulted {
+		t.Error("Expected configured authentication mode not to be reported as defaulted")
+	}
+}
+
+func TestLoad_LegacyConfigBecomesDefaultAccount(t *testing.T) {
+	yamlContent := `email:
+  imap: "imap.test.com:993"
+  login: "test@example.com"
+  password: "testpass"
+targetFrom: "info@test.com"
+targetSubject: "Test Subject"
+`
+
+	cfg := loadFromString(t, yamlContent)
+
+	if len(cfg.Accounts) != 1 {
+		t.Fatalf("Expected 1 account, got %d", len(cfg.Accounts))
+	}
+
+	account := cfg.Accounts[0]
+	if account.Name != "default" {
+		t.Errorf("Expected account name 'default', got '%s'", account.Name)
+	}
+	if account.Email.Imap != "imap.test.com:993" {
+		t.Errorf("Expected imap 'imap.test.com:993', got '%s'", account.Email.Imap)
+	}
+	if account.Email.MailBox != "INBOX" {
+		t.Errorf("Expected default mailbox 'INBOX', got '%s'", account.Email.MailBox)
+	}
+	if account.TargetSubject != "Test Subject" {
+		t.Errorf("Expected targetSubject 'Test Subject', got '%s'", account.TargetSubject)
+	}
+}
+
+func TestLoad_Accounts(t *testing.T) {
+	yamlContent := `targetFrom: "info@test.com"
+targetSubject: "Default Subject"
+accounts:
+  - name: "family"
+    email:
+      imap: "imap.one.com:993"
+      login: "one@example.com"
+      password: "pass1"
+      mailbox: "Netflix"
+  - email:
+      imap: "imap.two.com:993"
+      login: "two@example.com"
+      password: "pass2"
+    targetSubject: "Other Subject"
+`
+
+	cfg := loadFromString(t, yamlContent)
+
+	if len(cfg.Accounts) != 2 {
+		t.Fatalf("Expected 2 accounts, got %d", len(cfg.Accounts))
+	}
+
+	first, second := cfg.Accounts[0], cfg.Accounts[1]
+	if first.Name != "family" || first.Email.MailBox != "Netflix" {
+		t.Errorf("Unexpected first account: %+v", first)
+	}
+	if first.TargetFrom != "info@test.com" || first.TargetSubject != "Default Subject" {
+		t.Errorf("Expected first account to inherit top-level filters, got %+v", first)
+	}
+	if second.Name != "account-2" {
+		t.Errorf("Expected generated name 'account-2', got '%s'", second.Name)
+	}
+	if second.TargetSubject != "Other Subject" {
+		t.Errorf("Expected targetSubject 'Other Subject', got '%s'", second.TargetSubject)
+	}
+
+	scoped := cfg.ForAccount(second)
+	if scoped.Email.Imap != "imap.two.com:993" || scoped.TargetSubject != "Other Subject" {
+		t.Errorf("Unexpected scoped config: %+v", scoped)
+	}
+}
+
+func TestLoad_DuplicateAccountNames(t *testing.T) {
+	yamlContent := `accounts:
+  - name: "same"
+  - name: "same"
+`
+
+	path := writeTempConfig(t, yamlContent)
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for duplicate account names")
+	}
+}
+
+// writeTempConfig writes the YAML content to a temporary file and returns its path
+func writeTempConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write temp file: %v", err)
+	}
+	return path
+}
+
+// loadFromString loads a configuration from the given YAML content
+func loadFromString(t *testing.T, content string) *models.Config {
+	t.Helper()
+
+	cfg, err := Load(writeTempConfig(t, content))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	return cfg
+}
+
+func TestLoad_SubjectSettings(t *testing.T) {
+	yamlContent := `subjectLocales: fr, en
+targetSubjects:
+  - "Custom Subject"
+subjectPatterns:
+  - "(?i)netflix household"
+`
+
+	cfg := loadFromString(t, yamlContent)
+
+	if len(cfg.SubjectLocales) != 2 || cfg.SubjectLocales[0] != "fr" || cfg.SubjectLocales[1] != "en" {
+		t.Errorf("Expected subjectLocales [fr en], got %v", cfg.SubjectLocales)
+	}
+	if len(cfg.TargetSubjects) != 1 || len(cfg.SubjectPatterns) != 1 {
+		t.Errorf("Unexpected subject lists: %v / %v", cfg.TargetSubjects, cfg.SubjectPatterns)
+	}
+}
+
+func TestLoad_InvalidSubjectPattern(t *testing.T) {
+	path := writeTempConfig(t, "subjectPatterns:\n  - \"(\"\n")
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for invalid subject pattern")
+	}
+}
+
+func TestLoad_UnknownSubjectLocale(t *testing.T) {
+	if cfg := loadFromString(t, "subjectLocales: all, PT\n"); len(cfg.SubjectLocales) != 2 {
+		t.Errorf("Expected subjectLocales [all PT], got %v", cfg.SubjectLocales)
+	}
+
+	path := writeTempConfig(t, "subjectLocales: fr, ja\n")
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for a locale missing from the catalog")
+	}
+}
+
+func TestLoad_InvalidReplySecurity(t *testing.T) {
+	path := writeTempConfig(t, "reply:\n  smtp: \"smtp.example.com:587\"\n  security: \"ssl\"\n")
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for invalid reply SMTP security")
+	}
+}
+
+func TestLoad_MaildirFlags(t *testing.T) {
+	cfg := loadFromString(t, "maildir:\n  path: \"/var/mail/Maildir\"\n")
+	if cfg.Accounts[0].Maildir.Path != "/var/mail/Maildir" || cfg.Accounts[0].Maildir.Flags != "S" {
+		t.Errorf("Expected the Maildir of the default account with flags S, got %+v", cfg.Accounts[0].Maildir)
+	}
+
+	path := writeTempConfig(t, "maildir:\n  path: \"/var/mail/Maildir\"\n  flags: \"S,\"\n")
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for invalid Maildir flags")
+	}
+}
+
+func TestLoad_Inbound(t *testing.T) {
+	cfg := loadFromString(t, "recipients: [\"netflix@example.com\"]\ninbound:\n  lmtp: \"unix:/run/lmtp.sock\"\n")
+	if len(cfg.Accounts[0].Recipients) != 1 || cfg.Accounts[0].Recipients[0] != "netflix@example.com" {
+		t.Errorf("Expected the recipients of the default account, got %v", cfg.Accounts[0].Recipients)
+	}
+
+	for _, content := range []string{
+		"inbound:\n  allowedNetworks: [\"10.0.0.0/33\"]\n",
+		"inbound:\n  allowedNetworks: [\"localhost\"]\n",
+		"inbound:\n  tlsCert: \"/etc/cert.pem\"\n",
+	} {
+		if _, err := Load(writeTempConfig(t, content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
+
+func TestLoad_Webhook(t *testing.T) {
+	for _, content := range []string{
+		"webhook:\n  listen: \":8080\"\n",
+		"webhook:\n  listen: \":8080\"\n  sendgrid:\n    username: \"sendgrid\"\n",
+	} {
+		if _, err := Load(writeTempConfig(t, content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+
+	cfg := loadFromString(t, "webhook:\n  listen: \":8080\"\n  cloudflare:\n    secret: \"shared\"\n")
+	if cfg.Webhook.Cloudflare.Secret != "shared" {
+		t.Errorf("Expected the Cloudflare secret, got %+v", cfg.Webhook)
+	}
+}
+
+func TestLoad_POP3(t *testing.T) {
+	cfg := loadFromString(t, "pop3:\n  server: \"pop.example.com:995\"\n")
+	pop3 := cfg.Accounts[0].POP3
+	if pop3.Server != "pop.example.com:995" || pop3.Security != models.SMTPSecurityTLS || pop3.PollSeconds != 60 {
+		t.Errorf("Expected the POP3 server of the default account with defaults, got %+v", pop3)
+	}
+
+	path := writeTempConfig(t, "pop3:\n  server: \"pop.example.com:110\"\n  security: \"ssl\"\n")
+	if _, err := Load(path); err == nil {
+		t.Error("Expected error for invalid POP3 security")
+	}
+}
+
+func TestLoad_OAuth2(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "refresh-token")
+	if err := os.WriteFile(secret, []byte("refresh-from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write secret: %v", err)
+	}
+
+	cfg := loadFromString(t, "email:\n  oauth2:\n    provider: \"google\"\n    clientId: \"client\"\n    refreshTokenFile: \""+secret+"\"\n")
+	oauth := cfg.Accounts[0].Email.OAuth2
+	if oauth.TokenURL != "https://oauth2.googleapis.com/token" || oauth.Mechanism != models.OAuth2MechanismXOAUTH2 ||
+		oauth.RefreshToken != "refresh-from-file" {
+		t.Errorf("Expected the Google endpoint and the refresh token of the file, got %+v", oauth)
+	}
+
+	for _, content := range []string{
+		"email:\n  oauth2:\n    clientId: \"client\"\n    refreshToken: \"refresh\"\n",
+		"email:\n  oauth2:\n    provider: \"microsoft\"\n    clientId: \"client\"\n",
+		"email:\n  oauth2:\n    provider: \"microsoft\"\n    clientId: \"client\"\n    refreshToken: \"refresh\"\n    mechanism: \"plain\"\n",
+	} {
+		if _, err := Load(writeTempConfig(t, content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
+
+func TestLoad_Mailboxes(t *testing.T) {
+	cfg := loadFromString(t, "email:\n  mailbox: \"Archive\"\n")
+	if got := cfg.Accounts[0].Email.Mailboxes; len(got) != 1 || got[0] != "Archive" {
+		t.Errorf("Expected the mailbox to be watched alone, got %v", got)
+	}
+	if cfg.Accounts[0].Email.PollSeconds != defaultIMAPPollSeconds {
+		t.Errorf("Expected default poll interval, got %d", cfg.Accounts[0].Email.PollSeconds)
+	}
+
+	cfg = loadFromString(t, "email:\n  mailboxes: [\"INBOX\", \"\\\\junk\", \"Boîte réception\"]\n")
+	if got := strings.Join(cfg.Accounts[0].Email.Mailboxes, "|"); got != `INBOX|\Junk|Boîte réception` {
+		t.Errorf("Expected the special-use alias to be canonicalized, got %q", got)
+	}
+
+	for _, content := range []string{
+		"email:\n  mailboxes: [\"\\\\Spam\"]\n",
+		"email:\n  mailboxes: [\"\\\\Junk\", \"\\\\JUNK\"]\n",
+	} {
+		if _, err := Load(writeTempConfig(t, content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
+}
+
+func TestLoad_Actions(t *testing.T) {
+	cfg := loadFromString(t, "email:\n  actions:\n    success:\n      move: \"Netflix/Done\"\n    expired:\n      delete: true\n")
+	actions := cfg.Accounts[0].Email.Actions
+	if actions[string(models.OutcomeValidated)].Move != "Netflix/Done" || !actions[string(models.OutcomeExpired)].Delete {
+		t.Errorf("Expected the actions keyed by outcome, got %+v", actions)
+	}
+	if _, ok := actions["success"]; ok {
+		t.Errorf("Expected the browser result name to be replaced, got %+v", actions)
+	}
+
+	for _, content := range []string{
+		"email:\n  actions:\n    unknown:\n      delete: true\n",
+		"email:\n  actions:\n    failed:\n      move: \"Netflix/Failed\"\n      delete: true\n",
+		"email:\n  actions:\n    success:\n      delete: true\n    validated:\n      delete: true\n",
+	} {
+		if _, err := Load(writeTempConfig(t, content)); err == nil {
+			t.Errorf("Expected error for %q", content)
+		}
+	}
 }
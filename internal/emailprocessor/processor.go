@@ -1,12 +1,14 @@
 package emailprocessor
 
 import (
-	"netflix-household-validator/internal/mailparse"
 	"time"
 
-	imapclient "netflix-household-validator/internal/imap"
+	"netflix-household-validator/internal/history"
 	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/mailauth"
+	"netflix-household-validator/internal/mailer"
 	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/notify"
 
 	"netflix-household-validator/internal/netflix"
 )
@@ -17,64 +19,349 @@ const EmailValidityWindow = 15 * time.Minute
 
 // ProcessingStats tracks email processing statistics for a cycle
 type ProcessingStats struct {
-	Total          int
-	Processed      int
-	Ignored        int
-	Failed         int
-	TooOld         int
-	NoMatchingLink int
+	Total           int
+	Processed       int
+	Ignored         int
+	Failed          int
+	TooOld          int
+	NoMatchingLink  int
+	Unauthenticated int
+	DryRun          int
 }
 
 type Processor struct {
-	imapClient     imapclient.Client
+	source         Source
 	netflixService *netflix.Service
+	verifier       *mailauth.Verifier
+	authMode       string
+	history        *history.Store
+	notifier       *notify.Notifier
+	mailer         *mailer.Mailer
+	account        string
+	dryRun         bool
+	actions        map[string]models.MailboxAction
 }
 
-// NewProcessor creates a new Processor instance with the provided IMAP client and Netflix service
-func NewProcessor(imapClient imapclient.Client, netflixService *netflix.Service) *Processor {
-	return &Processor{
-		imapClient:     imapClient,
+// Option configures optional Processor dependencies
+type Option func(*Processor)
+
+// WithVerifier enables the authenticity verification of emails before their link is opened.
+// In models.AuthModeReport mode failures are only logged.
+func WithVerifier(verifier *mailauth.Verifier, mode string) Option {
+	return func(p *Processor) {
+		p.verifier = verifier
+		p.authMode = mode
+	}
+}
+
+// WithAccount sets the account name recorded in the history and notifications
+func WithAccount(name string) Option {
+	return func(p *Processor) {
+		p.account = name
+	}
+}
+
+// WithHistory records every processed email in the history store and skips the links already validated or expired
+func WithHistory(store *history.Store) Option {
+	return func(p *Processor) {
+		p.history = store
+	}
+}
+
+// WithNotifier sends a notification for the outcome of every opened link
+func WithNotifier(notifier *notify.Notifier) Option {
+	return func(p *Processor) {
+		p.notifier = notifier
+	}
+}
+
+// WithMailer emails the outcome of every opened link back to the household member who requested the update
+func WithMailer(m *mailer.Mailer) Option {
+	return func(p *Processor) {
+		p.mailer = m
+	}
+}
+
+// WithDryRun only logs the link that would be opened: the browser is never driven, nothing is recorded or
+// notified and no email is marked as seen
+func WithDryRun(dryRun bool) Option {
+	return func(p *Processor) {
+		p.dryRun = dryRun
+	}
+}
+
+// WithActions applies the mailbox actions configured for the outcome of every message, when the source supports them
+func WithActions(actions map[string]models.MailboxAction) Option {
+	return func(p *Processor) {
+		p.actions = actions
+	}
+}
+
+// NewProcessor creates a new Processor instance with the provided message source and Netflix service.
+// The source may be nil when emails are only given to Process.
+func NewProcessor(source Source, netflixService *netflix.Service, opts ...Option) *Processor {
+	p := &Processor{
+		source:         source,
 		netflixService: netflixService,
 	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
 // ProcessEmail orchestrates the complete email processing workflow:
-// fetch → parse → validate age → handle → mark as seen
-// Returns a boolean indicating if the email was successfully handled and should update stats
-func (p *Processor) ProcessEmail(uid uint32) (handled bool, ignored bool, err error) {
-	// Fetch message from IMAP
-	msg, err := p.imapClient.FetchMessage(uid)
+// screen envelope → fetch → parse → validate age → verify authenticity → filter → check history → open link → record → mark as processed
+// → mailbox action
+// In dry-run mode the link is only logged and nothing is recorded, marked as processed or moved.
+// Returns the outcome of the email, used to update stats
+func (p *Processor) ProcessEmail(id string) (models.Outcome, error) {
+	// Screen the envelope first when the source can, so that unrelated emails are never downloaded
+	if screener, ok := p.source.(Screener); ok {
+		envelope, err := screener.FetchEnvelope(id)
+		if err != nil {
+			logging.Log.WithField("trace_id", "unknown").Errorf("Error fetching envelope of email %s: %v", id, err)
+			return "", err
+		}
+		if outcome := p.screen(envelope); outcome != "" {
+			p.mark(id, envelope, outcome)
+			return outcome, nil
+		}
+	}
+
+	// Fetch the message from the source and parse it to the normalized structure
+	email, err := p.source.Fetch(id)
 	if err != nil {
-		return false, false, err
+		logging.Log.WithField("trace_id", "unknown").Errorf("Error fetching email %s: %v", id, err)
+		return "", err
+	}
+
+	outcome := p.Process(email)
+	p.mark(id, email, outcome)
+
+	return outcome, nil
+}
+
+// screen returns the outcome of an email that cannot match according to its envelope, or "" when it must be
+// processed in full
+func (p *Processor) screen(envelope *models.Email) models.Outcome {
+	locallog := logging.Log.WithField("trace_id", envelope.TraceID)
+
+	if !p.isEmailValid(envelope) {
+		locallog.Infof("Message is older than %v (date: %v), skipping", EmailValidityWindow, envelope.InternalDate)
+		return models.OutcomeTooOld
+	}
+	outcome := p.netflixService.Screen(envelope.From, envelope.Subject)
+	if outcome != "" {
+		locallog.Infof("Email from %s screened out (%s): %s", envelope.From, outcome, envelope.Subject)
+	}
+	return outcome
+}
+
+// mark marks the message as processed only if successfully handled (or records any outcome) and applies its
+// mailbox action, never in dry-run mode
+func (p *Processor) mark(id string, email *models.Email, outcome models.Outcome) {
+	if p.dryRun {
+		return
 	}
 
-	// Parse email to normalized structure
-	email, err := mailparse.Parse(msg)
+	var err error
+	if marker, ok := p.source.(OutcomeMarker); ok {
+		err = marker.MarkOutcome(id, outcome)
+	} else if outcome.Handled() {
+		err = p.source.MarkProcessed(id)
+	}
 	if err != nil {
-		logging.Log.WithField("trace_id", "unknown").Errorf("Error parsing email UID %d: %v", uid, err)
-		return false, false, err
+		logging.Log.WithField("trace_id", email.TraceID).Errorf("Error marking message %s as processed: %v", id, err)
+	}
+	p.applyAction(id, email, outcome)
+}
+
+// applyAction applies the mailbox action configured for the outcome, if any
+func (p *Processor) applyAction(id string, email *models.Email, outcome models.Outcome) {
+	action, ok := p.actions[string(outcome)]
+	if !ok {
+		return
+	}
+	actor, ok := p.source.(MailboxActor)
+	if !ok {
+		return
 	}
 
+	locallog := logging.Log.WithField("trace_id", email.TraceID)
+	if err := actor.ApplyAction(id, action); err != nil {
+		locallog.Errorf("Error applying the %s mailbox action to message %s: %v", outcome, id, err)
+		return
+	}
+	locallog.Infof("Mailbox action applied to message %s (%s)", id, outcome)
+}
+
+// Result is the outcome of a processed email with the decisions it results from
+type Result struct {
+	Outcome models.Outcome
+	// Reason explains the decision of the filters
+	Reason string
+	// Link is the update link selected by the filters, if any
+	Link string
+	// Authentication is the result of the authenticity verification, nil when it is disabled
+	Authentication *mailauth.Result
+}
+
+// Process runs the workflow on an already parsed email, whatever its source:
+// validate age → verify authenticity → filter → check history → open link → record
+func (p *Processor) Process(email *models.Email) models.Outcome {
+	return p.ProcessDetailed(email).Outcome
+}
+
+// ProcessDetailed runs Process and also returns the decisions taken on the way, e.g. to replay saved emails
+func (p *Processor) ProcessDetailed(email *models.Email) Result {
 	locallog := logging.Log.WithField("trace_id", email.TraceID)
 
 	// Validate email age (15 minutes window)
 	if !p.isEmailValid(email) {
-		locallog.Infof("Message UID %d is older than %v (date: %v), skipping", uid, EmailValidityWindow, email.InternalDate)
-		return false, false, nil
+		locallog.Infof("Message is older than %v (date: %v), skipping", EmailValidityWindow, email.InternalDate)
+		return Result{Outcome: models.OutcomeTooOld}
 	}
 
-	// Handle email with Netflix service (filters, browser automation)
-	handled = p.netflixService.HandleEmail(email)
+	// Verify the email really comes from Netflix before any link is opened
+	authentic, auth := p.isAuthentic(email)
+	if !authentic {
+		p.record(email, "", models.OutcomeUnauthenticated)
+		return Result{Outcome: models.OutcomeUnauthenticated, Reason: "email not authenticated", Authentication: auth}
+	}
 
-	// Mark as seen only if successfully handled
-	if handled {
-		if err := p.imapClient.MarkSeen(uid); err != nil {
-			locallog.Errorf("Error marking message UID %d as seen: %v", uid, err)
+	// Apply the Netflix filters and select the update link
+	decision := p.netflixService.Evaluate(email)
+
+	var previous *history.Entry
+	if decision.Link != "" {
+		previous = p.lookup(email, decision.Link)
+	}
+
+	var outcome models.Outcome
+	switch {
+	case decision.Link == "":
+		outcome = decision.Outcome
+	case p.alreadyHandled(email, previous):
+		outcome = models.OutcomeDuplicate
+	case p.dryRun:
+		locallog.Infof("Dry run: would open %s for %s", netflix.SanitizeURL(decision.Link), email.ToPrimary)
+		outcome = models.OutcomeDryRun
+	default:
+		// Open the link with the browser
+		outcome = p.netflixService.Validate(email, decision.Link)
+		// Failures are tried again within the validity window: they are only notified and replied once per outcome
+		if previous != nil && previous.Outcome == outcome {
+			locallog.Infof("Link already %s on %v, not notifying nor replying again", outcome, previous.LastSeen)
+		} else {
+			p.notify(email, outcome)
+			p.mailer.Send(email, outcome)
 		}
-		return true, false, nil
 	}
 
-	return false, true, nil
+	if outcome != models.OutcomeDuplicate {
+		p.record(email, decision.Link, outcome)
+	}
+
+	return Result{Outcome: outcome, Reason: decision.Reason, Link: decision.Link, Authentication: auth}
+}
+
+// lookup returns the history entry of the link, or nil when there is none
+func (p *Processor) lookup(email *models.Email, link string) *history.Entry {
+	if p.history == nil {
+		return nil
+	}
+
+	entry, err := p.history.Lookup(history.LinkKey(link))
+	if err != nil {
+		logging.Log.WithField("trace_id", email.TraceID).WithError(err).Error("History lookup failed")
+		return nil
+	}
+	return entry
+}
+
+// alreadyHandled reports whether the link token was already validated or expired according to its history entry,
+// which happens when the email could not be marked as seen after it was handled. Aborted links are not opened
+// again either: Netflix asks to log in whatever the number of attempts.
+func (p *Processor) alreadyHandled(email *models.Email, entry *history.Entry) bool {
+	if entry == nil || !entry.Outcome.Handled() && entry.Outcome != models.OutcomeAborted {
+		return false
+	}
+
+	logging.Log.WithField("trace_id", email.TraceID).Infof("Link already handled on %v (outcome: %s, trace_id: %s), skipping",
+		entry.LastSeen, entry.Outcome, entry.TraceID)
+	return true
+}
+
+// record stores the outcome in the history, keyed by the link token or, without link, by the Message-ID.
+// Emails that are not from Netflix at all, and dry runs, are not recorded.
+func (p *Processor) record(email *models.Email, link string, outcome models.Outcome) {
+	if p.history == nil || p.dryRun || outcome == models.OutcomeWrongSender || outcome == models.OutcomeWrongSubject {
+		return
+	}
+
+	entry := history.Entry{
+		Account:   p.account,
+		MessageID: email.MessageID,
+		Recipient: email.ToPrimary,
+		Outcome:   outcome,
+		TraceID:   email.TraceID,
+	}
+	if email.Mailbox != "" {
+		entry.Mailbox, entry.UID, entry.UIDValidity = email.Mailbox, email.UID, email.UIDValidity
+	}
+	if link != "" {
+		entry.TokenHash = history.TokenHash(link)
+		entry.Key = history.LinkKey(link)
+	} else {
+		entry.Key = history.MessageKey(email.MessageID)
+	}
+	if entry.Key == "" {
+		return
+	}
+
+	if err := p.history.Record(entry); err != nil {
+		logging.Log.WithField("trace_id", email.TraceID).WithError(err).Error("History record failed")
+	}
+}
+
+// notify sends the notification matching the outcome of an opened link
+func (p *Processor) notify(email *models.Email, outcome models.Outcome) {
+	eventType, ok := notify.EventForOutcome(outcome)
+	if !ok {
+		return
+	}
+	p.notifier.Notify(notify.Event{
+		Type:      eventType,
+		Account:   p.account,
+		Recipient: email.ToPrimary,
+		TraceID:   email.TraceID,
+		Outcome:   outcome,
+	})
+}
+
+// isAuthentic runs the authenticity verification, if enabled, and returns its result. In report mode failures are
+// logged but not enforced.
+func (p *Processor) isAuthentic(email *models.Email) (bool, *mailauth.Result) {
+	if p.verifier == nil || p.authMode == models.AuthModeOff {
+		return true, nil
+	}
+
+	locallog := logging.Log.WithField("trace_id", email.TraceID)
+
+	result := p.verifier.Verify(email)
+	if result.Pass {
+		locallog.WithField("auth_method", result.Method).Infof("Email authenticated for %s", result.Domain)
+		return true, &result
+	}
+
+	if p.authMode == models.AuthModeReport {
+		locallog.Warnf("Email authentication failed (report mode, continuing): %s", result.Reason)
+		return true, &result
+	}
+
+	locallog.Warnf("Email authentication failed, skipping: %s", result.Reason)
+	return false, &result
 }
 
 // isEmailValid checks if email is within the validity window (15 minutes)
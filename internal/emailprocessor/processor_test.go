@@ -1,10 +1,27 @@
 package emailprocessor
 
 import (
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"netflix-household-validator/internal/history"
+	imapclient "netflix-household-validator/internal/imap"
+	"netflix-household-validator/internal/mailauth"
+	"netflix-household-validator/internal/mailer"
+	"netflix-household-validator/internal/mailparse"
 	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/netflix"
+	"netflix-household-validator/internal/notify"
+
+	"github.com/emersion/go-imap"
 )
 
 func TestIsEmailValid(t *testing.T) {
@@ -66,4 +83,508 @@ func TestIsEmailValid(t *testing.T) {
 	}
 }
 
+func TestIsAuthentic(t *testing.T) {
+	unsigned := &models.Email{
+		Raw:     []byte("From: info@account.netflix.com\r\nSubject: Test\r\n\r\nbody\r\n"),
+		TraceID: "test-trace",
+	}
+	verifier := mailauth.NewVerifier(models.AuthConfig{Domain: "netflix.com"}, noRecords{})
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected bool
+	}{
+		{name: "No verifier", expected: true},
+		{name: "Enforce", opts: []Option{WithVerifier(verifier, models.AuthModeEnforce)}, expected: false},
+		{name: "Report", opts: []Option{WithVerifier(verifier, models.AuthModeReport)}, expected: true},
+		{name: "Off", opts: []Option{WithVerifier(verifier, models.AuthModeOff)}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewProcessor(nil, nil, tt.opts...)
+			if got, _ := p.isAuthentic(unsigned); got != tt.expected {
+				t.Errorf("isAuthentic() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+// noRecords is a mailauth.Resolver without any DNS record
+type noRecords struct{}
+
+func (noRecords) LookupTXT(domain string) ([]string, error) {
+	return nil, errors.New("no such host " + domain)
+}
+
+// fakeIMAPClient serves a single raw message and can be made to fail AddFlags
+type fakeIMAPClient struct {
+	imapclient.Client
+	raw         string
+	keywords    bool
+	markSeenErr error
+	seen        []uint32
+	flags       []string
+	actions     []string
+	fetched     int
+	rejected    []uint32
+	uidValidity uint32
+}
+
+func (c *fakeIMAPClient) UIDValidity() uint32 {
+	return c.uidValidity
+}
+
+func (c *fakeIMAPClient) FetchEnvelope(uid uint32) (*imap.Message, error) {
+	email, err := mailparse.ParseRaw([]byte(strings.ReplaceAll(c.raw, "\n", "\r\n")))
+	if err != nil {
+		return nil, err
+	}
+	address := func(addr string) *imap.Address {
+		mailbox, host, _ := strings.Cut(addr, "@")
+		return &imap.Address{MailboxName: mailbox, HostName: host}
+	}
+
+	msg := imap.NewMessage(uid, nil)
+	msg.Uid = uid
+	msg.Envelope = &imap.Envelope{
+		Subject:   email.Subject,
+		From:      []*imap.Address{address(email.From)},
+		To:        []*imap.Address{address(email.ToPrimary)},
+		MessageId: "<" + email.MessageID + ">",
+	}
+	return msg, nil
+}
+
+func (c *fakeIMAPClient) RejectUID(uid uint32) {
+	c.rejected = append(c.rejected, uid)
+}
+
+func (c *fakeIMAPClient) Move(uid uint32, folder string) error {
+	c.actions = append(c.actions, "move "+folder)
+	return nil
+}
+
+func (c *fakeIMAPClient) Copy(uid uint32, folder string) error {
+	c.actions = append(c.actions, "copy "+folder)
+	return nil
+}
+
+func (c *fakeIMAPClient) Delete(uid uint32) error {
+	c.actions = append(c.actions, "delete")
+	return nil
+}
+
+func (c *fakeIMAPClient) AddLabels(uid uint32, labels ...string) error {
+	c.actions = append(c.actions, "label "+strings.Join(labels, ","))
+	return nil
+}
+
+func (c *fakeIMAPClient) SupportsKeywords() bool {
+	return c.keywords
+}
+
+func (c *fakeIMAPClient) FetchMessage(uid uint32) (*imap.Message, error) {
+	c.fetched++
+	msg := imap.NewMessage(uid, nil)
+	msg.Uid = uid
+	msg.Body = map[*imap.BodySectionName]imap.Literal{
+		{}: bytes.NewBufferString(strings.ReplaceAll(c.raw, "\n", "\r\n")),
+	}
+	return msg, nil
+}
+
+func (c *fakeIMAPClient) AddFlags(uid uint32, flags ...string) error {
+	for _, flag := range flags {
+		if flag == imap.SeenFlag {
+			c.seen = append(c.seen, uid)
+		}
+	}
+	c.flags = append(c.flags, flags...)
+	return c.markSeenErr
+}
+
+// countingBrowser counts the links opened
+type countingBrowser struct {
+	opened int
+}
+
+func (b *countingBrowser) OpenUpdatePrimaryLocation(_, _ string) (models.BrowserResult, error) {
+	b.opened++
+	return models.ResultSuccess, nil
+}
+
+func TestProcessEmail_SkipsLinkAlreadyHandled(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("history.Open() error = %v", err)
+	}
+	defer store.Close()
+
+	client := &fakeIMAPClient{
+		raw: `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: Test Subject
+Message-ID: <abc@netflix.com>
+Content-Type: text/plain; charset=utf-8
+
+https://www.netflix.com/account/update-primary-location?nftoken=TOKEN
+`,
+		markSeenErr: errors.New("connection reset"),
+	}
+	browser := &countingBrowser{}
+	service := netflix.NewService(browser, &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	})
+	client.uidValidity = 1700000000
+	processor := NewProcessor(IMAPSource(client, IMAPOptions{Mailbox: "imap://user@imap.example.com/INBOX"}), service,
+		WithAccount("default"), WithHistory(store))
+
+	outcome, err := processor.ProcessEmail("1")
+	if err != nil || outcome != models.OutcomeValidated {
+		t.Fatalf("ProcessEmail() = %v, %v, want %v", outcome, err, models.OutcomeValidated)
+	}
+
+	// MarkSeen failed: the email comes back on the next cycle but the link must not be opened again
+	outcome, err = processor.ProcessEmail("1")
+	if err != nil || outcome != models.OutcomeDuplicate {
+		t.Fatalf("ProcessEmail() = %v, %v, want %v", outcome, err, models.OutcomeDuplicate)
+	}
+	if browser.opened != 1 {
+		t.Errorf("browser opened %d link(s), want 1", browser.opened)
+	}
+	if len(client.seen) != 2 {
+		t.Errorf("MarkSeen called %d time(s), want 2", len(client.seen))
+	}
+
+	entry, err := store.Lookup(history.LinkKey("https://www.netflix.com/account/update-primary-location?nftoken=TOKEN"))
+	if err != nil || entry == nil {
+		t.Fatalf("Lookup() = %v, %v, want entry", entry, err)
+	}
+	if entry.MessageID != "abc@netflix.com" || entry.Recipient != "user@example.com" || entry.Account != "default" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.Mailbox != "imap://user@imap.example.com/INBOX" || entry.UID != 1 || entry.UIDValidity != 1700000000 {
+		t.Errorf("entry = %+v, want the IMAP identifiers of the message", entry)
+	}
+}
+
+// resultBrowser returns the same result for every link
+type resultBrowser struct {
+	result models.BrowserResult
+	opened int
+}
+
+func (b *resultBrowser) OpenUpdatePrimaryLocation(_, _ string) (models.BrowserResult, error) {
+	b.opened++
+	return b.result, nil
+}
+
+func TestProcess_NotifiesOncePerLink(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("history.Open() error = %v", err)
+	}
+	defer store.Close()
+
+	// No SMTP server listens: the replies fail, but are still recorded
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	_ = l.Close()
+	m, err := mailer.New("default", &models.Config{
+		Email: models.EmailConfig{Login: "validator@example.com", Password: "secret"},
+		Reply: models.ReplyConfig{SMTP: l.Addr().String(), Security: models.SMTPSecurityNone},
+	}, store, nil)
+	if err != nil {
+		t.Fatalf("mailer.New() error = %v", err)
+	}
+
+	var notifications atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications.Add(1)
+	}))
+	defer server.Close()
+	notifier, err := notify.New(models.NotificationsConfig{Targets: []models.NotificationTarget{
+		{URL: "json://" + strings.TrimPrefix(server.URL, "http://")},
+	}})
+	if err != nil {
+		t.Fatalf("notify.New() error = %v", err)
+	}
 
+	tests := []struct {
+		name       string
+		result     models.BrowserResult
+		want       []models.Outcome
+		wantOpened int
+	}{
+		{"aborted", models.ResultAbort, []models.Outcome{models.OutcomeAborted, models.OutcomeDuplicate}, 1},
+		{"failed", models.ResultFailed, []models.Outcome{models.OutcomeFailed, models.OutcomeFailed}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := mailparse.ParseRaw([]byte("From: Netflix <info@account.netflix.com>\r\nTo: user@example.com\r\n" +
+				"Subject: Test Subject\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" +
+				"https://www.netflix.com/account/update-primary-location?nftoken=" + tt.name + "\r\n"))
+			if err != nil {
+				t.Fatalf("ParseRaw() error = %v", err)
+			}
+			browser := &resultBrowser{result: tt.result}
+			service := netflix.NewService(browser, &models.Config{
+				TargetFrom:    "info@account.netflix.com",
+				TargetSubject: "Test Subject",
+			})
+			notifications.Store(0)
+			processor := NewProcessor(nil, service, WithHistory(store), WithMailer(m), WithNotifier(notifier))
+
+			for i, want := range tt.want {
+				if outcome := processor.Process(email); outcome != want {
+					t.Errorf("Process() #%d = %v, want %v", i+1, outcome, want)
+				}
+			}
+			m.Close()
+			notifier.Close()
+
+			if browser.opened != tt.wantOpened {
+				t.Errorf("browser opened %d link(s), want %d", browser.opened, tt.wantOpened)
+			}
+			replies, err := store.Replies(email.TraceID)
+			if err != nil {
+				t.Fatalf("Replies() error = %v", err)
+			}
+			if len(replies) != 1 {
+				t.Errorf("Replies() = %+v, want 1", replies)
+			}
+			if n := notifications.Load(); n != 1 {
+				t.Errorf("sent %d notification(s), want 1", n)
+			}
+		})
+	}
+}
+
+func TestProcessEmail_DryRun(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("history.Open() error = %v", err)
+	}
+	defer store.Close()
+
+	link := "https://www.netflix.com/account/update-primary-location?nftoken=TOKEN"
+	client := &fakeIMAPClient{
+		raw: `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: Test Subject
+Message-ID: <abc@netflix.com>
+Content-Type: text/plain; charset=utf-8
+
+` + link + `
+`,
+	}
+	browser := &countingBrowser{}
+	service := netflix.NewService(browser, &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	})
+	processor := NewProcessor(IMAPSource(client, IMAPOptions{}), service, WithHistory(store), WithDryRun(true))
+
+	outcome, err := processor.ProcessEmail("1")
+	if err != nil || outcome != models.OutcomeDryRun {
+		t.Fatalf("ProcessEmail() = %v, %v, want %v", outcome, err, models.OutcomeDryRun)
+	}
+	if browser.opened != 0 {
+		t.Errorf("browser opened %d link(s), want 0", browser.opened)
+	}
+	if len(client.seen) != 0 {
+		t.Errorf("MarkSeen called %d time(s), want 0", len(client.seen))
+	}
+	if entry, err := store.Lookup(history.LinkKey(link)); err != nil || entry != nil {
+		t.Errorf("Lookup() = %v, %v, want no entry", entry, err)
+	}
+
+	// A link already validated is reported as duplicate but still not marked as seen
+	if err := store.Record(history.Entry{Key: history.LinkKey(link), Outcome: models.OutcomeValidated}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	outcome, err = processor.ProcessEmail("1")
+	if err != nil || outcome != models.OutcomeDuplicate {
+		t.Fatalf("ProcessEmail() = %v, %v, want %v", outcome, err, models.OutcomeDuplicate)
+	}
+	if len(client.seen) != 0 {
+		t.Errorf("MarkSeen called %d time(s), want 0", len(client.seen))
+	}
+}
+
+func TestProcessDetailed(t *testing.T) {
+	link := "https://www.netflix.com/account/update-primary-location?nftoken=TOKEN"
+	email, err := mailparse.ParseRaw([]byte("From: Netflix <info@account.netflix.com>\r\nTo: user@example.com\r\n" +
+		"Subject: Test Subject\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + link + "\r\n"))
+	if err != nil {
+		t.Fatalf("ParseRaw() error = %v", err)
+	}
+	browser := &countingBrowser{}
+	service := netflix.NewService(browser, &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	})
+	verifier := mailauth.NewVerifier(models.AuthConfig{Domain: "netflix.com"}, noRecords{})
+
+	result := NewProcessor(nil, service, WithDryRun(true)).ProcessDetailed(email)
+	if result.Outcome != models.OutcomeDryRun || result.Link != link || result.Authentication != nil {
+		t.Errorf("ProcessDetailed() = %+v, want the dry-run link without authentication", result)
+	}
+
+	result = NewProcessor(nil, service, WithDryRun(true), WithVerifier(verifier, models.AuthModeReport)).ProcessDetailed(email)
+	if result.Outcome != models.OutcomeDryRun || result.Authentication == nil || result.Authentication.Pass {
+		t.Errorf("ProcessDetailed() = %+v, want the dry-run link with the failed authentication", result)
+	}
+
+	result = NewProcessor(nil, service, WithDryRun(true), WithVerifier(verifier, models.AuthModeEnforce)).ProcessDetailed(email)
+	if result.Outcome != models.OutcomeUnauthenticated || result.Link != "" || result.Authentication == nil {
+		t.Errorf("ProcessDetailed() = %+v, want unauthenticated", result)
+	}
+	if browser.opened != 0 {
+		t.Errorf("browser opened %d link(s), want 0", browser.opened)
+	}
+}
+
+func TestProcessEmail_Keywords(t *testing.T) {
+	netflixEmail := `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: Test Subject
+Message-ID: <abc@netflix.com>
+Content-Type: text/plain; charset=utf-8
+
+https://www.netflix.com/account/update-primary-location?nftoken=TOKEN
+`
+	otherEmail := `From: Friend <friend@example.com>
+To: user@example.com
+Subject: Dinner
+Content-Type: text/plain; charset=utf-8
+
+See you tonight
+`
+
+	tests := []struct {
+		name      string
+		raw       string
+		keywords  bool
+		markSeen  bool
+		wantFlags []string
+	}{
+		{"validated", netflixEmail, true, false, []string{imapclient.KeywordValidated}},
+		{"validated and seen", netflixEmail, true, true, []string{imapclient.KeywordValidated, imap.SeenFlag}},
+		{"skipped, never seen", otherEmail, true, true, []string{imapclient.KeywordSkipped}},
+		{"fallback to seen", netflixEmail, false, false, []string{imap.SeenFlag}},
+		{"fallback, other email untouched", otherEmail, false, false, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeIMAPClient{raw: tt.raw, keywords: tt.keywords}
+			service := netflix.NewService(&countingBrowser{}, &models.Config{
+				TargetFrom:    "info@account.netflix.com",
+				TargetSubject: "Test Subject",
+			})
+			processor := NewProcessor(IMAPSource(client, IMAPOptions{MarkSeen: tt.markSeen}), service)
+
+			if _, err := processor.ProcessEmail("1"); err != nil {
+				t.Fatalf("ProcessEmail() error = %v", err)
+			}
+			if strings.Join(client.flags, " ") != strings.Join(tt.wantFlags, " ") {
+				t.Errorf("flags = %v, want %v", client.flags, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestProcessEmail_Actions(t *testing.T) {
+	client := &fakeIMAPClient{
+		raw: `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: Test Subject
+Message-ID: <abc@netflix.com>
+Content-Type: text/plain; charset=utf-8
+
+https://www.netflix.com/account/update-primary-location?nftoken=TOKEN
+`,
+	}
+	service := netflix.NewService(&countingBrowser{}, &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	})
+	actions := map[string]models.MailboxAction{
+		string(models.OutcomeValidated): {Labels: []string{"Netflix"}, Copy: "Archive", Move: "Netflix/Done"},
+		string(models.OutcomeExpired):   {Delete: true},
+	}
+
+	processor := NewProcessor(IMAPSource(client, IMAPOptions{}), service, WithActions(actions), WithDryRun(true))
+	if _, err := processor.ProcessEmail("1"); err != nil {
+		t.Fatalf("ProcessEmail() error = %v", err)
+	}
+	if len(client.actions) != 0 {
+		t.Errorf("actions in dry-run mode = %v, want none", client.actions)
+	}
+
+	processor = NewProcessor(IMAPSource(client, IMAPOptions{}), service, WithActions(actions))
+	if outcome, err := processor.ProcessEmail("1"); err != nil || outcome != models.OutcomeValidated {
+		t.Fatalf("ProcessEmail() = %v, %v, want %v", outcome, err, models.OutcomeValidated)
+	}
+	if got := strings.Join(client.actions, "; "); got != "label Netflix; copy Archive; move Netflix/Done" {
+		t.Errorf("actions = %q", got)
+	}
+}
+
+func TestProcessEmail_Screening(t *testing.T) {
+	service := netflix.NewService(&countingBrowser{}, &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	})
+
+	tests := []struct {
+		name         string
+		raw          string
+		want         models.Outcome
+		wantFetched  int
+		wantRejected int
+	}{
+		{"unrelated sender", `From: Friend <friend@example.com>
+To: user@example.com
+Subject: Dinner
+Content-Type: text/plain; charset=utf-8
+
+See you tonight
+`, models.OutcomeWrongSender, 0, 1},
+		{"other Netflix email", `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: New on Netflix
+Content-Type: text/plain; charset=utf-8
+
+Watch now
+`, models.OutcomeWrongSubject, 0, 1},
+		{"household email", `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: Test Subject
+Message-ID: <abc@netflix.com>
+Content-Type: text/plain; charset=utf-8
+
+https://www.netflix.com/account/update-primary-location?nftoken=TOKEN
+`, models.OutcomeValidated, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeIMAPClient{raw: tt.raw, keywords: true}
+			processor := NewProcessor(IMAPSource(client, IMAPOptions{}), service)
+
+			outcome, err := processor.ProcessEmail("1")
+			if err != nil || outcome != tt.want {
+				t.Fatalf("ProcessEmail() = %v, %v, want %v", outcome, err, tt.want)
+			}
+			if client.fetched != tt.wantFetched || len(client.rejected) != tt.wantRejected {
+				t.Errorf("fetched %d time(s), rejected %v, want %d fetch(es) and %d rejection(s)",
+					client.fetched, client.rejected, tt.wantFetched, tt.wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package emailprocessor
+
+import (
+	"fmt"
+	"strconv"
+
+	imapclient "netflix-household-validator/internal/imap"
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/models"
+
+	"github.com/emersion/go-imap"
+)
+
+// Source is a mailbox the processor reads messages from. Messages are identified by an opaque ID
+// (an IMAP UID, a Maildir file name...).
+type Source interface {
+	// Fetch returns the parsed message with the given ID
+	Fetch(id string) (*models.Email, error)
+	// MarkProcessed flags the message as handled (e.g. \Seen), so that it is not processed again
+	MarkProcessed(id string) error
+}
+
+// OutcomeMarker is implemented by the sources that record the outcome of every message, not only of those handled.
+// The processor then calls MarkOutcome instead of MarkProcessed.
+type OutcomeMarker interface {
+	MarkOutcome(id string, outcome models.Outcome) error
+}
+
+// MailboxActor is implemented by the sources that can apply the mailbox actions configured for an outcome
+type MailboxActor interface {
+	ApplyAction(id string, action models.MailboxAction) error
+}
+
+// Screener is implemented by the sources that can read the envelope of a message (sender, subject, dates) without
+// downloading it. The processor then screens the message before fetching it.
+type Screener interface {
+	FetchEnvelope(id string) (*models.Email, error)
+}
+
+// IMAPOptions configures an IMAP source
+type IMAPOptions struct {
+	// Mailbox identifies the selected mailbox in the history (e.g. imap://login@server/INBOX)
+	Mailbox string
+	// MarkSeen also adds \Seen to the handled messages when the mailbox allows keywords
+	MarkSeen bool
+	// TextOnly only downloads the header and the text parts of multipart messages, leaving attachments on the
+	// server. The whole message is needed to verify DKIM signatures.
+	TextOnly bool
+}
+
+// imapSource reads the messages of the selected mailbox of an IMAP client
+type imapSource struct {
+	client   imapclient.Client
+	keywords bool
+	opts     IMAPOptions
+	// structures and traces are the body structures and TraceIDs read with the envelopes, by UID
+	structures map[uint32]*imap.BodyStructure
+	traces     map[uint32]string
+}
+
+// IMAPSource returns the Source of the mailbox selected on the IMAP client, with the UIDs as IDs. When the mailbox
+// allows keywords, the outcome of every message is recorded with a keyword ($NfxValidated...) and \Seen is only
+// added to the handled messages with MarkSeen. Otherwise the handled messages are marked as seen.
+func IMAPSource(client imapclient.Client, opts IMAPOptions) Source {
+	return &imapSource{
+		client:     client,
+		keywords:   client.SupportsKeywords(),
+		opts:       opts,
+		structures: make(map[uint32]*imap.BodyStructure),
+		traces:     make(map[uint32]string),
+	}
+}
+
+// FetchEnvelope implements Screener
+func (s *imapSource) FetchEnvelope(id string) (*models.Email, error) {
+	uid, err := parseUID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := s.client.FetchEnvelope(uid)
+	if err != nil {
+		return nil, err
+	}
+	s.structures[uid] = msg.BodyStructure
+	email, err := s.identify(mailparse.ParseEnvelope(msg))
+	if err != nil {
+		return nil, err
+	}
+	s.traces[uid] = email.TraceID
+	return email, nil
+}
+
+// Fetch implements Source: with TextOnly, only the text parts of the multipart messages whose structure was read
+// with their envelope are downloaded. The email keeps the TraceID of its envelope.
+func (s *imapSource) Fetch(id string) (*models.Email, error) {
+	uid, err := parseUID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.opts.TextOnly {
+		if paths, ok := mailparse.TextParts(s.structures[uid]); ok {
+			msg, err := s.client.FetchParts(uid, paths)
+			if err != nil {
+				return nil, err
+			}
+			return s.identify(mailparse.ParseParts(msg, paths, s.traces[uid]))
+		}
+	}
+
+	msg, err := s.client.FetchMessage(uid)
+	if err != nil {
+		return nil, err
+	}
+	return s.identify(mailparse.Parse(msg, s.traces[uid]))
+}
+
+// identify records the mailbox and UIDVALIDITY of a parsed email, so that its UID identifies it in the history
+func (s *imapSource) identify(email *models.Email, err error) (*models.Email, error) {
+	if err != nil {
+		return nil, err
+	}
+	email.Mailbox = s.opts.Mailbox
+	email.UIDValidity = s.client.UIDValidity()
+	return email, nil
+}
+
+// MarkProcessed implements Source
+func (s *imapSource) MarkProcessed(id string) error {
+	uid, err := parseUID(id)
+	if err != nil {
+		return err
+	}
+	if !s.keywords {
+		return s.client.AddFlags(uid, imap.SeenFlag)
+	}
+	return s.MarkOutcome(id, models.OutcomeDuplicate)
+}
+
+// MarkOutcome implements OutcomeMarker
+func (s *imapSource) MarkOutcome(id string, outcome models.Outcome) error {
+	uid, err := parseUID(id)
+	if err != nil {
+		return err
+	}
+	if rejects(outcome) {
+		s.client.RejectUID(uid)
+	}
+
+	var flags []string
+	if s.keywords {
+		flags = append(flags, outcomeKeyword(outcome))
+	}
+	if outcome.Handled() && (s.opts.MarkSeen || !s.keywords) {
+		flags = append(flags, imap.SeenFlag)
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	return s.client.AddFlags(uid, flags...)
+}
+
+// rejects reports whether the outcome would be the same if the message was processed again
+func rejects(outcome models.Outcome) bool {
+	switch outcome {
+	case models.OutcomeWrongSender, models.OutcomeUntrustedForwarder, models.OutcomeWrongSubject, models.OutcomeEmptyBody,
+		models.OutcomeNoLink, models.OutcomeRejectedLink, models.OutcomeTooOld, models.OutcomeUnauthenticated,
+		models.OutcomeAborted:
+		return true
+	}
+	return false
+}
+
+// ApplyAction implements MailboxActor: labels are added, then the message is copied, then moved or deleted
+func (s *imapSource) ApplyAction(id string, action models.MailboxAction) error {
+	uid, err := parseUID(id)
+	if err != nil {
+		return err
+	}
+
+	if len(action.Labels) > 0 {
+		if err := s.client.AddLabels(uid, action.Labels...); err != nil {
+			return fmt.Errorf("labels: %w", err)
+		}
+	}
+	if action.Copy != "" {
+		if err := s.client.Copy(uid, action.Copy); err != nil {
+			return fmt.Errorf("copy to %s: %w", action.Copy, err)
+		}
+	}
+	switch {
+	case action.Move != "":
+		if err := s.client.Move(uid, action.Move); err != nil {
+			return fmt.Errorf("move to %s: %w", action.Move, err)
+		}
+	case action.Delete:
+		if err := s.client.Delete(uid); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+	return nil
+}
+
+// outcomeKeyword returns the keyword recording an outcome. Messages that are not Netflix household emails, or that
+// cannot be handled (e.g. aborted because Netflix asks to log in), are skipped; failures are tried again within the
+// validity window.
+func outcomeKeyword(outcome models.Outcome) string {
+	switch outcome {
+	case models.OutcomeValidated:
+		return imapclient.KeywordValidated
+	case models.OutcomeExpired:
+		return imapclient.KeywordExpired
+	case models.OutcomeFailed, models.OutcomeBrowserError:
+		return imapclient.KeywordFailed
+	default:
+		return imapclient.KeywordSkipped
+	}
+}
+
+// IMAPIDs converts IMAP UIDs to Source IDs
+func IMAPIDs(uids []uint32) []string {
+	ids := make([]string, len(uids))
+	for i, uid := range uids {
+		ids[i] = strconv.FormatUint(uint64(uid), 10)
+	}
+	return ids
+}
+
+func parseUID(id string) (uint32, error) {
+	uid, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid IMAP message ID %q", id)
+	}
+	return uint32(uid), nil
+}
@@ -0,0 +1,120 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check returns nil when the checked component is ready
+type Check func() error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker aggregates the readiness checks of the application components
+type Checker struct {
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewChecker creates an empty Checker
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Register adds a readiness check under the given name
+func (c *Checker) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// Report runs every check and returns their status ("ok" or the error) by name, and whether all passed
+func (c *Checker) Report() (map[string]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	report := make(map[string]string, len(c.checks))
+	ready := true
+	for _, nc := range c.checks {
+		if err := nc.check(); err != nil {
+			report[nc.name] = err.Error()
+			ready = false
+			continue
+		}
+		report[nc.name] = "ok"
+	}
+	return report, ready
+}
+
+// LivenessHandler answers 200 as long as the process serves HTTP
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write([]byte("ok\n"))
+	})
+}
+
+// ReadinessHandler answers 200 when all checks pass and 503 otherwise, with the JSON report of the checks
+func (c *Checker) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		report, ready := c.Report()
+
+		status := "ready"
+		code := http.StatusOK
+		if !ready {
+			status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(map[string]any{"status": status, "checks": report})
+	})
+}
+
+// State holds the last result of a check performed elsewhere (e.g. the browser self-test)
+type State struct {
+	mu      sync.RWMutex
+	err     error
+	checked bool
+}
+
+// Set records the result of the check
+func (s *State) Set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+	s.checked = true
+}
+
+// Check returns the recorded result, or an error while nothing was recorded yet
+func (s *State) Check() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !s.checked {
+		return errors.New("not checked yet")
+	}
+	return s.err
+}
+
+// Probe requests the given URL and returns an error unless it answers 200, used by the healthcheck subcommand
+func Probe(url string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
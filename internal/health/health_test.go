@@ -0,0 +1,81 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker_ReadinessHandler(t *testing.T) {
+	var browser State
+	imapErr := errors.New("IMAP not authenticated")
+
+	checker := NewChecker()
+	checker.Register("browser", browser.Check)
+	checker.Register("imap:default", func() error { return imapErr })
+
+	server := httptest.NewServer(checker.ReadinessHandler())
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		setup      func()
+		wantStatus int
+		wantChecks map[string]string
+	}{
+		{
+			name:       "nothing checked yet",
+			setup:      func() {},
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"browser": "not checked yet", "imap:default": "IMAP not authenticated"},
+		},
+		{
+			name:       "browser ok, IMAP down",
+			setup:      func() { browser.Set(nil) },
+			wantStatus: http.StatusServiceUnavailable,
+			wantChecks: map[string]string{"browser": "ok", "imap:default": "IMAP not authenticated"},
+		},
+		{
+			name:       "all ready",
+			setup:      func() { imapErr = nil },
+			wantStatus: http.StatusOK,
+			wantChecks: map[string]string{"browser": "ok", "imap:default": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+
+			resp, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatalf("GET error = %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			var body struct {
+				Checks map[string]string `json:"checks"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			for name, want := range tt.wantChecks {
+				if got := body.Checks[name]; got != want {
+					t.Errorf("checks[%s] = %q, want %q", name, got, want)
+				}
+			}
+
+			probeErr := Probe(server.URL, time.Second)
+			if (probeErr == nil) != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("Probe() error = %v, want error: %v", probeErr, tt.wantStatus != http.StatusOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package history
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"netflix-household-validator/internal/models"
+
+	_ "modernc.org/sqlite" // pure-Go SQLite driver
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS history (
+	key        TEXT PRIMARY KEY,
+	account    TEXT NOT NULL DEFAULT '',
+	message_id TEXT NOT NULL DEFAULT '',
+	recipient  TEXT NOT NULL DEFAULT '',
+	token_hash TEXT NOT NULL DEFAULT '',
+	outcome    TEXT NOT NULL,
+	attempts   INTEGER NOT NULL DEFAULT 1,
+	first_seen INTEGER NOT NULL,
+	last_seen  INTEGER NOT NULL,
+	trace_id   TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS history_message_id ON history (message_id);
+CREATE TABLE IF NOT EXISTS replies (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	account    TEXT NOT NULL DEFAULT '',
+	trace_id   TEXT NOT NULL DEFAULT '',
+	recipient  TEXT NOT NULL,
+	outcome    TEXT NOT NULL,
+	sent_at    INTEGER NOT NULL,
+	error      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS replies_trace_id ON replies (trace_id);
+CREATE TABLE IF NOT EXISTS handled_uids (
+	mailbox    TEXT NOT NULL,
+	uid        TEXT NOT NULL,
+	handled_at INTEGER NOT NULL,
+	PRIMARY KEY (mailbox, uid)
+);
+CREATE TABLE IF NOT EXISTS uid_validity (
+	mailbox  TEXT PRIMARY KEY,
+	validity INTEGER NOT NULL
+);
+`
+
+// migrations add the columns introduced after the first schema to existing databases
+var migrations = []string{
+	`ALTER TABLE history ADD COLUMN mailbox TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE history ADD COLUMN uid INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE history ADD COLUMN uid_validity INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS history_mailbox ON history (mailbox)`,
+}
+
+// Entry is the processing record of an email and its update link
+type Entry struct {
+	Key       string
+	Account   string
+	MessageID string
+	Recipient string
+	TokenHash string
+	Outcome   models.Outcome
+	Attempts  int
+	FirstSeen time.Time
+	LastSeen  time.Time
+	TraceID   string
+
+	// Mailbox, UID and UIDValidity identify the IMAP message last processed. UID and UIDValidity are cleared when
+	// the UIDVALIDITY of the mailbox changes, as the UID no longer identifies the message.
+	Mailbox     string
+	UID         uint32
+	UIDValidity uint32
+}
+
+// Store persists the processing history in an embedded SQLite database
+type Store struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// pragmas are applied to every connection opened by database/sql, which may reopen them at any time. Other processes
+// (e.g. pipe deliveries) may share the database: writers wait for their locks instead of failing, and readers do not
+// block them (WAL).
+const pragmas = "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+
+// Open opens (or creates) the history database at the given path
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", path+pragmas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history database: %w", err)
+	}
+	// SQLite serializes writers, a single connection avoids SQLITE_BUSY between account workers
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(schema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to initialize history database: %w", err)
+	}
+	for _, migration := range migrations {
+		if _, err := db.Exec(migration); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			_ = db.Close()
+			return nil, fmt.Errorf("failed to migrate history database: %w", err)
+		}
+	}
+
+	return &Store{db: db, now: time.Now}, nil
+}
+
+// Close closes the underlying database
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Lookup returns the entry stored under the given key, or nil when there is none
+func (s *Store) Lookup(key string) (*Entry, error) {
+	row := s.db.QueryRow(`SELECT key, account, message_id, recipient, token_hash, outcome, attempts, first_seen, last_seen, trace_id,
+		mailbox, uid, uid_validity
+		FROM history WHERE key = ?`, key)
+
+	var (
+		entry               Entry
+		outcome             string
+		firstSeen, lastSeen int64
+	)
+	err := row.Scan(&entry.Key, &entry.Account, &entry.MessageID, &entry.Recipient, &entry.TokenHash, &outcome,
+		&entry.Attempts, &firstSeen, &lastSeen, &entry.TraceID, &entry.Mailbox, &entry.UID, &entry.UIDValidity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history entry: %w", err)
+	}
+
+	entry.Outcome = models.Outcome(outcome)
+	entry.FirstSeen = time.Unix(firstSeen, 0)
+	entry.LastSeen = time.Unix(lastSeen, 0)
+	return &entry, nil
+}
+
+// Record inserts the entry, or updates the existing one with the same key and increments its attempts
+func (s *Store) Record(entry Entry) error {
+	now := s.now().Unix()
+	_, err := s.db.Exec(`INSERT INTO history (key, account, message_id, recipient, token_hash, outcome, attempts, first_seen, last_seen, trace_id,
+			mailbox, uid, uid_validity)
+		VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			outcome = excluded.outcome,
+			attempts = history.attempts + 1,
+			last_seen = excluded.last_seen,
+			trace_id = excluded.trace_id,
+			message_id = CASE WHEN excluded.message_id != '' THEN excluded.message_id ELSE history.message_id END,
+			mailbox = excluded.mailbox,
+			uid = excluded.uid,
+			uid_validity = excluded.uid_validity`,
+		entry.Key, entry.Account, entry.MessageID, entry.Recipient, entry.TokenHash, string(entry.Outcome), now, now, entry.TraceID,
+		entry.Mailbox, entry.UID, entry.UIDValidity)
+	if err != nil {
+		return fmt.Errorf("failed to record history entry: %w", err)
+	}
+	return nil
+}
+
+// Reply is the audit record of an email sent back to a household member
+type Reply struct {
+	Account   string
+	TraceID   string
+	Recipient string
+	Outcome   models.Outcome
+	SentAt    time.Time
+	// Error is the reason the reply could not be sent, empty when it was
+	Error string
+}
+
+// RecordReply appends the reply to the audit log
+func (s *Store) RecordReply(reply Reply) error {
+	_, err := s.db.Exec(`INSERT INTO replies (account, trace_id, recipient, outcome, sent_at, error) VALUES (?, ?, ?, ?, ?, ?)`,
+		reply.Account, reply.TraceID, reply.Recipient, string(reply.Outcome), s.now().Unix(), reply.Error)
+	if err != nil {
+		return fmt.Errorf("failed to record reply: %w", err)
+	}
+	return nil
+}
+
+// Replies returns the replies recorded for the given trace ID, oldest first
+func (s *Store) Replies(traceID string) ([]Reply, error) {
+	rows, err := s.db.Query(`SELECT account, trace_id, recipient, outcome, sent_at, error
+		FROM replies WHERE trace_id = ? ORDER BY id`, traceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replies: %w", err)
+	}
+	defer rows.Close()
+
+	var replies []Reply
+	for rows.Next() {
+		var (
+			reply   Reply
+			outcome string
+			sentAt  int64
+		)
+		if err := rows.Scan(&reply.Account, &reply.TraceID, &reply.Recipient, &outcome, &sentAt, &reply.Error); err != nil {
+			return nil, fmt.Errorf("failed to read replies: %w", err)
+		}
+		reply.Outcome = models.Outcome(outcome)
+		reply.SentAt = time.Unix(sentAt, 0)
+		replies = append(replies, reply)
+	}
+	return replies, rows.Err()
+}
+
+// RecordHandledUID remembers that the message with the given unique ID was handled, for mailboxes that cannot
+// flag it on the server (e.g. POP3 UIDL)
+func (s *Store) RecordHandledUID(mailbox, uid string) error {
+	_, err := s.db.Exec(`INSERT INTO handled_uids (mailbox, uid, handled_at) VALUES (?, ?, ?) ON CONFLICT DO NOTHING`,
+		mailbox, uid, s.now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record handled UID: %w", err)
+	}
+	return nil
+}
+
+// HandledUID reports whether the message with the given unique ID was handled
+func (s *Store) HandledUID(mailbox, uid string) (bool, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM handled_uids WHERE mailbox = ? AND uid = ?`, mailbox, uid).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to read handled UID: %w", err)
+	}
+	return n > 0, nil
+}
+
+// UIDValidity returns the UIDVALIDITY recorded for an IMAP mailbox, or 0 when none was
+func (s *Store) UIDValidity(mailbox string) (uint32, error) {
+	var validity uint32
+	err := s.db.QueryRow(`SELECT validity FROM uid_validity WHERE mailbox = ?`, mailbox).Scan(&validity)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read UIDVALIDITY: %w", err)
+	}
+	return validity, nil
+}
+
+// ResetUIDValidity records the UIDVALIDITY of an IMAP mailbox and clears the UIDs of the entries recorded with
+// another one: they no longer identify the same messages
+func (s *Store) ResetUIDValidity(mailbox string, validity uint32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to reset UIDVALIDITY: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.Exec(`UPDATE history SET uid = 0, uid_validity = 0 WHERE mailbox = ? AND uid_validity != ?`, mailbox, validity)
+	if err != nil {
+		return fmt.Errorf("failed to reset UIDVALIDITY: %w", err)
+	}
+	_, err = tx.Exec(`INSERT INTO uid_validity (mailbox, validity) VALUES (?, ?)
+		ON CONFLICT (mailbox) DO UPDATE SET validity = excluded.validity`, mailbox, validity)
+	if err != nil {
+		return fmt.Errorf("failed to reset UIDVALIDITY: %w", err)
+	}
+	return tx.Commit()
+}
+
+// TokenHash returns the SHA-256 of the nftoken parameter of an update link (of the whole link when it has none),
+// so that the token itself is never stored
+func TokenHash(link string) string {
+	value := link
+	if u, err := url.Parse(link); err == nil {
+		if token := u.Query().Get("nftoken"); token != "" {
+			value = token
+		}
+	}
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// LinkKey returns the history key of an update link
+func LinkKey(link string) string {
+	return "token:" + TokenHash(link)
+}
+
+// MessageKey returns the history key of an email without usable link, or "" when it has no Message-ID
+func MessageKey(messageID string) string {
+	if messageID == "" {
+		return ""
+	}
+	return "message:" + messageID
+}
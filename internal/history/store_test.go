@@ -0,0 +1,272 @@
+package history
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"netflix-household-validator/internal/models"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestStore_RecordAndLookup(t *testing.T) {
+	store := openTestStore(t)
+	now := time.Unix(1760000000, 0)
+	store.now = func() time.Time { return now }
+
+	entry, err := store.Lookup("token:missing")
+	if err != nil || entry != nil {
+		t.Fatalf("Lookup() on empty store = %v, %v, want nil, nil", entry, err)
+	}
+
+	first := Entry{
+		Key:       "token:abc",
+		Account:   "default",
+		MessageID: "id@netflix.com",
+		Recipient: "user@example.com",
+		TokenHash: "abc",
+		Outcome:   models.OutcomeBrowserError,
+		TraceID:   "trace-1",
+	}
+	if err := store.Record(first); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	second := first
+	second.Outcome = models.OutcomeValidated
+	second.TraceID = "trace-2"
+	if err := store.Record(second); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	entry, err = store.Lookup("token:abc")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if entry == nil {
+		t.Fatal("Lookup() = nil, want entry")
+	}
+	if entry.Outcome != models.OutcomeValidated {
+		t.Errorf("Outcome = %v, want %v", entry.Outcome, models.OutcomeValidated)
+	}
+	if entry.Attempts != 2 {
+		t.Errorf("Attempts = %d, want 2", entry.Attempts)
+	}
+	if entry.TraceID != "trace-2" {
+		t.Errorf("TraceID = %v, want trace-2", entry.TraceID)
+	}
+	if !entry.FirstSeen.Equal(time.Unix(1760000000, 0)) || !entry.LastSeen.Equal(now) {
+		t.Errorf("FirstSeen, LastSeen = %v, %v, want %v, %v", entry.FirstSeen, entry.LastSeen, time.Unix(1760000000, 0), now)
+	}
+	if entry.Recipient != "user@example.com" || entry.MessageID != "id@netflix.com" {
+		t.Errorf("Recipient, MessageID = %v, %v", entry.Recipient, entry.MessageID)
+	}
+}
+
+func TestStore_PersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if err := store.Record(Entry{Key: "token:abc", Outcome: models.OutcomeExpired}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	_ = store.Close()
+
+	store, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+
+	entry, err := store.Lookup("token:abc")
+	if err != nil || entry == nil {
+		t.Fatalf("Lookup() = %v, %v, want entry", entry, err)
+	}
+	if entry.Outcome != models.OutcomeExpired {
+		t.Errorf("Outcome = %v, want %v", entry.Outcome, models.OutcomeExpired)
+	}
+}
+
+func TestStore_RecordReply(t *testing.T) {
+	store := openTestStore(t)
+	now := time.Unix(1760000000, 0)
+	store.now = func() time.Time { return now }
+
+	replies := []Reply{
+		{Account: "default", TraceID: "trace-1", Recipient: "member@example.com", Outcome: models.OutcomeValidated},
+		{Account: "default", TraceID: "trace-1", Recipient: "member@example.com", Outcome: models.OutcomeValidated, Error: "timeout"},
+		{Account: "default", TraceID: "trace-2", Recipient: "other@example.com", Outcome: models.OutcomeExpired},
+	}
+	for _, reply := range replies {
+		if err := store.RecordReply(reply); err != nil {
+			t.Fatalf("RecordReply() error = %v", err)
+		}
+	}
+
+	got, err := store.Replies("trace-1")
+	if err != nil {
+		t.Fatalf("Replies() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("len(Replies()) = %d, want 2", len(got))
+	}
+	if got[0].Recipient != "member@example.com" || got[0].Error != "" || !got[0].SentAt.Equal(now) {
+		t.Errorf("Replies()[0] = %+v", got[0])
+	}
+	if got[1].Error != "timeout" {
+		t.Errorf("Replies()[1].Error = %q, want timeout", got[1].Error)
+	}
+}
+
+func TestStore_HandledUID(t *testing.T) {
+	store := openTestStore(t)
+
+	for i := 0; i < 2; i++ {
+		if err := store.RecordHandledUID("pop3://owner@pop.example.com", "uid-1"); err != nil {
+			t.Fatalf("RecordHandledUID() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		mailbox string
+		uid     string
+		want    bool
+	}{
+		{"pop3://owner@pop.example.com", "uid-1", true},
+		{"pop3://owner@pop.example.com", "uid-2", false},
+		{"pop3://other@pop.example.com", "uid-1", false},
+	}
+	for _, tt := range tests {
+		got, err := store.HandledUID(tt.mailbox, tt.uid)
+		if err != nil {
+			t.Fatalf("HandledUID() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("HandledUID(%q, %q) = %v, want %v", tt.mailbox, tt.uid, got, tt.want)
+		}
+	}
+}
+
+func TestStore_ResetUIDValidity(t *testing.T) {
+	store := openTestStore(t)
+	const mailbox = "imap://owner@imap.example.com/INBOX"
+
+	const junk = "imap://owner@imap.example.com/Junk"
+
+	if validity, err := store.UIDValidity(mailbox); err != nil || validity != 0 {
+		t.Fatalf("UIDValidity() = %d, %v, want 0 for an unknown mailbox", validity, err)
+	}
+	entries := []Entry{
+		{Key: "token:inbox", Outcome: models.OutcomeValidated, Mailbox: mailbox, UID: 42, UIDValidity: 1700000000},
+		{Key: "token:junk", Outcome: models.OutcomeValidated, Mailbox: junk, UID: 42, UIDValidity: 1700000000},
+	}
+	for _, entry := range entries {
+		if err := store.Record(entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	for _, validity := range []uint32{1700000000, 1700000001} {
+		if err := store.ResetUIDValidity(mailbox, validity); err != nil {
+			t.Fatalf("ResetUIDValidity() error = %v", err)
+		}
+		if got, err := store.UIDValidity(mailbox); err != nil || got != validity {
+			t.Errorf("UIDValidity() = %d, %v, want %d", got, err, validity)
+		}
+	}
+
+	// The entry is kept (its link must not be opened again) but its UID no longer identifies the message
+	if entry, err := store.Lookup("token:inbox"); err != nil || entry == nil || entry.Mailbox != mailbox || entry.UID != 0 || entry.UIDValidity != 0 {
+		t.Errorf("Lookup() = %+v, %v, want the UID cleared after a UIDVALIDITY change", entry, err)
+	}
+	if entry, err := store.Lookup("token:junk"); err != nil || entry == nil || entry.UID != 42 || entry.UIDValidity != 1700000000 {
+		t.Errorf("Lookup() of another mailbox = %+v, %v, want the UID kept", entry, err)
+	}
+}
+
+func TestOpen_PragmasOnEveryConnection(t *testing.T) {
+	store := openTestStore(t)
+	// Every query gets a new connection
+	store.db.SetMaxIdleConns(0)
+
+	for i := 0; i < 2; i++ {
+		var timeout int
+		var mode string
+		if err := store.db.QueryRow(`PRAGMA busy_timeout`).Scan(&timeout); err != nil {
+			t.Fatalf("PRAGMA busy_timeout error = %v", err)
+		}
+		if err := store.db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+			t.Fatalf("PRAGMA journal_mode error = %v", err)
+		}
+		if timeout != 5000 || mode != "wal" {
+			t.Errorf("busy_timeout = %d, journal_mode = %q, want 5000, wal", timeout, mode)
+		}
+	}
+}
+
+func TestOpen_Migrates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+
+	// Database created before the mailbox, uid and uid_validity columns
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	if _, err := db.Exec(`CREATE TABLE history (key TEXT PRIMARY KEY, account TEXT NOT NULL DEFAULT '',
+		message_id TEXT NOT NULL DEFAULT '', recipient TEXT NOT NULL DEFAULT '', token_hash TEXT NOT NULL DEFAULT '',
+		outcome TEXT NOT NULL, attempts INTEGER NOT NULL DEFAULT 1, first_seen INTEGER NOT NULL,
+		last_seen INTEGER NOT NULL, trace_id TEXT NOT NULL DEFAULT '')`); err != nil {
+		t.Fatalf("Exec() error = %v", err)
+	}
+	_ = db.Close()
+
+	for i := 0; i < 2; i++ {
+		store, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if err := store.Record(Entry{Key: "token:abc", Outcome: models.OutcomeValidated, Mailbox: "imap://a@b/INBOX", UID: 7}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		_ = store.Close()
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	a := TokenHash("https://www.netflix.com/account/update-primary-location?nftoken=TOKEN1&g=1")
+	b := TokenHash("https://www.netflix.com/account/update-primary-location?g=2&nftoken=TOKEN1")
+	c := TokenHash("https://www.netflix.com/account/update-primary-location?nftoken=TOKEN2")
+
+	if a != b {
+		t.Errorf("TokenHash() differs for the same nftoken: %v != %v", a, b)
+	}
+	if a == c {
+		t.Errorf("TokenHash() equal for different nftokens")
+	}
+	if len(a) != 64 {
+		t.Errorf("len(TokenHash()) = %d, want 64", len(a))
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	if got := MessageKey(""); got != "" {
+		t.Errorf("MessageKey(\"\") = %v, want empty", got)
+	}
+	if got := MessageKey("id@netflix.com"); got != "message:id@netflix.com" {
+		t.Errorf("MessageKey() = %v, want message:id@netflix.com", got)
+	}
+}
@@ -5,15 +5,47 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"netflix-household-validator/internal/models"
+
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/commands"
+	"github.com/emersion/go-imap/responses"
+	"github.com/emersion/go-imap/utf7"
+	"github.com/emersion/go-sasl"
 )
 
+// specialUseAttrs are the special-use attributes of RFC 6154
+var specialUseAttrs = []string{imap.AllAttr, imap.ArchiveAttr, imap.DraftsAttr, imap.FlaggedAttr, imap.JunkAttr,
+	imap.SentAttr, imap.TrashAttr}
+
+// rejectedUIDs are the UIDs of a mailbox that cannot match, valid as long as its UIDVALIDITY does not change
+type rejectedUIDs struct {
+	uidValidity uint32
+	uids        map[uint32]bool
+}
+
 type StandardClient struct {
-	client  *client.Client
-	timeout time.Duration
+	client *client.Client
+	server string
+	// uidValidity is the UIDVALIDITY of the selected mailbox, keywords whether it allows new keywords
+	uidValidity uint32
+	keywords    bool
+	// folders are the destination folders known to exist
+	folders map[string]bool
+	// selected is the selected mailbox; rejected are the UIDs rejected during the session, by mailbox
+	selected string
+	rejected map[string]*rejectedUIDs
+	// notifying is set once the watched mailboxes are subscribed to with NOTIFY
+	notifying bool
+	timeout   time.Duration
+	lastIdle  atomic.Int64
 }
 
 // NewStandardClient creates a new StandardClient with a default timeout of 30 seconds for IMAP operations
@@ -41,6 +73,10 @@ func (c *StandardClient) Connect(server string) error {
 		return fmt.Errorf("IMAP connection error: %w", err)
 	}
 	c.client = cl
+	c.server = server
+	c.folders = make(map[string]bool)
+	c.rejected = make(map[string]*rejectedUIDs)
+	c.notifying = false
 	return nil
 }
 
@@ -52,35 +88,224 @@ func (c *StandardClient) Login(user, password string) error {
 	return c.client.Login(user, password)
 }
 
-// SelectMailbox selects the specified mailbox (e.g., "INBOX") for subsequent operations. It returns an error if the mailbox cannot be selected or if there is no active connection.
-func (c *StandardClient) SelectMailbox(name string) error {
+// Authenticate authenticates the user with an OAuth2 access token, using the SASL mechanism
+// models.OAuth2MechanismXOAUTH2 or models.OAuth2MechanismOAUTHBEARER. It returns an error if the server does not
+// support the mechanism, rejects the token, or if there is no active connection.
+func (c *StandardClient) Authenticate(user, token, mechanism string) error {
 	if c.client == nil {
 		return fmt.Errorf("not connected")
 	}
-	_, err := c.client.Select(name, false)
-	return err
+
+	var auth sasl.Client
+	switch mechanism {
+	case models.OAuth2MechanismXOAUTH2:
+		auth = &xoauth2Client{user: user, token: token}
+	case models.OAuth2MechanismOAUTHBEARER:
+		host, portStr, _ := net.SplitHostPort(c.server)
+		port, _ := strconv.Atoi(portStr)
+		auth = sasl.NewOAuthBearerClient(&sasl.OAuthBearerOptions{Username: user, Token: token, Host: host, Port: port})
+	default:
+		return fmt.Errorf("unsupported OAuth2 mechanism %q", mechanism)
+	}
+
+	name, _, _ := auth.Start()
+	if ok, err := c.client.SupportAuth(name); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("server does not support AUTH=%s", name)
+	}
+	return c.client.Authenticate(auth)
 }
 
-// ListUnseenUIDs retrieves the UIDs of unseen emails that have been received within the specified duration (e.g., last 15 minutes). It returns a slice of UIDs and an error if the search operation fails or if there is no active connection.
-func (c *StandardClient) ListUnseenUIDs(since time.Duration) ([]uint32, error) {
+// SpecialUseMailboxes returns the names of the mailboxes having a special-use attribute (RFC 6154), by attribute (e.g., "\\Junk": "Spam"). It returns an error if the LIST command fails or if there is no active connection.
+func (c *StandardClient) SpecialUseMailboxes() (map[string]string, error) {
 	if c.client == nil {
 		return nil, fmt.Errorf("not connected")
 	}
 
-	criteria := imap.NewSearchCriteria()
-	criteria.WithoutFlags = []string{imap.SeenFlag}
-	criteria.Since = time.Now().Add(-since)
+	mailboxes := make(chan *imap.MailboxInfo, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.client.List("", "*", mailboxes)
+	}()
+
+	special := make(map[string]string)
+	for info := range mailboxes {
+		for _, attr := range info.Attributes {
+			i := slices.IndexFunc(specialUseAttrs, func(special string) bool { return strings.EqualFold(special, attr) })
+			if i < 0 {
+				continue
+			}
+			if _, ok := special[specialUseAttrs[i]]; !ok {
+				special[specialUseAttrs[i]] = info.Name
+			}
+		}
+	}
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("error listing mailboxes: %w", err)
+	}
+	return special, nil
+}
+
+// WatchMailboxes subscribes to the new messages of the mailboxes with NOTIFY (RFC 5465): WaitForNewMail then also returns when one of them changes, not only the selected mailbox. Mailbox names are sent in modified UTF-7. It returns false when the server does not support NOTIFY and IDLE, and an error if the command fails or if there is no active connection.
+func (c *StandardClient) WatchMailboxes(names []string) (bool, error) {
+	if c.client == nil {
+		return false, fmt.Errorf("not connected")
+	}
+	// NOTIFY events are read while idling
+	for _, capability := range []string{"NOTIFY", "IDLE"} {
+		if ok, err := c.client.Support(capability); err != nil || !ok {
+			return false, err
+		}
+	}
 
-	uids, err := c.client.Search(criteria)
+	mailboxes := make([]interface{}, len(names))
+	for i, name := range names {
+		encoded, err := utf7.Encoding.NewEncoder().String(name)
+		if err != nil {
+			return false, fmt.Errorf("invalid mailbox name %q: %w", name, err)
+		}
+		mailboxes[i] = encoded
+	}
+	events := []interface{}{imap.RawString("MessageNew"), imap.RawString("MessageExpunge")}
+	cmd := &imap.Command{Name: "NOTIFY", Arguments: []interface{}{
+		imap.RawString("SET"),
+		[]interface{}{imap.RawString("selected"), events},
+		[]interface{}{imap.RawString("mailboxes"), mailboxes, events},
+	}}
+
+	status, err := c.client.Execute(cmd, nil)
+	if err != nil {
+		return false, err
+	}
+	if err := status.Err(); err != nil {
+		return false, err
+	}
+	c.notifying = true
+	return true, nil
+}
+
+// SelectMailbox selects the specified mailbox (e.g., "INBOX") for subsequent operations and records its UIDVALIDITY. It returns an error if the mailbox cannot be selected or if there is no active connection.
+func (c *StandardClient) SelectMailbox(name string) error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+	status, err := c.client.Select(name, false)
+	if err != nil {
+		return err
+	}
+	if rejected := c.rejected[name]; rejected != nil && rejected.uidValidity != status.UidValidity {
+		delete(c.rejected, name)
+	}
+	c.selected = name
+	c.uidValidity = status.UidValidity
+	c.keywords = slices.Contains(status.PermanentFlags, imap.TryCreateFlag)
+	return nil
+}
+
+// UIDValidity returns the UIDVALIDITY of the selected mailbox: the UIDs only identify the same messages as long as it does not change
+func (c *StandardClient) UIDValidity() uint32 {
+	return c.uidValidity
+}
+
+// SupportsKeywords reports whether the selected mailbox allows new keywords (PERMANENTFLAGS \*)
+func (c *StandardClient) SupportsKeywords() bool {
+	return c.keywords
+}
+
+// ListCandidateUIDs retrieves the UIDs of the emails that have been received within the specified duration (e.g., last 15 minutes) and were not processed yet: without a final keyword when the mailbox allows keywords, unseen otherwise. The search is restricted to the senders (any of them) and the subject word when given, and the UIDs rejected during the session are left out. It returns a slice of UIDs and an error if the search operation fails or if there is no active connection.
+func (c *StandardClient) ListCandidateUIDs(since time.Duration, senders []string, subject string) ([]uint32, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	uids, err := c.client.UidSearch(candidateCriteria(time.Now().Add(-since), c.keywords, senders, subject))
 	if err != nil {
 		return nil, fmt.Errorf("error searching for recent emails: %w", err)
 	}
 
+	if rejected := c.rejected[c.selected]; rejected != nil {
+		uids = slices.DeleteFunc(uids, func(uid uint32) bool { return rejected.uids[uid] })
+	}
 	return uids, nil
 }
 
+// candidateCriteria returns the search criteria of the emails received since the date and not processed yet
+func candidateCriteria(since time.Time, keywords bool, senders []string, subject string) *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+	if keywords {
+		criteria.WithoutFlags = finalKeywords
+	} else {
+		criteria.WithoutFlags = []string{imap.SeenFlag}
+	}
+	criteria.Since = since
+	if subject != "" {
+		criteria.Header.Add("Subject", subject)
+	}
+	switch len(senders) {
+	case 0:
+	case 1:
+		criteria.Header.Add("From", senders[0])
+	default:
+		criteria.Or = [][2]*imap.SearchCriteria{fromAny(senders)}
+	}
+	return criteria
+}
+
+// fromAny returns the OR criteria matching any of the senders, at least two
+func fromAny(senders []string) [2]*imap.SearchCriteria {
+	from := func(sender string) *imap.SearchCriteria {
+		criteria := imap.NewSearchCriteria()
+		criteria.Header.Add("From", sender)
+		return criteria
+	}
+
+	rest := from(senders[1])
+	if len(senders) > 2 {
+		rest = imap.NewSearchCriteria()
+		rest.Or = [][2]*imap.SearchCriteria{fromAny(senders[1:])}
+	}
+	return [2]*imap.SearchCriteria{from(senders[0]), rest}
+}
+
+// RejectUID records that the email with the specified UID cannot match, so that it is no longer listed by ListCandidateUIDs during the session
+func (c *StandardClient) RejectUID(uid uint32) {
+	if c.client == nil {
+		return
+	}
+	rejected := c.rejected[c.selected]
+	if rejected == nil {
+		rejected = &rejectedUIDs{uidValidity: c.uidValidity, uids: make(map[uint32]bool)}
+		c.rejected[c.selected] = rejected
+	}
+	rejected.uids[uid] = true
+}
+
 // FetchMessage retrieves the full email message corresponding to the specified UID. It returns an imap.Message struct containing the email data and an error if the fetch operation fails, if there is no active connection, or if no message is retrieved for the given UID.
 func (c *StandardClient) FetchMessage(uid uint32) (*imap.Message, error) {
+	section := &imap.BodySectionName{Peek: true}
+	return c.fetch(uid, []imap.FetchItem{section.FetchItem(), imap.FetchInternalDate, imap.FetchUid})
+}
+
+// FetchEnvelope retrieves the ENVELOPE and BODYSTRUCTURE of the email corresponding to the specified UID, without downloading its body. It returns an error if the fetch operation fails, if there is no active connection, or if no message is retrieved for the given UID.
+func (c *StandardClient) FetchEnvelope(uid uint32) (*imap.Message, error) {
+	return c.fetch(uid, []imap.FetchItem{imap.FetchEnvelope, imap.FetchBodyStructure, imap.FetchInternalDate, imap.FetchUid})
+}
+
+// FetchParts retrieves the header and the specified parts (with their MIME header) of the email corresponding to the specified UID, leaving the other parts (e.g., attachments) on the server. It returns an error if the fetch operation fails, if there is no active connection, or if no message is retrieved for the given UID.
+func (c *StandardClient) FetchParts(uid uint32, paths [][]int) (*imap.Message, error) {
+	header := &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Specifier: imap.HeaderSpecifier}}
+	items := []imap.FetchItem{header.FetchItem(), imap.FetchInternalDate, imap.FetchUid}
+	for _, path := range paths {
+		mime := &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Specifier: imap.MIMESpecifier, Path: path}}
+		content := &imap.BodySectionName{Peek: true, BodyPartName: imap.BodyPartName{Path: path}}
+		items = append(items, mime.FetchItem(), content.FetchItem())
+	}
+	return c.fetch(uid, items)
+}
+
+// fetch retrieves the items of the email corresponding to the specified UID
+func (c *StandardClient) fetch(uid uint32, items []imap.FetchItem) (*imap.Message, error) {
 	if c.client == nil {
 		return nil, fmt.Errorf("not connected")
 	}
@@ -88,9 +313,6 @@ func (c *StandardClient) FetchMessage(uid uint32) (*imap.Message, error) {
 	seqSet := new(imap.SeqSet)
 	seqSet.AddNum(uid)
 
-	section := &imap.BodySectionName{Peek: true}
-	items := []imap.FetchItem{section.FetchItem(), imap.FetchInternalDate, imap.FetchUid}
-
 	prevTimeout := c.client.Timeout
 	c.client.Timeout = c.timeout
 	defer func() { c.client.Timeout = prevTimeout }()
@@ -99,7 +321,7 @@ func (c *StandardClient) FetchMessage(uid uint32) (*imap.Message, error) {
 	done := make(chan error, 1)
 
 	go func() {
-		done <- c.client.Fetch(seqSet, items, messages)
+		done <- c.client.UidFetch(seqSet, items, messages)
 	}()
 
 	var msg *imap.Message
@@ -118,8 +340,8 @@ func (c *StandardClient) FetchMessage(uid uint32) (*imap.Message, error) {
 	return msg, nil
 }
 
-// MarkSeen marks the email with the specified UID as seen (read) on the IMAP server. It returns an error if the store operation fails or if there is no active connection.
-func (c *StandardClient) MarkSeen(uid uint32) error {
+// AddFlags adds the flags or keywords (e.g., \Seen, $NfxValidated) to the email with the specified UID on the IMAP server. It returns an error if the store operation fails or if there is no active connection.
+func (c *StandardClient) AddFlags(uid uint32, flags ...string) error {
 	if c.client == nil {
 		return fmt.Errorf("not connected")
 	}
@@ -128,9 +350,136 @@ func (c *StandardClient) MarkSeen(uid uint32) error {
 	seqSet.AddNum(uid)
 
 	item := imap.FormatFlagsOp(imap.AddFlags, true)
-	flags := []interface{}{imap.SeenFlag}
+	values := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		values[i] = flag
+	}
 
-	return c.client.Store(seqSet, item, flags, nil)
+	return c.client.UidStore(seqSet, item, values, nil)
+}
+
+// Move moves the email with the specified UID to the folder, which is created if missing. Servers without MOVE
+// (RFC 6851) get COPY, \Deleted and UID EXPUNGE instead, which needs UIDPLUS (RFC 4315): without both, the move fails
+// before anything is copied. It returns an error if one of the operations fails or if there is no active connection.
+func (c *StandardClient) Move(uid uint32, folder string) error {
+	if err := c.ensureFolder(folder); err != nil {
+		return err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	if ok, err := c.client.Support("MOVE"); err != nil {
+		return err
+	} else if ok {
+		return c.client.UidMove(seqSet, folder)
+	}
+	if err := c.requireUIDPlus(); err != nil {
+		return err
+	}
+
+	if err := c.client.UidCopy(seqSet, folder); err != nil {
+		return err
+	}
+	return c.Delete(uid)
+}
+
+// Copy copies the email with the specified UID to the folder, which is created if missing. It returns an error if the copy fails or if there is no active connection.
+func (c *StandardClient) Copy(uid uint32, folder string) error {
+	if err := c.ensureFolder(folder); err != nil {
+		return err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+	return c.client.UidCopy(seqSet, folder)
+}
+
+// Delete flags the email with the specified UID as \Deleted and expunges it with UID EXPUNGE. Servers without UIDPLUS
+// (RFC 4315) are refused before the message is flagged: a plain EXPUNGE would also remove the other messages the user
+// flagged as deleted. It returns an error if one of the operations fails or if there is no active connection.
+func (c *StandardClient) Delete(uid uint32) error {
+	if err := c.requireUIDPlus(); err != nil {
+		return err
+	}
+	if err := c.AddFlags(uid, imap.DeletedFlag); err != nil {
+		return err
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	cmd := &commands.Uid{Cmd: &imap.Command{Name: "EXPUNGE", Arguments: []interface{}{seqSet}}}
+	status, err := c.client.Execute(cmd, nil)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// requireUIDPlus fails when the server cannot expunge a single message (UID EXPUNGE, RFC 4315)
+func (c *StandardClient) requireUIDPlus() error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+	if ok, err := c.client.Support("UIDPLUS"); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("server without UIDPLUS: messages cannot be expunged one by one from %s", c.selected)
+	}
+	return nil
+}
+
+// AddLabels adds Gmail labels to the email with the specified UID with X-GM-LABELS. It returns an error if the server is not Gmail (X-GM-EXT-1), if the store operation fails or if there is no active connection.
+func (c *StandardClient) AddLabels(uid uint32, labels ...string) error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+	if ok, err := c.client.Support("X-GM-EXT-1"); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("server does not support Gmail labels (X-GM-EXT-1)")
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uid)
+
+	values := make([]interface{}, len(labels))
+	for i, label := range labels {
+		values[i] = label
+	}
+	return c.client.UidStore(seqSet, imap.StoreItem("+X-GM-LABELS.SILENT"), values, nil)
+}
+
+// ensureFolder creates the folder when it does not exist
+func (c *StandardClient) ensureFolder(folder string) error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+	if c.folders[folder] {
+		return nil
+	}
+
+	mailboxes := make(chan *imap.MailboxInfo, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.client.List("", folder, mailboxes)
+	}()
+	exists := false
+	for range mailboxes {
+		exists = true
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("error listing folder %s: %w", folder, err)
+	}
+
+	if !exists {
+		if err := c.client.Create(folder); err != nil {
+			return fmt.Errorf("error creating folder %s: %w", folder, err)
+		}
+	}
+	c.folders[folder] = true
+	return nil
 }
 
 // Close logs out from the IMAP server and closes the connection. It returns an error if the logout operation fails. If there is no active connection, it simply returns nil.
@@ -139,13 +488,14 @@ func (c *StandardClient) Close() error {
 		return nil
 	}
 	err := c.client.Logout()
-	c.client = nil
+	c.client, c.uidValidity, c.keywords, c.notifying = nil, 0, false, false
 	return err
 }
 
 // WaitForNewMail enters IMAP IDLE and blocks until the server signals a mailbox
-// change or ctx is cancelled. IDLE is transparently re-issued every 25 minutes
-// to prevent the server from closing the session (RFC 2177 recommends < 29 min).
+// change (of the selected mailbox, or of a watched one with NOTIFY) or ctx is
+// cancelled. IDLE is transparently re-issued every 25 minutes to prevent the
+// server from closing the session (RFC 2177 recommends < 29 min).
 func (c *StandardClient) WaitForNewMail(ctx context.Context) error {
 	if c.client == nil {
 		return fmt.Errorf("not connected")
@@ -158,10 +508,12 @@ func (c *StandardClient) WaitForNewMail(ctx context.Context) error {
 	defer func() { c.client.Updates = nil }()
 
 	for {
+		c.lastIdle.Store(time.Now().UnixNano())
+
 		stop := make(chan struct{})
 		idleDone := make(chan error, 1)
 		go func() {
-			idleDone <- c.client.Idle(stop, nil)
+			idleDone <- c.idle(stop, updates)
 		}()
 
 		refresh := time.NewTimer(idleRefreshInterval)
@@ -196,3 +548,60 @@ func (c *StandardClient) WaitForNewMail(ctx context.Context) error {
 		}
 	}
 }
+
+// idle runs IDLE until stop is closed. With NOTIFY, the STATUS responses sent for the other watched mailboxes are
+// delivered on updates as mailbox updates, like the changes of the selected mailbox.
+func (c *StandardClient) idle(stop <-chan struct{}, updates chan<- client.Update) error {
+	if !c.notifying {
+		return c.client.Idle(stop, nil)
+	}
+
+	handler := &notifyHandler{
+		Idle:    &responses.Idle{Stop: stop, RepliesCh: make(chan []byte, 10)},
+		updates: updates,
+	}
+	status, err := c.client.Execute(&commands.Idle{}, handler)
+	if err != nil {
+		return err
+	}
+	return status.Err()
+}
+
+// notifyHandler handles the responses to IDLE when NOTIFY is set: the client would otherwise drop the STATUS
+// responses of the other mailboxes. Those received while another command runs are lost, the caller must poll too.
+type notifyHandler struct {
+	*responses.Idle
+	updates chan<- client.Update
+}
+
+// Handle implements responses.Handler. It is called with the client handlers locked and must not block.
+func (h *notifyHandler) Handle(resp imap.Resp) error {
+	name, fields, ok := imap.ParseNamedResp(resp)
+	if !ok || name != "STATUS" || len(fields) < 2 {
+		return h.Idle.Handle(resp)
+	}
+
+	mailbox, _ := imap.ParseString(fields[0])
+	if decoded, err := utf7.Encoding.NewDecoder().String(mailbox); err == nil {
+		mailbox = decoded
+	}
+	status := imap.NewMailboxStatus(mailbox, nil)
+	if items, ok := fields[1].([]interface{}); ok {
+		_ = status.Parse(items)
+	}
+
+	select {
+	case h.updates <- &client.MailboxUpdate{Mailbox: status}:
+	default:
+	}
+	return nil
+}
+
+// LastIdleRefresh returns when IDLE was last issued or re-issued, or the zero time if it never was
+func (c *StandardClient) LastIdleRefresh() time.Time {
+	nanos := c.lastIdle.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
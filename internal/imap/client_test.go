@@ -0,0 +1,344 @@
+package imap
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/netflix"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend/backendutil"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-message"
+)
+
+// matches reports whether the raw message matches the search criteria, as evaluated by a server
+func matches(t *testing.T, raw string, date time.Time, flags []string, criteria *imap.SearchCriteria) bool {
+	t.Helper()
+	entity, err := message.Read(strings.NewReader(strings.ReplaceAll(raw, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("message.Read() error = %v", err)
+	}
+	ok, err := backendutil.Match(entity, 1, 1, date, flags, criteria)
+	if err != nil {
+		t.Fatalf("Match() error = %v", err)
+	}
+	return ok
+}
+
+func TestCandidateCriteria_TrustedForwarder(t *testing.T) {
+	service := netflix.NewService(nil, &models.Config{
+		TargetFrom:        "info@account.netflix.com",
+		TargetSubject:     "Important : comment mettre à jour votre foyer Netflix",
+		TrustedForwarders: models.StringList{"partner@example.com"},
+	})
+	senders, subject := service.SearchTerms()
+	now := time.Now()
+	// SINCE only compares dates
+	criteria := candidateCriteria(now.AddDate(0, 0, -2), true, senders, subject)
+
+	reworded := "From: Partner <partner@example.com>\nTo: user@example.com\nSubject: can you click this?\n\nbody\n"
+	if !matches(t, reworded, now, nil, criteria) {
+		t.Error("forwarded email with a reworded subject is not a candidate")
+	}
+	other := "From: News <news@example.com>\nTo: user@example.com\nSubject: Netflix weekly\n\nbody\n"
+	if matches(t, other, now, nil, criteria) {
+		t.Error("email from another sender is a candidate")
+	}
+}
+
+func TestFromAny(t *testing.T) {
+	senders := []string{"a@example.com", "b@example.com", "c@example.com", "d@example.com"}
+	now := time.Now()
+
+	for n := 2; n <= len(senders); n++ {
+		t.Run(fmt.Sprintf("%d senders", n), func(t *testing.T) {
+			criteria := imap.NewSearchCriteria()
+			criteria.Or = [][2]*imap.SearchCriteria{fromAny(senders[:n])}
+
+			for i, sender := range senders {
+				raw := "From: " + sender + "\nSubject: test\n\nbody\n"
+				if got, want := matches(t, raw, now, nil, criteria), i < n; got != want {
+					t.Errorf("match %s = %v, want %v", sender, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCandidateCriteria(t *testing.T) {
+	now := time.Now()
+	const raw = "From: Netflix <info@account.netflix.com>\nSubject: Votre foyer Netflix\n\nbody\n"
+
+	tests := []struct {
+		name     string
+		keywords bool
+		senders  []string
+		subject  string
+		date     time.Time
+		flags    []string
+		want     bool
+	}{
+		{name: "unseen", want: true},
+		{name: "seen without keywords", flags: []string{imap.SeenFlag}},
+		{name: "seen with keywords", keywords: true, flags: []string{imap.SeenFlag}, want: true},
+		{name: "final keyword", keywords: true, flags: []string{finalKeywords[0]}},
+		{name: "final keyword without keywords", flags: []string{finalKeywords[0]}, want: true},
+		{name: "too old", date: now.AddDate(0, 0, -7)},
+		{name: "sender", senders: []string{"info@account.netflix.com"}, want: true},
+		{name: "other sender", senders: []string{"partner@example.com"}},
+		{name: "any sender", senders: []string{"partner@example.com", "info@account.netflix.com"}, want: true},
+		{name: "subject", subject: "foyer", want: true},
+		{name: "other subject", subject: "household"},
+		{name: "sender and other subject", senders: []string{"info@account.netflix.com"}, subject: "household"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date := tt.date
+			if date.IsZero() {
+				date = now
+			}
+			criteria := candidateCriteria(now.AddDate(0, 0, -2), tt.keywords, tt.senders, tt.subject)
+			if got := matches(t, raw, date, tt.flags, criteria); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeServer is a scripted IMAP server: it answers every command with the untagged responses of its verb (e.g.
+// "LIST", "UID MOVE") and a tagged OK, and records the commands. IDLE sends the idle responses once started.
+type fakeServer struct {
+	conn      net.Conn
+	responses map[string][]string
+	idle      []string
+
+	mu       sync.Mutex
+	commands []string
+}
+
+// newFakeClient connects a StandardClient to a fake server announcing the capabilities, with INBOX selected
+func newFakeClient(t *testing.T, capabilities string, responses map[string][]string, idle ...string) (*StandardClient, *fakeServer) {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	server := &fakeServer{conn: serverConn, responses: responses, idle: idle}
+	go server.serve(capabilities)
+	t.Cleanup(func() { _ = clientConn.Close(); _ = serverConn.Close() })
+
+	cl, err := client.New(clientConn)
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	// The connection is closed under the reader at the end of the test
+	cl.ErrorLog = log.New(io.Discard, "", 0)
+	c := NewStandardClient()
+	c.client, c.folders, c.rejected = cl, make(map[string]bool), make(map[string]*rejectedUIDs)
+	if err := c.SelectMailbox("INBOX"); err != nil {
+		t.Fatalf("SelectMailbox() error = %v", err)
+	}
+	server.reset()
+	return c, server
+}
+
+func (s *fakeServer) serve(capabilities string) {
+	r := bufio.NewReader(s.conn)
+	write := func(line string) bool {
+		_, err := fmt.Fprintf(s.conn, "%s\r\n", line)
+		return err == nil
+	}
+
+	if !write("* PREAUTH [CAPABILITY IMAP4rev1 " + capabilities + "] ready") {
+		return
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		tag, command, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		s.mu.Lock()
+		s.commands = append(s.commands, command)
+		s.mu.Unlock()
+
+		fields := strings.Fields(command)
+		verb := strings.ToUpper(fields[0])
+		if verb == "UID" && len(fields) > 1 {
+			verb += " " + strings.ToUpper(fields[1])
+		}
+
+		switch verb {
+		case "SELECT":
+			write("* FLAGS (\\Seen \\Deleted)")
+			write("* OK [PERMANENTFLAGS (\\Seen \\Deleted \\*)] flags")
+			write("* OK [UIDVALIDITY 7] UIDs valid")
+		case "IDLE":
+			write("+ idling")
+			for _, resp := range s.idle {
+				write(resp)
+			}
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+		default:
+			for _, resp := range s.responses[verb] {
+				write(resp)
+			}
+		}
+		if !write(tag + " OK " + verb + " completed") {
+			return
+		}
+	}
+}
+
+// sent returns the commands received since the last reset
+func (s *fakeServer) sent() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.commands)
+}
+
+func (s *fakeServer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands = nil
+}
+
+func TestStandardClient_Move(t *testing.T) {
+	existing := map[string][]string{"LIST": {`* LIST () "/" Netflix`}}
+
+	tests := []struct {
+		name         string
+		capabilities string
+		responses    map[string][]string
+		want         []string
+		wantErr      bool
+	}{
+		{
+			name:         "MOVE",
+			capabilities: "MOVE UIDPLUS",
+			responses:    existing,
+			want:         []string{`LIST "" "Netflix"`, `UID MOVE 42 "Netflix"`},
+		},
+		{
+			name:         "MOVE to a new folder",
+			capabilities: "MOVE",
+			want:         []string{`LIST "" "Netflix"`, `CREATE "Netflix"`, `UID MOVE 42 "Netflix"`},
+		},
+		{
+			name:         "COPY and UID EXPUNGE",
+			capabilities: "UIDPLUS",
+			responses:    existing,
+			want: []string{`LIST "" "Netflix"`, `UID COPY 42 "Netflix"`, `UID STORE 42 +FLAGS.SILENT (\Deleted)`,
+				"UID EXPUNGE 42"},
+		},
+		{
+			// The message would be copied but not expunged
+			name:      "without MOVE nor UIDPLUS",
+			responses: existing,
+			want:      []string{`LIST "" "Netflix"`},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newFakeClient(t, tt.capabilities, tt.responses)
+			if err := c.Move(42, "Netflix"); (err != nil) != tt.wantErr {
+				t.Fatalf("Move() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := server.sent(); !slices.Equal(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStandardClient_Delete(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities string
+		want         []string
+		wantErr      bool
+	}{
+		{name: "UIDPLUS", capabilities: "UIDPLUS", want: []string{`UID STORE 42 +FLAGS.SILENT (\Deleted)`, "UID EXPUNGE 42"}},
+		// A plain EXPUNGE would remove the other messages flagged as deleted
+		{name: "without UIDPLUS", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newFakeClient(t, tt.capabilities, nil)
+			if err := c.Delete(42); (err != nil) != tt.wantErr {
+				t.Fatalf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := server.sent(); !slices.Equal(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStandardClient_WatchMailboxes(t *testing.T) {
+	tests := []struct {
+		name         string
+		capabilities string
+		want         bool
+	}{
+		{name: "NOTIFY and IDLE", capabilities: "NOTIFY IDLE", want: true},
+		{name: "without NOTIFY", capabilities: "IDLE"},
+		// NOTIFY events are only read while idling
+		{name: "without IDLE", capabilities: "NOTIFY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, server := newFakeClient(t, tt.capabilities, nil)
+			ok, err := c.WatchMailboxes([]string{"INBOX", "Indésirables"})
+			if err != nil || ok != tt.want {
+				t.Fatalf("WatchMailboxes() = %v, %v, want %v", ok, err, tt.want)
+			}
+
+			sent := server.sent()
+			if !tt.want {
+				if len(sent) != 0 {
+					t.Errorf("commands = %q, want none", sent)
+				}
+				return
+			}
+			// Mailbox names are sent in modified UTF-7
+			want := `NOTIFY SET (selected (MessageNew MessageExpunge)) (mailboxes ("INBOX" "Ind&AOk-sirables") (MessageNew MessageExpunge))`
+			if len(sent) != 1 || sent[0] != want {
+				t.Errorf("commands = %q, want %q", sent, want)
+			}
+		})
+	}
+}
+
+func TestStandardClient_WaitForNewMail_Notify(t *testing.T) {
+	c, server := newFakeClient(t, "NOTIFY IDLE", nil, "* STATUS Ind&AOk-sirables (MESSAGES 3 UIDNEXT 10)")
+	if ok, err := c.WatchMailboxes([]string{"INBOX", "Indésirables"}); err != nil || !ok {
+		t.Fatalf("WatchMailboxes() = %v, %v", ok, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// The STATUS of another watched mailbox ends the wait, like a change of the selected mailbox
+	if err := c.WaitForNewMail(ctx); err != nil {
+		t.Fatalf("WaitForNewMail() error = %v", err)
+	}
+	if sent := server.sent(); len(sent) != 2 || sent[1] != "IDLE" {
+		t.Errorf("commands = %q, want NOTIFY then IDLE", sent)
+	}
+}
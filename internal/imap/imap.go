@@ -7,15 +7,40 @@ import (
 	"github.com/emersion/go-imap"
 )
 
+// Keywords set on the processed messages when the server allows them (PERMANENTFLAGS \*)
+const (
+	KeywordValidated = "$NfxValidated"
+	KeywordExpired   = "$NfxExpired"
+	KeywordFailed    = "$NfxFailed"
+	KeywordSkipped   = "$NfxSkipped"
+)
+
+// finalKeywords mark the messages done with. Those marked $NfxFailed are tried again within the validity window.
+var finalKeywords = []string{KeywordValidated, KeywordExpired, KeywordSkipped}
+
+// Client is an IMAP session. Messages are identified by their UID (RFC 3501 section 2.3.1.1), which is stable
+// across sessions as long as the UIDVALIDITY of the mailbox does not change.
 type Client interface {
 	Connect(server string) error
 	Login(user, password string) error
+	Authenticate(user, token, mechanism string) error
+	SpecialUseMailboxes() (map[string]string, error)
+	WatchMailboxes(names []string) (bool, error)
 	SelectMailbox(name string) error
-	ListUnseenUIDs(since time.Duration) ([]uint32, error)
+	UIDValidity() uint32
+	SupportsKeywords() bool
+	ListCandidateUIDs(since time.Duration, senders []string, subject string) ([]uint32, error)
+	RejectUID(uid uint32)
+	FetchEnvelope(uid uint32) (*imap.Message, error)
+	FetchParts(uid uint32, paths [][]int) (*imap.Message, error)
 	FetchMessage(uid uint32) (*imap.Message, error)
-	MarkSeen(uid uint32) error
+	AddFlags(uid uint32, flags ...string) error
+	Move(uid uint32, folder string) error
+	Copy(uid uint32, folder string) error
+	Delete(uid uint32) error
+	AddLabels(uid uint32, labels ...string) error
 	Close() error
 	// WaitForNewMail blocks until the server signals new mail in the selected
-	// mailbox (via IMAP IDLE) or ctx is cancelled.
+	// mailbox (via IMAP IDLE), or in a watched mailbox (via NOTIFY), or ctx is cancelled.
 	WaitForNewMail(ctx context.Context) error
 }
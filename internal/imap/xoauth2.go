@@ -0,0 +1,18 @@
+package imap
+
+// xoauth2Client implements the XOAUTH2 SASL mechanism of Gmail and Microsoft 365, which go-sasl does not provide
+type xoauth2Client struct {
+	user  string
+	token string
+}
+
+// Start sends the user and the access token as initial response
+func (c *xoauth2Client) Start() (mech string, ir []byte, err error) {
+	return "XOAUTH2", []byte("user=" + c.user + "\x01auth=Bearer " + c.token + "\x01\x01"), nil
+}
+
+// Next answers the JSON error sent as challenge when the token is rejected with an empty response, after which the
+// server ends the exchange with a NO status
+func (c *xoauth2Client) Next(challenge []byte) ([]byte, error) {
+	return []byte{}, nil
+}
@@ -0,0 +1,183 @@
+package mailauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-msgauth/authres"
+)
+
+// maxARCInstances is the highest instance number allowed by RFC 8617
+const maxARCInstances = 50
+
+var aarInstanceRe = regexp.MustCompile(`^\s*i\s*=\s*(\d+)\s*;`)
+
+// arcSet groups the three header fields added by one ARC intermediary
+type arcSet struct {
+	aar  *headerField
+	ams  *headerField
+	seal *headerField
+}
+
+// arcChain is a validated ARC chain, ordered from instance 1 (first intermediary) to N
+type arcChain []arcSet
+
+// verifyARC validates the ARC chain of a message (RFC 8617 section 5.2): structure, chain validation
+// statuses, the most recent ARC-Message-Signature and every ARC-Seal.
+// It returns nil without error when the message has no ARC header at all.
+func (v *Verifier) verifyARC(fields []headerField, body []byte) (arcChain, error) {
+	sets := make(map[int]*arcSet)
+	set := func(i int) *arcSet {
+		if sets[i] == nil {
+			sets[i] = &arcSet{}
+		}
+		return sets[i]
+	}
+
+	for i := range fields {
+		f := &fields[i]
+
+		var instance int
+		var slot **headerField
+		switch strings.ToLower(f.name) {
+		case "arc-authentication-results":
+			m := aarInstanceRe.FindStringSubmatch(f.value())
+			if m == nil {
+				return nil, errors.New("ARC-Authentication-Results without instance")
+			}
+			instance, _ = strconv.Atoi(m[1])
+			slot = &set(instance).aar
+		case "arc-message-signature":
+			instance, _ = strconv.Atoi(parseTags(f.value())["i"])
+			slot = &set(instance).ams
+		case "arc-seal":
+			instance, _ = strconv.Atoi(parseTags(f.value())["i"])
+			slot = &set(instance).seal
+		default:
+			continue
+		}
+
+		if instance < 1 || instance > maxARCInstances {
+			return nil, fmt.Errorf("invalid ARC instance %d", instance)
+		}
+		if *slot != nil {
+			return nil, fmt.Errorf("duplicate %s for instance %d", f.name, instance)
+		}
+		*slot = f
+	}
+
+	if len(sets) == 0 {
+		return nil, nil
+	}
+
+	chain := make(arcChain, len(sets))
+	for i := 1; i <= len(sets); i++ {
+		s, ok := sets[i]
+		if !ok || s.aar == nil || s.ams == nil || s.seal == nil {
+			return nil, fmt.Errorf("incomplete ARC set for instance %d", i)
+		}
+
+		cv := parseTags(s.seal.value())["cv"]
+		if (i == 1 && cv != "none") || (i > 1 && cv != "pass") {
+			return nil, fmt.Errorf("ARC-Seal instance %d has cv=%s", i, cv)
+		}
+		chain[i-1] = *s
+	}
+
+	latest := chain[len(chain)-1]
+	if err := v.verifyARCMessageSignature(fields, body, latest.ams); err != nil {
+		return nil, fmt.Errorf("ARC-Message-Signature instance %d: %w", len(chain), err)
+	}
+
+	for n := len(chain); n >= 1; n-- {
+		if err := v.verifyARCSeal(chain[:n]); err != nil {
+			return nil, fmt.Errorf("ARC-Seal instance %d: %w", n, err)
+		}
+	}
+
+	return chain, nil
+}
+
+// verifyARCMessageSignature verifies an ARC-Message-Signature, which works like a DKIM signature
+func (v *Verifier) verifyARCMessageSignature(fields []headerField, body []byte, ams *headerField) error {
+	tags := parseTags(ams.value())
+
+	headerCan, bodyCan := "simple", "simple"
+	if c, ok := tags["c"]; ok {
+		headerCan, bodyCan, _ = strings.Cut(c, "/")
+		if bodyCan == "" {
+			bodyCan = "simple"
+		}
+	}
+
+	canonicalBody := canonicalizeBody(body, bodyCan)
+	if l, ok := tags["l"]; ok {
+		length, err := strconv.Atoi(l)
+		if err != nil || length < 0 || length > len(canonicalBody) {
+			return fmt.Errorf("invalid body length %q", l)
+		}
+		canonicalBody = canonicalBody[:length]
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		return errors.New("body hash mismatch")
+	}
+
+	var data strings.Builder
+	used := make(map[*headerField]bool)
+	for _, name := range strings.Split(tags["h"], ":") {
+		if strings.EqualFold(name, "arc-seal") {
+			return errors.New("ARC-Seal must not be signed by ARC-Message-Signature")
+		}
+		// Pick the last unused instance of the field (RFC 6376 section 5.4.2)
+		for i := len(fields) - 1; i >= 0; i-- {
+			f := &fields[i]
+			if strings.EqualFold(f.name, name) && !used[f] {
+				used[f] = true
+				data.WriteString(canonicalizeHeader(f.raw, headerCan))
+				break
+			}
+		}
+	}
+	data.WriteString(strings.TrimSuffix(canonicalizeHeader(removeSignatureValue(ams.raw), headerCan), "\r\n"))
+
+	return v.verifySignature(tags, []byte(data.String()))
+}
+
+// verifyARCSeal verifies the ARC-Seal of the last set of the given chain prefix (RFC 8617 section 5.1.1)
+func (v *Verifier) verifyARCSeal(chain arcChain) error {
+	var data strings.Builder
+	for i, s := range chain {
+		data.WriteString(canonicalizeHeader(s.aar.raw, "relaxed"))
+		data.WriteString(canonicalizeHeader(s.ams.raw, "relaxed"))
+		if i < len(chain)-1 {
+			data.WriteString(canonicalizeHeader(s.seal.raw, "relaxed"))
+		}
+	}
+
+	seal := chain[len(chain)-1].seal
+	data.WriteString(strings.TrimSuffix(canonicalizeHeader(removeSignatureValue(seal.raw), "relaxed"), "\r\n"))
+
+	return v.verifySignature(parseTags(seal.value()), []byte(data.String()))
+}
+
+// sealer returns the domain that sealed this ARC set
+func (s arcSet) sealer() string {
+	return strings.ToLower(parseTags(s.seal.value())["d"])
+}
+
+// results returns the authentication results recorded by the intermediary in its ARC-Authentication-Results
+func (s arcSet) results() []authres.Result {
+	value := aarInstanceRe.ReplaceAllString(s.aar.value(), "")
+	_, results, err := authres.Parse(value)
+	if err != nil {
+		return nil
+	}
+	return results
+}
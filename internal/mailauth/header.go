@@ -0,0 +1,144 @@
+package mailauth
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// headerField is a raw header field as found in the message, including folding and the trailing CRLF
+type headerField struct {
+	name string
+	raw  string
+}
+
+// value returns the unfolded field value
+func (f headerField) value() string {
+	_, v, _ := strings.Cut(f.raw, ":")
+	v = strings.ReplaceAll(v, "\r\n", "")
+	return strings.TrimSpace(v)
+}
+
+var (
+	wspRe       = regexp.MustCompile(`[ \t]+`)
+	signatureRe = regexp.MustCompile(`((?:^|;)[ \t\r\n]*b[ \t\r\n]*=)[^;]*`)
+	// receivedByRe extracts the host that added a Received header
+	receivedByRe = regexp.MustCompile(`(?i)\bby\s+([^\s;()]+)`)
+)
+
+// splitMessage normalizes line endings to CRLF and splits the message into its header fields and body
+func splitMessage(raw []byte) ([]headerField, []byte) {
+	raw = normalizeCRLF(raw)
+
+	var head, body []byte
+	if idx := bytes.Index(raw, []byte("\r\n\r\n")); idx >= 0 {
+		head, body = raw[:idx+2], raw[idx+4:]
+	} else {
+		head = raw
+	}
+
+	var fields []headerField
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].raw += line
+			continue
+		}
+		name, _, _ := strings.Cut(line, ":")
+		fields = append(fields, headerField{name: strings.TrimSpace(name), raw: line})
+	}
+
+	return fields, body
+}
+
+// normalizeCRLF converts bare LF line endings to CRLF
+func normalizeCRLF(raw []byte) []byte {
+	if !bytes.Contains(raw, []byte("\n")) || bytes.Count(raw, []byte("\r\n")) == bytes.Count(raw, []byte("\n")) {
+		return raw
+	}
+	raw = bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+}
+
+// fieldsNamed returns the fields with the given (case-insensitive) name, in message order
+func fieldsNamed(fields []headerField, name string) []headerField {
+	var out []headerField
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// parseTags parses a DKIM-style tag list ("a=rsa-sha256; d=example.com; ...")
+func parseTags(value string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(value, ";") {
+		k, v, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.TrimSpace(k)] = stripWhitespace(v)
+	}
+	return tags
+}
+
+// stripWhitespace removes all whitespace (including folding) from a tag value
+func stripWhitespace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\t' || r == '\r' || r == '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// removeSignatureValue empties the b= tag of a signature header field
+func removeSignatureValue(raw string) string {
+	name, value, _ := strings.Cut(raw, ":")
+	return name + ":" + signatureRe.ReplaceAllString(value, "$1")
+}
+
+// canonicalizeHeader applies the given header canonicalization ("simple" or "relaxed") to a raw field
+func canonicalizeHeader(raw, algo string) string {
+	if algo != "relaxed" {
+		return raw
+	}
+
+	name, value, _ := strings.Cut(raw, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = wspRe.ReplaceAllString(value, " ")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(value) + "\r\n"
+}
+
+// canonicalizeBody applies the given body canonicalization ("simple" or "relaxed") to the body
+func canonicalizeBody(body []byte, algo string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	// The element after the final CRLF is not a line
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if algo == "relaxed" {
+		for i, line := range lines {
+			lines[i] = strings.TrimRight(wspRe.ReplaceAllString(line, " "), " ")
+		}
+	}
+
+	// Ignore empty lines at the end of the body
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		if algo == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,99 @@
+package mailauth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// publicKey is a DKIM public key as published in DNS
+type publicKey struct {
+	rsa     *rsa.PublicKey
+	ed25519 ed25519.PublicKey
+}
+
+// lookupKey fetches and parses the DKIM key record "<selector>._domainkey.<domain>"
+func (v *Verifier) lookupKey(domain, selector string) (*publicKey, error) {
+	records, err := v.resolver.LookupTXT(selector + "._domainkey." + domain)
+	if err != nil {
+		return nil, fmt.Errorf("key lookup for %s/%s failed: %w", domain, selector, err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no key record for %s/%s", domain, selector)
+	}
+
+	tags := parseTags(strings.Join(records, ""))
+	if version, ok := tags["v"]; ok && version != "DKIM1" {
+		return nil, fmt.Errorf("unsupported key record version %q", version)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid key data: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("key has been revoked")
+	}
+
+	switch tags["k"] {
+	case "", "rsa":
+		pub, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			// Some records publish a bare PKCS#1 key
+			if rsaPub, err := x509.ParsePKCS1PublicKey(data); err == nil {
+				return &publicKey{rsa: rsaPub}, nil
+			}
+			return nil, fmt.Errorf("invalid RSA key: %w", err)
+		}
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, errors.New("key is not an RSA key")
+		}
+		return &publicKey{rsa: rsaPub}, nil
+	case "ed25519":
+		if len(data) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+		return &publicKey{ed25519: ed25519.PublicKey(data)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", tags["k"])
+	}
+}
+
+// verifySignature checks the b= signature of a signature header over the given canonicalized data
+func (v *Verifier) verifySignature(tags map[string]string, data []byte) error {
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	key, err := v.lookupKey(tags["d"], tags["s"])
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256(data)
+	switch tags["a"] {
+	case "rsa-sha256":
+		if key.rsa == nil {
+			return errors.New("algorithm does not match key type")
+		}
+		return rsa.VerifyPKCS1v15(key.rsa, crypto.SHA256, hashed[:], signature)
+	case "ed25519-sha256":
+		if key.ed25519 == nil {
+			return errors.New("algorithm does not match key type")
+		}
+		if !ed25519.Verify(key.ed25519, hashed[:], signature) {
+			return errors.New("invalid Ed25519 signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", tags["a"])
+	}
+}
@@ -0,0 +1,273 @@
+package mailauth
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+
+	"netflix-household-validator/internal/models"
+
+	"github.com/emersion/go-msgauth/authres"
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// Resolver looks up DNS TXT records. It is pluggable so that tests can run offline with generated keys.
+type Resolver interface {
+	LookupTXT(domain string) ([]string, error)
+}
+
+// DNSResolver resolves TXT records with the system resolver
+type DNSResolver struct{}
+
+// LookupTXT implements Resolver
+func (DNSResolver) LookupTXT(domain string) ([]string, error) {
+	return net.LookupTXT(domain)
+}
+
+// Method identifies the mechanism that authenticated an email
+type Method string
+
+const (
+	MethodDKIM        Method = "dkim"
+	MethodARC         Method = "arc"
+	MethodAuthResults Method = "authentication-results"
+)
+
+// Result is the outcome of the authenticity verification of an email
+type Result struct {
+	Pass   bool
+	Method Method
+	// Domain is the domain the email was authenticated for
+	Domain string
+	// Reason explains the decision (failure details, or how a forwarded email was authenticated)
+	Reason string
+}
+
+type Verifier struct {
+	cfg      models.AuthConfig
+	resolver Resolver
+}
+
+// NewVerifier creates a new Verifier with the given configuration. A nil resolver uses DNS.
+func NewVerifier(cfg models.AuthConfig, resolver Resolver) *Verifier {
+	if resolver == nil {
+		resolver = DNSResolver{}
+	}
+	return &Verifier{
+		cfg:      cfg,
+		resolver: resolver,
+	}
+}
+
+// Verify checks that the email really comes from the configured domain (netflix.com by default).
+// Forwarded emails are accepted when the attached original is authenticated for that domain, or when
+// the forwarding message itself is authenticated for the forwarder's domain.
+func (v *Verifier) Verify(email *models.Email) Result {
+	if email.ForwardedBy == "" {
+		return v.verifyFor(email.Raw, v.cfg.Domain)
+	}
+
+	if len(email.ForwardedRaw) > 0 {
+		if result := v.verifyFor(email.ForwardedRaw, v.cfg.Domain); result.Pass {
+			result.Reason = "attached original message authenticated"
+			return result
+		}
+	}
+
+	result := v.verifyFor(email.Raw, domainOf(email.ForwardedBy))
+	if result.Pass {
+		result.Reason = "forwarding message authenticated for " + result.Domain
+	}
+	return result
+}
+
+// verifyFor tries DKIM, trusted Authentication-Results and ARC, in that order, for the given domain
+func (v *Verifier) verifyFor(raw []byte, domain string) Result {
+	if len(raw) == 0 {
+		return Result{Reason: "raw message unavailable"}
+	}
+	if domain == "" {
+		return Result{Reason: "no domain to authenticate"}
+	}
+
+	var reasons []string
+
+	d, err := v.checkDKIM(raw, domain)
+	if err == nil {
+		return Result{Pass: true, Method: MethodDKIM, Domain: d}
+	}
+	reasons = append(reasons, err.Error())
+
+	fields, body := splitMessage(raw)
+
+	if d, ok := v.checkAuthResults(fields, domain); ok {
+		return Result{Pass: true, Method: MethodAuthResults, Domain: d}
+	}
+
+	d, err = v.checkARC(fields, body, domain)
+	if err == nil {
+		return Result{Pass: true, Method: MethodARC, Domain: d}
+	}
+	reasons = append(reasons, err.Error())
+
+	return Result{Domain: domain, Reason: strings.Join(reasons, "; ")}
+}
+
+// checkDKIM returns the signing domain of a valid DKIM signature aligned with domain and covering the From header
+func (v *Verifier) checkDKIM(raw []byte, domain string) (string, error) {
+	verifications, err := dkim.VerifyWithOptions(bytes.NewReader(raw), &dkim.VerifyOptions{
+		LookupTXT:        v.resolver.LookupTXT,
+		MaxVerifications: 10,
+	})
+	if err != nil && len(verifications) == 0 {
+		return "", fmt.Errorf("dkim: %w", err)
+	}
+	if len(verifications) == 0 {
+		return "", fmt.Errorf("dkim: no signature")
+	}
+
+	var failures []string
+	for _, ver := range verifications {
+		switch {
+		case ver.Err != nil:
+			failures = append(failures, fmt.Sprintf("d=%s: %v", ver.Domain, ver.Err))
+		case !aligned(ver.Domain, domain):
+			failures = append(failures, fmt.Sprintf("d=%s: not aligned with %s", ver.Domain, domain))
+		case !signsFrom(ver.HeaderKeys):
+			failures = append(failures, fmt.Sprintf("d=%s: From header not signed", ver.Domain))
+		default:
+			return ver.Domain, nil
+		}
+	}
+
+	return "", fmt.Errorf("dkim: %s", strings.Join(failures, ", "))
+}
+
+// checkAuthResults looks for a DKIM or DMARC pass aligned with domain in the Authentication-Results
+// headers added by one of the trusted servers
+func (v *Verifier) checkAuthResults(fields []headerField, domain string) (string, bool) {
+	if len(v.cfg.TrustedAuthServIDs) == 0 {
+		return "", false
+	}
+
+	for _, f := range fieldsNamed(v.topTraceFields(fields), "Authentication-Results") {
+		id, results, err := authres.Parse(f.value())
+		if err != nil || !v.isTrustedAuthServID(id) {
+			continue
+		}
+		if d, ok := passingDomain(results, domain); ok {
+			return d, true
+		}
+	}
+
+	return "", false
+}
+
+// topTraceFields returns the header fields above the topmost Received header added by a trusted server, or above
+// the first Received header when none names one. The fields further down were already in the message when it
+// reached the trusted server: their Authentication-Results may be forged by the sender.
+func (v *Verifier) topTraceFields(fields []headerField) []headerField {
+	first := -1
+	for i, f := range fields {
+		if !strings.EqualFold(f.name, "Received") {
+			continue
+		}
+		if first < 0 {
+			first = i
+		}
+		if m := receivedByRe.FindStringSubmatch(f.value()); m != nil && v.isTrustedAuthServID(m[1]) {
+			return fields[:i]
+		}
+	}
+	if first < 0 {
+		return fields
+	}
+	return fields[:first]
+}
+
+// checkARC validates the ARC chain and looks for an intermediary sealed by a trusted domain that
+// recorded a DKIM or DMARC pass aligned with domain
+func (v *Verifier) checkARC(fields []headerField, body []byte, domain string) (string, error) {
+	chain, err := v.verifyARC(fields, body)
+	if err != nil {
+		return "", fmt.Errorf("arc: %w", err)
+	}
+	if chain == nil {
+		return "", fmt.Errorf("arc: no chain")
+	}
+
+	for _, set := range chain {
+		if !v.isTrustedSealer(set.sealer()) {
+			continue
+		}
+		if d, ok := passingDomain(set.results(), domain); ok {
+			return d, nil
+		}
+	}
+
+	return "", fmt.Errorf("arc: no trusted sealer recorded a pass for %s", domain)
+}
+
+// isTrustedAuthServID reports whether the authserv-id belongs to one of the trusted servers
+func (v *Verifier) isTrustedAuthServID(id string) bool {
+	for _, trusted := range v.cfg.TrustedAuthServIDs {
+		if strings.EqualFold(id, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrustedSealer reports whether the ARC sealer domain is (a subdomain of) a trusted sealer
+func (v *Verifier) isTrustedSealer(sealer string) bool {
+	for _, trusted := range v.cfg.TrustedARCSealers {
+		if aligned(sealer, trusted) {
+			return true
+		}
+	}
+	return false
+}
+
+// passingDomain returns the domain of a DKIM or DMARC pass aligned with domain
+func passingDomain(results []authres.Result, domain string) (string, bool) {
+	for _, r := range results {
+		switch r := r.(type) {
+		case *authres.DKIMResult:
+			if r.Value == authres.ResultPass && aligned(r.Domain, domain) {
+				return r.Domain, true
+			}
+		case *authres.DMARCResult:
+			if r.Value == authres.ResultPass && aligned(r.From, domain) {
+				return r.From, true
+			}
+		}
+	}
+	return "", false
+}
+
+// aligned reports whether d is domain or one of its subdomains (relaxed alignment)
+func aligned(d, domain string) bool {
+	d = strings.ToLower(strings.TrimSuffix(d, "."))
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	return d != "" && (d == domain || strings.HasSuffix(d, "."+domain))
+}
+
+// signsFrom reports whether the signed header list includes From
+func signsFrom(keys []string) bool {
+	for _, k := range keys {
+		if strings.EqualFold(k, "From") {
+			return true
+		}
+	}
+	return false
+}
+
+// domainOf returns the domain part of an email address
+func domainOf(address string) string {
+	_, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(domain)
+}
@@ -0,0 +1,380 @@
+package mailauth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"netflix-household-validator/internal/models"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+// fakeResolver serves DKIM key records from memory
+type fakeResolver map[string]string
+
+func (r fakeResolver) LookupTXT(domain string) ([]string, error) {
+	record, ok := r[domain]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", domain)
+	}
+	return []string{record}, nil
+}
+
+// testKey is a generated signing key published in a fakeResolver
+type testKey struct {
+	domain   string
+	selector string
+	key      *rsa.PrivateKey
+}
+
+func newTestKey(t *testing.T, resolver fakeResolver, domain, selector string) *testKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey() error: %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey() error: %v", err)
+	}
+	resolver[selector+"._domainkey."+domain] = "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(pub)
+
+	return &testKey{domain: domain, selector: selector, key: key}
+}
+
+// dkimSign signs the message with go-msgauth
+func (k *testKey) dkimSign(t *testing.T, raw string, canonicalization dkim.Canonicalization) []byte {
+	t.Helper()
+
+	var signed bytes.Buffer
+	err := dkim.Sign(&signed, strings.NewReader(crlf(raw)), &dkim.SignOptions{
+		Domain:                 k.domain,
+		Selector:               k.selector,
+		Signer:                 k.key,
+		HeaderCanonicalization: canonicalization,
+		BodyCanonicalization:   canonicalization,
+		HeaderKeys:             []string{"From", "To", "Subject"},
+	})
+	if err != nil {
+		t.Fatalf("dkim.Sign() error: %v", err)
+	}
+	return signed.Bytes()
+}
+
+// sign returns the base64 RSA-SHA256 signature of data
+func (k *testKey) sign(t *testing.T, data string) string {
+	t.Helper()
+
+	hashed := sha256.Sum256([]byte(data))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15() error: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// arcSeal adds an ARC set for the given instance to the message, as an intermediary would
+func (k *testKey) arcSeal(t *testing.T, raw []byte, instance int, results string) []byte {
+	t.Helper()
+
+	fields, body := splitMessage(raw)
+
+	bodyHash := sha256.Sum256(canonicalizeBody(body, "relaxed"))
+	ams := fmt.Sprintf("ARC-Message-Signature: i=%d; a=rsa-sha256; c=relaxed/relaxed; d=%s; s=%s;\r\n\th=from:to:subject; bh=%s; b=",
+		instance, k.domain, k.selector, base64.StdEncoding.EncodeToString(bodyHash[:]))
+	var amsData strings.Builder
+	for _, name := range []string{"from", "to", "subject"} {
+		named := fieldsNamed(fields, name)
+		amsData.WriteString(canonicalizeHeader(named[len(named)-1].raw, "relaxed"))
+	}
+	amsData.WriteString(strings.TrimSuffix(canonicalizeHeader(ams+"\r\n", "relaxed"), "\r\n"))
+	ams += k.sign(t, amsData.String()) + "\r\n"
+
+	aar := fmt.Sprintf("ARC-Authentication-Results: i=%d; mx.%s; %s\r\n", instance, k.domain, results)
+
+	cv := "pass"
+	if instance == 1 {
+		cv = "none"
+	}
+	seal := fmt.Sprintf("ARC-Seal: i=%d; a=rsa-sha256; cv=%s; d=%s; s=%s; b=", instance, cv, k.domain, k.selector)
+
+	var sealData strings.Builder
+	for i := 1; i < instance; i++ {
+		for _, name := range []string{"ARC-Authentication-Results", "ARC-Message-Signature", "ARC-Seal"} {
+			for _, f := range fieldsNamed(fields, name) {
+				if arcInstance(f) == i {
+					sealData.WriteString(canonicalizeHeader(f.raw, "relaxed"))
+				}
+			}
+		}
+	}
+	sealData.WriteString(canonicalizeHeader(aar, "relaxed"))
+	sealData.WriteString(canonicalizeHeader(ams, "relaxed"))
+	sealData.WriteString(strings.TrimSuffix(canonicalizeHeader(seal+"\r\n", "relaxed"), "\r\n"))
+	seal += k.sign(t, sealData.String()) + "\r\n"
+
+	return append([]byte(seal+ams+aar), raw...)
+}
+
+// arcInstance returns the instance number of an ARC header field
+func arcInstance(f headerField) int {
+	var i int
+	if m := aarInstanceRe.FindStringSubmatch(f.value()); m != nil {
+		_, _ = fmt.Sscan(m[1], &i)
+		return i
+	}
+	_, _ = fmt.Sscan(parseTags(f.value())["i"], &i)
+	return i
+}
+
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+const netflixMessage = `From: Netflix <info@account.netflix.com>
+To: member@example.com
+Subject: Important: How to update your Netflix Household
+Content-Type: text/plain; charset=utf-8
+
+Hi,
+https://www.netflix.com/account/update-primary-location?nftoken=ABC
+`
+
+func newTestVerifier(resolver fakeResolver) *Verifier {
+	return NewVerifier(models.AuthConfig{
+		Domain:             "netflix.com",
+		TrustedAuthServIDs: models.StringList{"mx.example.com"},
+		TrustedARCSealers:  models.StringList{"google.com"},
+	}, resolver)
+}
+
+func TestVerify_DKIM(t *testing.T) {
+	resolver := fakeResolver{}
+	netflixKey := newTestKey(t, resolver, "netflix.com", "s1")
+	otherKey := newTestKey(t, resolver, "evil.example", "s1")
+
+	tests := []struct {
+		name     string
+		raw      []byte
+		expected bool
+	}{
+		{
+			name:     "Valid netflix.com signature",
+			raw:      netflixKey.dkimSign(t, netflixMessage, dkim.CanonicalizationRelaxed),
+			expected: true,
+		},
+		{
+			name:     "Tampered body",
+			raw:      bytes.Replace(netflixKey.dkimSign(t, netflixMessage, dkim.CanonicalizationSimple), []byte("nftoken=ABC"), []byte("nftoken=XYZ"), 1),
+			expected: false,
+		},
+		{
+			name:     "Signature from another domain",
+			raw:      otherKey.dkimSign(t, netflixMessage, dkim.CanonicalizationRelaxed),
+			expected: false,
+		},
+		{
+			name:     "Unsigned",
+			raw:      []byte(crlf(netflixMessage)),
+			expected: false,
+		},
+	}
+
+	v := newTestVerifier(resolver)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := v.Verify(&models.Email{Raw: tt.raw})
+			if result.Pass != tt.expected {
+				t.Errorf("Verify() pass = %v, want %v (reason: %s)", result.Pass, tt.expected, result.Reason)
+			}
+			if result.Pass && result.Method != MethodDKIM {
+				t.Errorf("Verify() method = %s, want %s", result.Method, MethodDKIM)
+			}
+		})
+	}
+}
+
+func TestVerify_AuthenticationResults(t *testing.T) {
+	v := newTestVerifier(fakeResolver{})
+
+	trusted := "Authentication-Results: mx.example.com; dkim=pass header.d=netflix.com; spf=pass\n" + netflixMessage
+	if result := v.Verify(&models.Email{Raw: []byte(trusted)}); !result.Pass || result.Method != MethodAuthResults {
+		t.Errorf("Expected trusted Authentication-Results to pass, got %+v", result)
+	}
+
+	untrusted := "Authentication-Results: mx.attacker.example; dkim=pass header.d=netflix.com\n" + netflixMessage
+	if result := v.Verify(&models.Email{Raw: []byte(untrusted)}); result.Pass {
+		t.Errorf("Expected untrusted Authentication-Results to fail, got %+v", result)
+	}
+
+	failed := "Authentication-Results: mx.example.com; dkim=fail header.d=netflix.com\n" + netflixMessage
+	if result := v.Verify(&models.Email{Raw: []byte(failed)}); result.Pass {
+		t.Errorf("Expected dkim=fail to fail, got %+v", result)
+	}
+}
+
+func TestVerify_ForgedAuthenticationResults(t *testing.T) {
+	v := newTestVerifier(fakeResolver{})
+
+	tests := []struct {
+		name     string
+		header   string
+		expected bool
+	}{
+		{"added by the trusted server", "Authentication-Results: mx.example.com; dkim=pass header.d=netflix.com\n" +
+			"Received: from mail.netflix.com by mx.example.com with ESMTPS; Fri, 17 Oct 2026 12:00:00 +0000\n", true},
+		{"added before an internal hop", "Received: from mx.example.com by imap.example.com with LMTP; Fri, 17 Oct 2026 12:00:01 +0000\n" +
+			"Authentication-Results: mx.example.com; dkim=pass header.d=netflix.com\n" +
+			"Received: from mail.netflix.com by mx.example.com with ESMTPS; Fri, 17 Oct 2026 12:00:00 +0000\n", true},
+		{"forged by the sender", "Authentication-Results: mx.example.com; dkim=none\n" +
+			"Received: from attacker.example by mx.example.com with ESMTP; Fri, 17 Oct 2026 12:00:00 +0000\n" +
+			"Authentication-Results: mx.example.com; dkim=pass header.d=netflix.com\n", false},
+		{"forged below an unknown hop", "Received: from attacker.example by relay.example.net; Fri, 17 Oct 2026 12:00:00 +0000\n" +
+			"Authentication-Results: mx.example.com; dkim=pass header.d=netflix.com\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := v.Verify(&models.Email{Raw: []byte(tt.header + netflixMessage)})
+			if result.Pass != tt.expected {
+				t.Errorf("Verify() pass = %v, want %v (reason: %s)", result.Pass, tt.expected, result.Reason)
+			}
+		})
+	}
+}
+
+func TestVerify_ARC(t *testing.T) {
+	resolver := fakeResolver{}
+	netflixKey := newTestKey(t, resolver, "netflix.com", "s1")
+	googleKey := newTestKey(t, resolver, "google.com", "arc")
+	listKey := newTestKey(t, resolver, "lists.example.org", "arc")
+
+	// The forwarding hop rewrites the subject, breaking the original DKIM signature
+	signed := netflixKey.dkimSign(t, netflixMessage, dkim.CanonicalizationSimple)
+	modified := bytes.Replace(signed, []byte("Subject: Important"), []byte("Subject: [EXT] Important"), 1)
+
+	sealedByGoogle := googleKey.arcSeal(t, modified, 1, "dkim=pass header.d=netflix.com")
+	resealed := listKey.arcSeal(t, sealedByGoogle, 2, "arc=pass")
+
+	tests := []struct {
+		name     string
+		raw      []byte
+		expected bool
+	}{
+		{
+			name:     "Trusted sealer recorded a pass",
+			raw:      sealedByGoogle,
+			expected: true,
+		},
+		{
+			name:     "Two hops, first sealed by trusted sealer",
+			raw:      resealed,
+			expected: true,
+		},
+		{
+			name:     "Untrusted sealer",
+			raw:      listKey.arcSeal(t, modified, 1, "dkim=pass header.d=netflix.com"),
+			expected: false,
+		},
+		{
+			name:     "Trusted sealer recorded a failure",
+			raw:      googleKey.arcSeal(t, modified, 1, "dkim=fail header.d=netflix.com"),
+			expected: false,
+		},
+		{
+			name:     "Body modified after sealing",
+			raw:      bytes.Replace(sealedByGoogle, []byte("nftoken=ABC"), []byte("nftoken=XYZ"), 1),
+			expected: false,
+		},
+		{
+			name:     "Whitespace change in sealed header (relaxed canonicalization)",
+			raw:      bytes.Replace(sealedByGoogle, []byte("dkim=pass"), []byte("dkim=pass "), 1),
+			expected: true,
+		},
+		{
+			name:     "Tampered seal",
+			raw:      bytes.Replace(sealedByGoogle, []byte("header.d=netflix.com"), []byte("header.d=netflix.co"), 1),
+			expected: false,
+		},
+	}
+
+	v := newTestVerifier(resolver)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := v.Verify(&models.Email{Raw: tt.raw})
+			if result.Pass != tt.expected {
+				t.Errorf("Verify() pass = %v, want %v (reason: %s)", result.Pass, tt.expected, result.Reason)
+			}
+			if result.Pass && result.Method != MethodARC {
+				t.Errorf("Verify() method = %s, want %s", result.Method, MethodARC)
+			}
+		})
+	}
+}
+
+func TestVerify_Forwarded(t *testing.T) {
+	resolver := fakeResolver{}
+	netflixKey := newTestKey(t, resolver, "netflix.com", "s1")
+	memberKey := newTestKey(t, resolver, "example.com", "s1")
+
+	forward := `From: Member <member@example.com>
+To: validator@example.com
+Subject: Fwd: Important: How to update your Netflix Household
+Content-Type: text/plain; charset=utf-8
+
+---------- Forwarded message ---------
+From: Netflix <info@account.netflix.com>
+Subject: Important: How to update your Netflix Household
+
+https://www.netflix.com/account/update-primary-location?nftoken=ABC
+`
+
+	v := newTestVerifier(resolver)
+
+	signedForward := &models.Email{ForwardedBy: "member@example.com", Raw: memberKey.dkimSign(t, forward, dkim.CanonicalizationRelaxed)}
+	if result := v.Verify(signedForward); !result.Pass || result.Domain != "example.com" {
+		t.Errorf("Expected forward signed by the forwarder domain to pass, got %+v", result)
+	}
+
+	spoofedForward := &models.Email{ForwardedBy: "member@example.com", Raw: []byte(crlf(forward))}
+	if result := v.Verify(spoofedForward); result.Pass {
+		t.Errorf("Expected unsigned forward to fail, got %+v", result)
+	}
+
+	attached := &models.Email{
+		ForwardedBy:  "member@example.com",
+		Raw:          []byte(crlf(forward)),
+		ForwardedRaw: netflixKey.dkimSign(t, netflixMessage, dkim.CanonicalizationRelaxed),
+	}
+	if result := v.Verify(attached); !result.Pass || result.Domain != "netflix.com" {
+		t.Errorf("Expected signed attached original to pass, got %+v", result)
+	}
+}
+
+func TestCanonicalization_MatchesGoMsgAuth(t *testing.T) {
+	resolver := fakeResolver{}
+	key := newTestKey(t, resolver, "netflix.com", "s1")
+	v := newTestVerifier(resolver)
+
+	message := "From: Netflix <info@account.netflix.com>\nTo:   member@example.com\nSubject: Important:\n\t How  to update \n\nLine with trailing spaces   \n\tIndented\tline\n\n\n"
+
+	for _, c := range []dkim.Canonicalization{dkim.CanonicalizationSimple, dkim.CanonicalizationRelaxed} {
+		t.Run(string(c), func(t *testing.T) {
+			fields, body := splitMessage(key.dkimSign(t, message, c))
+			signature := fieldsNamed(fields, "DKIM-Signature")[0]
+
+			// ARC-Message-Signature verification shares the DKIM algorithm
+			if err := v.verifyARCMessageSignature(fields, body, &signature); err != nil {
+				t.Errorf("verification of a go-msgauth signature failed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package maildir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/models"
+)
+
+// infoSeparator separates the unique name of a Maildir message from its info ("2," followed by the flags)
+const infoSeparator = ":2,"
+
+// Maildir is a local Maildir folder used as a message source. New messages are read from new/ and, once handled,
+// moved to cur/ with the configured flags, as a mail client would do.
+type Maildir struct {
+	path  string
+	flags string
+}
+
+// New opens the Maildir at path. flags are the info flags set on handled messages (e.g. "S").
+func New(path, flags string) (*Maildir, error) {
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		info, err := os.Stat(filepath.Join(path, sub))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Maildir %s: %w", path, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("invalid Maildir %s: %s is not a directory", path, sub)
+		}
+	}
+	return &Maildir{path: path, flags: flags}, nil
+}
+
+// Path returns the Maildir folder
+func (m *Maildir) Path() string {
+	return m.path
+}
+
+// List returns the names of the messages delivered to new/ within the given duration, oldest first
+func (m *Maildir) List(since time.Duration) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(m.path, "new"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing Maildir messages: %w", err)
+	}
+
+	cutoff := time.Now().Add(-since)
+	type candidate struct {
+		name    string
+		modTime time.Time
+	}
+	var candidates []candidate
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Moved away in the meantime (e.g. by a mail client)
+			continue
+		}
+		if info.ModTime().Before(cutoff) {
+			continue
+		}
+		candidates = append(candidates, candidate{name: entry.Name(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+
+	names := make([]string, len(candidates))
+	for i, c := range candidates {
+		names[i] = c.name
+	}
+	return names, nil
+}
+
+// Fetch reads and parses the message new/<name>. Its delivery time is used as internal date.
+func (m *Maildir) Fetch(name string) (*models.Email, error) {
+	path, err := m.newPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Maildir message %s: %w", name, err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Maildir message %s: %w", name, err)
+	}
+
+	email, err := mailparse.ParseRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	email.InternalDate = info.ModTime()
+	return email, nil
+}
+
+// MarkProcessed moves new/<name> to cur/ with the configured flags
+func (m *Maildir) MarkProcessed(name string) error {
+	path, err := m.newPath(name)
+	if err != nil {
+		return err
+	}
+
+	unique, _, _ := strings.Cut(name, infoSeparator)
+	target := filepath.Join(m.path, "cur", unique+infoSeparator+sortFlags(m.flags))
+	if err := os.Rename(path, target); err != nil {
+		return fmt.Errorf("error moving Maildir message %s to cur/: %w", name, err)
+	}
+	return nil
+}
+
+// newPath returns the path of a message of new/, refusing names that would escape it
+func (m *Maildir) newPath(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid Maildir message name %q", name)
+	}
+	return filepath.Join(m.path, "new", name), nil
+}
+
+// sortFlags returns the flags without duplicates in ASCII order, as the Maildir specification requires
+func sortFlags(flags string) string {
+	seen := make(map[rune]bool)
+	var sorted []rune
+	for _, flag := range flags {
+		if !seen[flag] {
+			seen[flag] = true
+			sorted = append(sorted, flag)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return string(sorted)
+}
@@ -0,0 +1,96 @@
+package maildir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMessage = "From: Netflix <info@account.netflix.com>\r\nTo: user@example.com\r\nSubject: Test Subject\r\n\r\n" +
+	"https://www.netflix.com/account/update-primary-location?nftoken=ABC\r\n"
+
+func newTestMaildir(t *testing.T, flags string) *Maildir {
+	t.Helper()
+	dir := t.TempDir()
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o700); err != nil {
+			t.Fatalf("Mkdir() error = %v", err)
+		}
+	}
+	m, err := New(dir, flags)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return m
+}
+
+// deliver writes a message to tmp/ then moves it to new/, as an MDA does
+func deliver(t *testing.T, m *Maildir, name string, modTime time.Time) {
+	t.Helper()
+	tmp := filepath.Join(m.path, "tmp", name)
+	if err := os.WriteFile(tmp, []byte(testMessage), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes() error = %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(m.path, "new", name)); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+}
+
+func TestNew_InvalidMaildir(t *testing.T) {
+	if _, err := New(t.TempDir(), "S"); err == nil {
+		t.Error("New() on a directory without new/, cur/ and tmp/ should fail")
+	}
+}
+
+func TestMaildir_ListFetchAndMarkProcessed(t *testing.T) {
+	m := newTestMaildir(t, "aS")
+	now := time.Now()
+	deliver(t, m, "2.host", now.Add(-time.Minute))
+	deliver(t, m, "1.host", now.Add(-2*time.Minute))
+	deliver(t, m, "0.host", now.Add(-time.Hour))
+
+	names, err := m.List(15 * time.Minute)
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	if len(names) != 2 || names[0] != "1.host" || names[1] != "2.host" {
+		t.Fatalf("List() = %v, want [1.host 2.host]", names)
+	}
+
+	email, err := m.Fetch("1.host")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if email.From != "info@account.netflix.com" || len(email.Links) != 1 || email.TraceID == "" {
+		t.Errorf("Fetch() = %+v", email)
+	}
+	if d := email.InternalDate.Sub(now.Add(-2 * time.Minute)); d > time.Second || d < -time.Second {
+		t.Errorf("InternalDate = %v, want the delivery time", email.InternalDate)
+	}
+
+	if err := m.MarkProcessed("1.host"); err != nil {
+		t.Fatalf("MarkProcessed() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.path, "cur", "1.host:2,Sa")); err != nil {
+		t.Errorf("processed message not in cur/ with its flags: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(m.path, "new", "1.host")); !os.IsNotExist(err) {
+		t.Errorf("processed message still in new/: %v", err)
+	}
+}
+
+func TestMaildir_RejectsNamesOutsideNew(t *testing.T) {
+	m := newTestMaildir(t, "S")
+	for _, name := range []string{"", "../cur/x", ".hidden", "sub/x"} {
+		if _, err := m.Fetch(name); err == nil {
+			t.Errorf("Fetch(%q) should fail", name)
+		}
+		if err := m.MarkProcessed(name); err == nil {
+			t.Errorf("MarkProcessed(%q) should fail", name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package maildir
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Watcher reports the messages delivered to new/, using inotify
+type Watcher struct {
+	file *os.File
+}
+
+// Watch starts watching new/ for deliveries. Deliveries that happen while the caller is busy are kept by the kernel
+// and reported by the next Wait.
+func (m *Maildir) Watch() (*Watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init error: %w", err)
+	}
+
+	// Messages are written to tmp/ then moved (IN_MOVED_TO) or linked (IN_CREATE) into new/
+	mask := uint32(unix.IN_CREATE | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF)
+	if _, err := unix.InotifyAddWatch(fd, filepath.Join(m.path, "new"), mask); err != nil {
+		_ = unix.Close(fd)
+		return nil, fmt.Errorf("inotify watch error: %w", err)
+	}
+
+	// A non-blocking descriptor gives a pollable file, whose reads can be interrupted with a deadline
+	return &Watcher{file: os.NewFile(uintptr(fd), "inotify")}, nil
+}
+
+// Wait blocks until at least one message was delivered to new/ since the previous call, or ctx is cancelled
+func (w *Watcher) Wait(ctx context.Context) error {
+	_ = w.file.SetReadDeadline(time.Time{})
+	stop := context.AfterFunc(ctx, func() {
+		_ = w.file.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	n, err := w.file.Read(buf)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("inotify read error: %w", err)
+	}
+
+	for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+		event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		if event.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0 {
+			return fmt.Errorf("maildir new/ directory was removed or moved")
+		}
+		offset += unix.SizeofInotifyEvent + int(event.Len)
+	}
+	// Any other event, including a queue overflow, is a reason to list new/ again
+	return nil
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,40 @@
+package maildir
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWatcher_WaitReportsDeliveries(t *testing.T) {
+	m := newTestMaildir(t, "S")
+	watcher, err := m.Watch()
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	defer watcher.Close()
+
+	// A delivery made before Wait is reported
+	deliver(t, m, "1.host", time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := watcher.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	// Without delivery, Wait returns when ctx is cancelled
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := watcher.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The watcher is still usable after a cancelled Wait
+	deliver(t, m, "2.host", time.Now())
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := watcher.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+}
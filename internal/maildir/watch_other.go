@@ -0,0 +1,37 @@
+//go:build !linux
+
+package maildir
+
+import (
+	"context"
+	"time"
+)
+
+// pollInterval is how often new/ is listed again where inotify is not available
+const pollInterval = 10 * time.Second
+
+// Watcher reports the messages delivered to new/. Without inotify it simply wakes up every pollInterval.
+type Watcher struct{}
+
+// Watch starts watching new/ for deliveries
+func (m *Maildir) Watch() (*Watcher, error) {
+	return &Watcher{}, nil
+}
+
+// Wait blocks until new/ should be listed again, or ctx is cancelled
+func (w *Watcher) Wait(ctx context.Context) error {
+	timer := time.NewTimer(pollInterval)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Close stops watching
+func (w *Watcher) Close() error {
+	return nil
+}
@@ -0,0 +1,387 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"netflix-household-validator/internal/history"
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/oauth2"
+
+	"github.com/google/uuid"
+)
+
+// sendTimeout bounds a whole SMTP conversation
+const sendTimeout = 30 * time.Second
+
+// defaultOutcomes are replied when the configuration does not list them
+var defaultOutcomes = []models.Outcome{
+	models.OutcomeValidated,
+	models.OutcomeExpired,
+	models.OutcomeAborted,
+	models.OutcomeFailed,
+}
+
+// Reply is the data available to the reply templates
+type Reply struct {
+	Account   string
+	Recipient string
+	TraceID   string
+	Outcome   models.Outcome
+	Time      time.Time
+}
+
+// Mailer emails the outcome of a household update back to the member who requested it.
+// Sends run in the background, Close waits for them. A nil Mailer does nothing.
+type Mailer struct {
+	account  string
+	server   string
+	host     string
+	security string
+	login    string
+	password string
+	// tokens replaces the password with an OAuth2 access token of the given SASL mechanism
+	tokens     *oauth2.TokenSource
+	mechanism  string
+	from       string
+	outcomes   map[models.Outcome]bool
+	recipients map[string]string
+	subjects   map[models.Outcome]*template.Template
+	bodies     map[models.Outcome]*template.Template
+	history    *history.Store
+	wg         sync.WaitGroup
+}
+
+// New creates the Mailer of an account from its scoped configuration, or returns nil when no SMTP server is
+// configured. The IMAP credentials of the account are used unless SMTP credentials are given: with OAuth2, the
+// access tokens of tokens, shared with the IMAP connection. Every send is recorded in store when it is not nil.
+func New(account string, cfg *models.Config, store *history.Store, tokens *oauth2.TokenSource) (*Mailer, error) {
+	rc := cfg.Reply
+	if rc.SMTP == "" {
+		return nil, nil
+	}
+
+	host, port, err := net.SplitHostPort(rc.SMTP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reply SMTP server %q: %w", rc.SMTP, err)
+	}
+
+	m := &Mailer{
+		account:    account,
+		server:     rc.SMTP,
+		host:       host,
+		security:   rc.Security,
+		login:      rc.Login,
+		password:   rc.Password,
+		from:       rc.From,
+		outcomes:   make(map[models.Outcome]bool),
+		recipients: make(map[string]string, len(rc.Recipients)),
+		subjects:   make(map[models.Outcome]*template.Template),
+		bodies:     make(map[models.Outcome]*template.Template),
+		history:    store,
+	}
+
+	if m.security == "" {
+		m.security = models.SMTPSecuritySTARTTLS
+		if port == "465" {
+			m.security = models.SMTPSecurityTLS
+		}
+	}
+	if m.login == "" {
+		m.login, m.password = cfg.Email.Login, cfg.Email.Password
+		if cfg.Email.OAuth2.Enabled() {
+			if tokens == nil {
+				return nil, errors.New("reply SMTP login and password are required: the IMAP account uses OAuth2")
+			}
+			m.tokens, m.mechanism = tokens, cfg.Email.OAuth2.Mechanism
+		}
+	}
+	if m.from == "" {
+		m.from = m.login
+	}
+	if m.from == "" {
+		return nil, fmt.Errorf("reply sender address is missing")
+	}
+
+	for member, address := range rc.Recipients {
+		m.recipients[strings.ToLower(strings.TrimSpace(member))] = address
+	}
+
+	outcomes := defaultOutcomes
+	if len(rc.Outcomes) > 0 {
+		outcomes = nil
+		for _, o := range rc.Outcomes {
+			outcome := models.Outcome(o)
+			if _, ok := builtinTemplates[defaultLanguage][outcome]; !ok {
+				return nil, fmt.Errorf("unknown reply outcome %q", o)
+			}
+			outcomes = append(outcomes, outcome)
+		}
+	}
+
+	language := strings.ToLower(rc.Language)
+	templates, ok := builtinTemplates[language]
+	if !ok {
+		if language != "" {
+			logging.Log.Warnf("No built-in reply templates for language %q, using %q", rc.Language, defaultLanguage)
+		}
+		templates = builtinTemplates[defaultLanguage]
+	}
+
+	for _, outcome := range outcomes {
+		m.outcomes[outcome] = true
+
+		subjectText, bodyText := templates[outcome].Subject, templates[outcome].Body
+		if rc.Subject != "" {
+			subjectText = rc.Subject
+		}
+		if rc.Body != "" {
+			bodyText = rc.Body
+		}
+		if m.subjects[outcome], err = template.New("subject").Parse(subjectText); err != nil {
+			return nil, fmt.Errorf("invalid reply subject template: %w", err)
+		}
+		if m.bodies[outcome], err = template.New("body").Parse(bodyText); err != nil {
+			return nil, fmt.Errorf("invalid reply body template: %w", err)
+		}
+	}
+
+	return m, nil
+}
+
+// Send emails the outcome of the opened link of email to its recipient (or the mapped address), in the background
+func (m *Mailer) Send(email *models.Email, outcome models.Outcome) {
+	if m == nil {
+		return
+	}
+	if outcome == models.OutcomeBrowserError {
+		outcome = models.OutcomeFailed
+	}
+	if !m.outcomes[outcome] || email.ToPrimary == "" {
+		return
+	}
+
+	locallog := logging.Log.WithField("trace_id", email.TraceID)
+
+	reply := Reply{
+		Account:   m.account,
+		Recipient: email.ToPrimary,
+		TraceID:   email.TraceID,
+		Outcome:   outcome,
+		Time:      time.Now(),
+	}
+	to := m.recipient(email.ToPrimary)
+
+	msg, err := m.render(to, reply)
+	if err != nil {
+		locallog.WithError(err).Errorf("Failed to render %s reply", outcome)
+		return
+	}
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := m.deliver(to, msg)
+		m.audit(to, reply, err)
+		if err != nil {
+			locallog.WithError(err).Errorf("Failed to send %s reply to %s", outcome, to)
+			return
+		}
+		locallog.Infof("Sent %s reply to %s", outcome, to)
+	}()
+}
+
+// Close waits for the pending replies to be sent
+func (m *Mailer) Close() {
+	if m == nil {
+		return
+	}
+	m.wg.Wait()
+}
+
+// recipient returns the address the reply for the given Netflix recipient is sent to
+func (m *Mailer) recipient(member string) string {
+	if address, ok := m.recipients[strings.ToLower(member)]; ok {
+		return address
+	}
+	return member
+}
+
+// audit records the send in the history, if enabled
+func (m *Mailer) audit(to string, reply Reply, sendErr error) {
+	if m.history == nil {
+		return
+	}
+
+	entry := history.Reply{
+		Account:   reply.Account,
+		TraceID:   reply.TraceID,
+		Recipient: to,
+		Outcome:   reply.Outcome,
+	}
+	if sendErr != nil {
+		entry.Error = sendErr.Error()
+	}
+	if err := m.history.RecordReply(entry); err != nil {
+		logging.Log.WithField("trace_id", reply.TraceID).WithError(err).Error("History record failed")
+	}
+}
+
+// render builds the RFC 5322 message of the reply
+func (m *Mailer) render(to string, reply Reply) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := m.subjects[reply.Outcome].Execute(&subject, reply); err != nil {
+		return nil, err
+	}
+	if err := m.bodies[reply.Outcome].Execute(&body, reply); err != nil {
+		return nil, err
+	}
+
+	domain := m.host
+	if idx := strings.LastIndex(m.from, "@"); idx >= 0 {
+		domain = m.from[idx+1:]
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", reply.Time.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@%s>\r\n", uuid.New().String(), domain)
+	msg.WriteString("Auto-Submitted: auto-generated\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// deliver sends the message to the SMTP server, over implicit TLS, STARTTLS or in clear text
+func (m *Mailer) deliver(to string, msg []byte) error {
+	dialer := &net.Dialer{Timeout: sendTimeout}
+	tlsConfig := &tls.Config{ServerName: m.host}
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if m.security == models.SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", m.server, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", m.server)
+	}
+	if err != nil {
+		return fmt.Errorf("SMTP connection error: %w", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(sendTimeout))
+
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("SMTP connection error: %w", err)
+	}
+	defer c.Close()
+
+	if m.security == models.SMTPSecuritySTARTTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("SMTP server %s does not support STARTTLS", m.server)
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+
+	if ok, _ := c.Extension("AUTH"); ok && m.login != "" {
+		if err := m.authenticate(c); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// authenticate logs in with the password, or with an OAuth2 access token. A rejected token is dropped, so that the
+// next send refreshes it.
+func (m *Mailer) authenticate(c *smtp.Client) error {
+	if m.tokens == nil {
+		return c.Auth(smtp.PlainAuth("", m.login, m.password, m.host))
+	}
+
+	token, err := m.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("OAuth2 token: %w", err)
+	}
+	_, port, _ := net.SplitHostPort(m.server)
+	if err := c.Auth(&oauth2Auth{mechanism: m.mechanism, user: m.login, token: token, host: m.host, port: port}); err != nil {
+		m.tokens.Invalidate()
+		return err
+	}
+	return nil
+}
+
+// oauth2Auth implements the XOAUTH2 (Gmail and Microsoft 365) and OAUTHBEARER (RFC 7628) SMTP authentication
+type oauth2Auth struct {
+	mechanism string
+	user      string
+	token     string
+	host      string
+	port      string
+}
+
+// Start sends the access token as initial response. Like smtp.PlainAuth, it refuses to send it in clear text
+// except to localhost.
+func (a *oauth2Auth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if a.mechanism == models.OAuth2MechanismOAUTHBEARER {
+		return "OAUTHBEARER", []byte("n,a=" + a.user + ",\x01host=" + a.host + "\x01port=" + a.port +
+			"\x01auth=Bearer " + a.token + "\x01\x01"), nil
+	}
+	return "XOAUTH2", []byte("user=" + a.user + "\x01auth=Bearer " + a.token + "\x01\x01"), nil
+}
+
+// Next answers the error challenge sent when the token is rejected, after which the server fails the exchange
+func (a *oauth2Auth) Next(_ []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	if a.mechanism == models.OAuth2MechanismOAUTHBEARER {
+		return []byte{0x01}, nil
+	}
+	return []byte{}, nil
+}
@@ -0,0 +1,309 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"netflix-household-validator/internal/history"
+	"netflix-household-validator/internal/models"
+	"netflix-household-validator/internal/oauth2"
+)
+
+// delivery is a message received by the test SMTP server
+type delivery struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// smtpServer is a minimal SMTP server recording the received messages
+type smtpServer struct {
+	listener   net.Listener
+	mu         sync.Mutex
+	deliveries []delivery
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	s := &smtpServer{listener: l}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+	var d delivery
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			d.auth = line
+			reply("235 OK")
+		case "MAIL":
+			d.from = line
+			reply("250 OK")
+		case "RCPT":
+			d.to = append(d.to, line)
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			d.data = data.String()
+			s.mu.Lock()
+			s.deliveries = append(s.deliveries, d)
+			s.mu.Unlock()
+			d = delivery{}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpServer) received() []delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]delivery(nil), s.deliveries...)
+}
+
+func testConfig(server string) *models.Config {
+	return &models.Config{
+		Email: models.EmailConfig{Login: "validator@example.com", Password: "imap-password"},
+		Reply: models.ReplyConfig{SMTP: server, Security: models.SMTPSecurityNone},
+	}
+}
+
+func TestNew(t *testing.T) {
+	if m, err := New("default", &models.Config{}, nil, nil); m != nil || err != nil {
+		t.Errorf("New() without SMTP = %v, %v, want nil, nil", m, err)
+	}
+
+	tests := []struct {
+		name    string
+		reply   models.ReplyConfig
+		wantErr bool
+	}{
+		{name: "implicit TLS on 465", reply: models.ReplyConfig{SMTP: "smtp.example.com:465"}},
+		{name: "missing port", reply: models.ReplyConfig{SMTP: "smtp.example.com"}, wantErr: true},
+		{name: "unknown outcome", reply: models.ReplyConfig{SMTP: "smtp.example.com:587", Outcomes: models.StringList{"duplicate"}}, wantErr: true},
+		{name: "invalid template", reply: models.ReplyConfig{SMTP: "smtp.example.com:587", Body: "{{.Recipient"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig("")
+			cfg.Reply = tt.reply
+			m, err := New("default", cfg, nil, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && m.security != models.SMTPSecurityTLS {
+				t.Errorf("security = %q, want %q", m.security, models.SMTPSecurityTLS)
+			}
+		})
+	}
+}
+
+func TestMailer_Send(t *testing.T) {
+	server := newSMTPServer(t)
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("history.Open() error = %v", err)
+	}
+	defer store.Close()
+
+	cfg := testConfig(server.listener.Addr().String())
+	cfg.Reply.Language = "fr"
+	cfg.Reply.Outcomes = models.StringList{"validated", "failed"}
+	cfg.Reply.Recipients = map[string]string{"Owner@Example.com": "member@example.com"}
+
+	m, err := New("family", cfg, store, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	m.Send(&models.Email{ToPrimary: "owner@example.com", TraceID: "trace-1"}, models.OutcomeValidated)
+	m.Send(&models.Email{ToPrimary: "other@example.com", TraceID: "trace-2"}, models.OutcomeBrowserError)
+	m.Send(&models.Email{ToPrimary: "owner@example.com", TraceID: "trace-3"}, models.OutcomeExpired)
+	m.Close()
+
+	deliveries := server.received()
+	if len(deliveries) != 2 {
+		t.Fatalf("received %d messages, want 2", len(deliveries))
+	}
+
+	byTrace := make(map[string]delivery)
+	for _, d := range deliveries {
+		msg, err := mail.ReadMessage(strings.NewReader(d.data))
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		body, _ := io.ReadAll(quotedprintable.NewReader(msg.Body))
+		subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+
+		switch {
+		case strings.Contains(string(body), "trace-1"):
+			byTrace["trace-1"] = d
+			if subject != "Foyer Netflix mis à jour" {
+				t.Errorf("Subject = %q, want the French validated subject", subject)
+			}
+			if msg.Header.Get("To") != "member@example.com" {
+				t.Errorf("To = %q, want the mapped address", msg.Header.Get("To"))
+			}
+			if !strings.Contains(string(body), "owner@example.com") {
+				t.Errorf("Body does not mention the Netflix recipient: %q", body)
+			}
+		case strings.Contains(string(body), "trace-2"):
+			byTrace["trace-2"] = d
+			if subject != "Échec de la mise à jour du foyer Netflix" {
+				t.Errorf("Subject = %q, want the French failed subject", subject)
+			}
+		}
+		if !strings.HasPrefix(d.auth, "AUTH PLAIN") {
+			t.Errorf("AUTH = %q, want PLAIN with the IMAP credentials", d.auth)
+		}
+		if d.from != "MAIL FROM:<validator@example.com>" {
+			t.Errorf("MAIL = %q", d.from)
+		}
+	}
+	if len(byTrace) != 2 {
+		t.Errorf("unexpected replies: %+v", deliveries)
+	}
+
+	replies, err := store.Replies("trace-1")
+	if err != nil {
+		t.Fatalf("Replies() error = %v", err)
+	}
+	if len(replies) != 1 || replies[0].Recipient != "member@example.com" || replies[0].Account != "family" ||
+		replies[0].Outcome != models.OutcomeValidated || replies[0].Error != "" {
+		t.Errorf("Replies() = %+v", replies)
+	}
+}
+
+func TestMailer_OAuth2(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"access_token":"access-1","expires_in":3600}`)
+	}))
+	defer endpoint.Close()
+
+	server := newSMTPServer(t)
+	cfg := testConfig(server.listener.Addr().String())
+	cfg.Email.Password = ""
+	cfg.Email.OAuth2 = models.OAuth2Config{Mechanism: models.OAuth2MechanismXOAUTH2, TokenURL: endpoint.URL,
+		ClientID: "client", RefreshToken: "refresh"}
+
+	// The IMAP password is an access token: replies need the token source or their own credentials
+	if _, err := New("default", cfg, nil, nil); err == nil {
+		t.Error("New() with OAuth2 and without token source: expected error")
+	}
+	withLogin := *cfg
+	withLogin.Reply.Login, withLogin.Reply.Password = "smtp-user", "smtp-password"
+	if _, err := New("default", &withLogin, nil, nil); err != nil {
+		t.Errorf("New() with SMTP credentials error = %v", err)
+	}
+
+	m, err := New("default", cfg, nil, oauth2.NewTokenSource(cfg.Email.OAuth2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	m.Send(&models.Email{ToPrimary: "owner@example.com", TraceID: "trace-1"}, models.OutcomeValidated)
+	m.Close()
+
+	deliveries := server.received()
+	if len(deliveries) != 1 {
+		t.Fatalf("received %d messages, want 1", len(deliveries))
+	}
+	initial := base64.StdEncoding.EncodeToString([]byte("user=validator@example.com\x01auth=Bearer access-1\x01\x01"))
+	if deliveries[0].auth != "AUTH XOAUTH2 "+initial {
+		t.Errorf("AUTH = %q, want XOAUTH2 with the access token", deliveries[0].auth)
+	}
+}
+
+func TestMailer_SendFailureIsRecorded(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("history.Open() error = %v", err)
+	}
+	defer store.Close()
+
+	m, err := New("default", testConfig(addr), store, nil)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	m.Send(&models.Email{ToPrimary: "owner@example.com", TraceID: "trace-1"}, models.OutcomeAborted)
+	m.Close()
+
+	replies, err := store.Replies("trace-1")
+	if err != nil {
+		t.Fatalf("Replies() error = %v", err)
+	}
+	if len(replies) != 1 || replies[0].Error == "" {
+		t.Errorf("Replies() = %+v, want one failed reply", replies)
+	}
+}
+
+func TestMailer_NilIsNoop(t *testing.T) {
+	var m *Mailer
+	m.Send(&models.Email{ToPrimary: "owner@example.com"}, models.OutcomeValidated)
+	m.Close()
+}
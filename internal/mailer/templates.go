@@ -0,0 +1,88 @@
+package mailer
+
+import "netflix-household-validator/internal/models"
+
+// defaultLanguage is used when the configured language has no built-in templates
+const defaultLanguage = "en"
+
+// localizedTemplate is the built-in subject and body of the reply for one outcome
+type localizedTemplate struct {
+	Subject string
+	Body    string
+}
+
+// builtinTemplates holds the reply templates by language and outcome
+var builtinTemplates = map[string]map[models.Outcome]localizedTemplate{
+	"en": {
+		models.OutcomeValidated: {
+			Subject: "Netflix household updated",
+			Body:    "Hello,\n\nThe Netflix household update requested for {{.Recipient}} has been confirmed. You can continue watching.\n\nReference: {{.TraceID}}\n",
+		},
+		models.OutcomeExpired: {
+			Subject: "Netflix household update link expired",
+			Body:    "Hello,\n\nThe Netflix household update link for {{.Recipient}} had expired. Please request a new one from your TV.\n\nReference: {{.TraceID}}\n",
+		},
+		models.OutcomeAborted: {
+			Subject: "Netflix household update needs your action",
+			Body:    "Hello,\n\nNetflix asked for a login, so the household update for {{.Recipient}} could not be confirmed automatically. Please confirm it from the email or ask the account owner.\n\nReference: {{.TraceID}}\n",
+		},
+		models.OutcomeFailed: {
+			Subject: "Netflix household update failed",
+			Body:    "Hello,\n\nThe Netflix household update for {{.Recipient}} could not be confirmed. Please try again from your TV or ask the account owner.\n\nReference: {{.TraceID}}\n",
+		},
+	},
+	"fr": {
+		models.OutcomeValidated: {
+			Subject: "Foyer Netflix mis à jour",
+			Body:    "Bonjour,\n\nLa mise à jour du foyer Netflix demandée pour {{.Recipient}} a été confirmée. Bon visionnage !\n\nRéférence : {{.TraceID}}\n",
+		},
+		models.OutcomeExpired: {
+			Subject: "Lien de mise à jour du foyer Netflix expiré",
+			Body:    "Bonjour,\n\nLe lien de mise à jour du foyer Netflix pour {{.Recipient}} avait expiré. Merci d'en redemander un depuis votre TV.\n\nRéférence : {{.TraceID}}\n",
+		},
+		models.OutcomeAborted: {
+			Subject: "Mise à jour du foyer Netflix : action requise",
+			Body:    "Bonjour,\n\nNetflix a demandé une connexion, la mise à jour du foyer pour {{.Recipient}} n'a pas pu être confirmée automatiquement. Merci de la confirmer depuis l'email ou de contacter le titulaire du compte.\n\nRéférence : {{.TraceID}}\n",
+		},
+		models.OutcomeFailed: {
+			Subject: "Échec de la mise à jour du foyer Netflix",
+			Body:    "Bonjour,\n\nLa mise à jour du foyer Netflix pour {{.Recipient}} n'a pas pu être confirmée. Merci de réessayer depuis votre TV ou de contacter le titulaire du compte.\n\nRéférence : {{.TraceID}}\n",
+		},
+	},
+	"de": {
+		models.OutcomeValidated: {
+			Subject: "Netflix-Haushalt aktualisiert",
+			Body:    "Hallo,\n\ndie für {{.Recipient}} angeforderte Aktualisierung des Netflix-Haushalts wurde bestätigt. Viel Spaß beim Schauen!\n\nReferenz: {{.TraceID}}\n",
+		},
+		models.OutcomeExpired: {
+			Subject: "Link zur Aktualisierung des Netflix-Haushalts abgelaufen",
+			Body:    "Hallo,\n\nder Link zur Aktualisierung des Netflix-Haushalts für {{.Recipient}} war abgelaufen. Bitte fordere am Fernseher einen neuen an.\n\nReferenz: {{.TraceID}}\n",
+		},
+		models.OutcomeAborted: {
+			Subject: "Aktualisierung des Netflix-Haushalts: Aktion erforderlich",
+			Body:    "Hallo,\n\nNetflix hat eine Anmeldung verlangt, daher konnte die Aktualisierung des Haushalts für {{.Recipient}} nicht automatisch bestätigt werden. Bitte bestätige sie über die E-Mail oder wende dich an den Kontoinhaber.\n\nReferenz: {{.TraceID}}\n",
+		},
+		models.OutcomeFailed: {
+			Subject: "Aktualisierung des Netflix-Haushalts fehlgeschlagen",
+			Body:    "Hallo,\n\ndie Aktualisierung des Netflix-Haushalts für {{.Recipient}} konnte nicht bestätigt werden. Bitte versuche es am Fernseher erneut oder wende dich an den Kontoinhaber.\n\nReferenz: {{.TraceID}}\n",
+		},
+	},
+	"es": {
+		models.OutcomeValidated: {
+			Subject: "Hogar de Netflix actualizado",
+			Body:    "Hola:\n\nLa actualización del hogar de Netflix solicitada para {{.Recipient}} se ha confirmado. ¡Disfruta!\n\nReferencia: {{.TraceID}}\n",
+		},
+		models.OutcomeExpired: {
+			Subject: "El enlace de actualización del hogar de Netflix ha caducado",
+			Body:    "Hola:\n\nEl enlace de actualización del hogar de Netflix para {{.Recipient}} había caducado. Solicita uno nuevo desde tu TV.\n\nReferencia: {{.TraceID}}\n",
+		},
+		models.OutcomeAborted: {
+			Subject: "Actualización del hogar de Netflix: acción necesaria",
+			Body:    "Hola:\n\nNetflix pidió iniciar sesión, así que la actualización del hogar para {{.Recipient}} no se pudo confirmar automáticamente. Confírmala desde el correo o contacta con el titular de la cuenta.\n\nReferencia: {{.TraceID}}\n",
+		},
+		models.OutcomeFailed: {
+			Subject: "Error al actualizar el hogar de Netflix",
+			Body:    "Hola:\n\nNo se pudo confirmar la actualización del hogar de Netflix para {{.Recipient}}. Vuelve a intentarlo desde tu TV o contacta con el titular de la cuenta.\n\nReferencia: {{.TraceID}}\n",
+		},
+	},
+}
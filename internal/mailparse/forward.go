@@ -0,0 +1,177 @@
+package mailparse
+
+import (
+	"html"
+	netmail "net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"netflix-household-validator/internal/models"
+)
+
+var (
+	// forwardMarkerRe matches the separator line mail clients insert above an inline forwarded message
+	// (Gmail, Outlook, Thunderbird, Apple Mail and their usual translations).
+	forwardMarkerRe = regexp.MustCompile(`(?im)^[ \t>]*(?:-{2,}[ \t]*[^\n]*?(?:forward|transf[ée]r|weitergeleitet|reenviado|inoltrato|doorgestuurd|encaminhad|przekazan|videresend|vidarebefordra|välitetty|original message|message d'origine|ursprüngliche nachricht)[^\n]*?-{2,}|begin forwarded message:|début du message réexpédié ?:)[ \t]*$`)
+
+	// forwardHeaderRe matches a "Key: value" line of the header block following the marker
+	forwardHeaderRe = regexp.MustCompile(`^[ \t>]*([^\s:][^:\n]{0,30}?)[ \t\x{00a0}]*:[ \t]*(.*)$`)
+
+	htmlTagRe   = regexp.MustCompile(`(?s)<[^>]*>`)
+	htmlBreakRe = regexp.MustCompile(`(?i)<br\s*/?>|</(p|div|tr|li|blockquote)>`)
+)
+
+// forwardHeaderKeys maps the localized header names used in forwarded blocks to their canonical name
+var forwardHeaderKeys = map[string]string{
+	"from": "from", "de": "from", "von": "from", "van": "from", "da": "from", "od": "from", "fra": "from", "från": "from",
+	"subject": "subject", "objet": "subject", "betreff": "subject", "asunto": "subject", "oggetto": "subject",
+	"onderwerp": "subject", "assunto": "subject", "temat": "subject", "emne": "subject", "ämne": "subject",
+	"date": "date", "sent": "date", "envoyé": "date", "gesendet": "date", "datum": "date", "fecha": "date",
+	"enviado": "date", "data": "date", "inviato": "date", "verzonden": "date", "wysłano": "date", "sendt": "date",
+	"to": "to", "à": "to", "pour": "to", "a": "to", "an": "to", "para": "to", "aan": "to", "do": "to", "til": "to", "till": "to",
+}
+
+// forwardDateLayouts are tried (after net/mail.ParseDate) to read the date of an inline forwarded message
+var forwardDateLayouts = []string{
+	"Mon, Jan 2, 2006 at 3:04 PM",
+	"Monday, January 2, 2006 3:04 PM",
+	"January 2, 2006 at 3:04:05 PM MST",
+	"2 January 2006 15:04",
+	"02/01/2006 15:04",
+	"2006-01-02 15:04",
+}
+
+// inlineForward is the original message found in an inline forwarded block
+type inlineForward struct {
+	from    string
+	to      []string
+	subject string
+	date    time.Time
+	body    string
+}
+
+// unwrapForwarded replaces the envelope information of a forwarded email with the original message's
+// From, To, Subject, Date and body, keeping the forwarder address in ForwardedBy. Attached message/rfc822
+// parts take precedence over inline "Forwarded message" blocks.
+func unwrapForwarded(email *models.Email, attached *models.Email) {
+	if attached != nil && attached.From != "" {
+		email.ForwardedBy = email.From
+		email.From = attached.From
+		email.Subject = attached.Subject
+		email.Date = attached.Date
+		email.BodyText = attached.BodyText
+		email.BodyHTML = attached.BodyHTML
+		email.ForwardedRaw = attached.Raw
+		if len(attached.To) > 0 {
+			email.To = attached.To
+			email.ToPrimary = attached.ToPrimary
+		}
+		return
+	}
+
+	text := email.BodyText
+	fromHTML := false
+	if text == "" && email.BodyHTML != "" {
+		text = htmlToText(email.BodyHTML)
+		fromHTML = true
+	}
+
+	fwd := findInlineForward(text)
+	if fwd == nil {
+		return
+	}
+
+	email.ForwardedBy = email.From
+	email.From = fwd.from
+	email.Subject = fwd.subject
+	email.Date = fwd.date
+	if !fromHTML {
+		// The HTML part cannot be split reliably, its links are kept as is
+		email.BodyText = fwd.body
+	}
+	if len(fwd.to) > 0 {
+		email.To = fwd.to
+		email.ToPrimary = fwd.to[0]
+	}
+}
+
+// findInlineForward looks for the last forward marker in the text and parses the header block following it.
+// It returns nil when no marker is found or when the block has no sender.
+func findInlineForward(text string) *inlineForward {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	markers := forwardMarkerRe.FindAllStringIndex(text, -1)
+	if len(markers) == 0 {
+		return nil
+	}
+
+	lines := strings.Split(text[markers[len(markers)-1][1]:], "\n")
+	fwd := &inlineForward{}
+
+	i := 0
+	// Skip blank lines between the marker and the header block (Apple Mail)
+	for i < len(lines) && strings.TrimSpace(strings.Trim(lines[i], ">")) == "" {
+		i++
+	}
+
+	for ; i < len(lines); i++ {
+		line := strings.TrimRight(lines[i], " \t")
+		if strings.TrimSpace(strings.Trim(line, ">")) == "" {
+			break
+		}
+
+		m := forwardHeaderRe.FindStringSubmatch(line)
+		if m == nil {
+			break
+		}
+
+		key, ok := forwardHeaderKeys[strings.ToLower(strings.TrimSpace(m[1]))]
+		if !ok {
+			continue
+		}
+
+		value := strings.TrimSpace(m[2])
+		switch key {
+		case "from":
+			fwd.from = extractEmailAddress(value)
+		case "subject":
+			fwd.subject = value
+		case "date":
+			fwd.date = parseForwardDate(value)
+		case "to":
+			fwd.to = extractEmailAddresses(value)
+		}
+	}
+
+	if fwd.from == "" {
+		return nil
+	}
+
+	fwd.body = strings.TrimSpace(strings.Join(lines[i:], "\n"))
+	return fwd
+}
+
+// parseForwardDate parses the date of an inline forwarded message, returning the zero time when the format is unknown
+func parseForwardDate(value string) time.Time {
+	if t, err := netmail.ParseDate(value); err == nil {
+		return t
+	}
+	for _, layout := range forwardDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// extractEmailAddresses returns all email addresses found in a header value
+func extractEmailAddresses(value string) []string {
+	return emailAddressRe.FindAllString(value, -1)
+}
+
+// htmlToText strips tags from an HTML body so that forward blocks can be detected in HTML-only emails
+func htmlToText(body string) string {
+	body = htmlBreakRe.ReplaceAllString(body, "\n")
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(body, ""))
+}
@@ -0,0 +1,148 @@
+package mailparse
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse_InlineForwardedGmail(t *testing.T) {
+	raw := `From: Member <member@example.com>
+To: validator@example.com
+Subject: Fwd: Important: How to update your Netflix Household
+Content-Type: text/plain; charset=utf-8
+
+FYI
+
+---------- Forwarded message ---------
+From: Netflix <info@account.netflix.com>
+Date: Mon, Feb 9, 2026 at 8:00 PM
+Subject: Important: How to update your Netflix Household
+To: <member@example.com>
+
+
+Hi,
+https://www.netflix.com/account/update-primary-location?nftoken=ABC
+`
+
+	email, err := Parse(newTestMessage(raw), "")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if email.ForwardedBy != "member@example.com" {
+		t.Errorf("ForwardedBy = %q, want member@example.com", email.ForwardedBy)
+	}
+	if email.From != "info@account.netflix.com" {
+		t.Errorf("From = %q, want info@account.netflix.com", email.From)
+	}
+	if email.Subject != "Important: How to update your Netflix Household" {
+		t.Errorf("Unexpected subject %q", email.Subject)
+	}
+	if email.ToPrimary != "member@example.com" {
+		t.Errorf("ToPrimary = %q, want member@example.com", email.ToPrimary)
+	}
+	if !email.Date.Equal(time.Date(2026, time.February, 9, 20, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected date %v", email.Date)
+	}
+	if strings.Contains(email.BodyText, "FYI") || !strings.Contains(email.BodyText, "nftoken=ABC") {
+		t.Errorf("Expected body of the original message, got %q", email.BodyText)
+	}
+	if len(email.Links) != 1 {
+		t.Errorf("Expected 1 link, got %v", email.Links)
+	}
+}
+
+func TestParse_InlineForwardedLocalized(t *testing.T) {
+	raw := `From: membre@example.fr
+To: validator@example.com
+Subject: TR: Important : comment mettre à jour votre foyer Netflix
+Content-Type: text/plain; charset=utf-8
+
+-------- Message transféré --------
+Objet :	Important : comment mettre à jour votre foyer Netflix
+Date :	Mon, 9 Feb 2026 20:00:00 +0100
+De :	Netflix <info@account.netflix.com>
+Pour :	membre@example.fr
+
+https://www.netflix.com/account/update-primary-location?nftoken=FR
+`
+
+	email, err := Parse(newTestMessage(raw), "")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if email.ForwardedBy != "membre@example.fr" || email.From != "info@account.netflix.com" {
+		t.Errorf("Unexpected forward: by=%q from=%q", email.ForwardedBy, email.From)
+	}
+	if email.Subject != "Important : comment mettre à jour votre foyer Netflix" {
+		t.Errorf("Unexpected subject %q", email.Subject)
+	}
+	if email.Date.IsZero() {
+		t.Error("Expected date to be parsed")
+	}
+}
+
+func TestParse_AttachedRFC822(t *testing.T) {
+	raw := `From: Member <member@example.com>
+To: validator@example.com
+Subject: Fwd: Netflix
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="b"
+
+--b
+Content-Type: text/plain; charset=utf-8
+
+See attached
+--b
+Content-Type: message/rfc822
+Content-Disposition: attachment; filename="netflix.eml"
+
+From: Netflix <info@account.netflix.com>
+To: member@example.com
+Subject: Important: How to update your Netflix Household
+Date: Mon, 09 Feb 2026 20:00:00 +0000
+Content-Type: text/html; charset=utf-8
+
+<a href="https://www.netflix.com/account/update-primary-location?nftoken=ATT">Update</a>
+--b--
+`
+
+	email, err := Parse(newTestMessage(raw), "")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if email.ForwardedBy != "member@example.com" || email.From != "info@account.netflix.com" {
+		t.Errorf("Unexpected forward: by=%q from=%q", email.ForwardedBy, email.From)
+	}
+	if email.Subject != "Important: How to update your Netflix Household" {
+		t.Errorf("Unexpected subject %q", email.Subject)
+	}
+	if email.BodyText != "" {
+		t.Errorf("Expected forwarder text to be replaced, got %q", email.BodyText)
+	}
+	if len(email.Links) != 1 || !strings.Contains(email.Links[0], "nftoken=ATT") {
+		t.Errorf("Unexpected links %v", email.Links)
+	}
+}
+
+func TestParse_NotForwarded(t *testing.T) {
+	raw := `From: Netflix <info@account.netflix.com>
+To: member@example.com
+Subject: Important: How to update your Netflix Household
+Content-Type: text/plain; charset=utf-8
+
+https://www.netflix.com/account/update-primary-location?nftoken=ABC
+`
+
+	email, err := Parse(newTestMessage(raw), "")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if email.ForwardedBy != "" || email.From != "info@account.netflix.com" {
+		t.Errorf("Unexpected forward: by=%q from=%q", email.ForwardedBy, email.From)
+	}
+}
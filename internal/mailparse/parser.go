@@ -1,35 +1,77 @@
 package mailparse
 
 import (
+	"bytes"
+	"html"
 	"io"
 	"mime"
 	"regexp"
+	"strings"
 
 	"netflix-household-validator/internal/models"
 
 	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
 	"github.com/emersion/go-message/mail"
 	"github.com/google/uuid"
 )
 
-func Parse(msg *imap.Message) (*models.Email, error) {
+var (
+	emailAddressRe = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	linkRe         = regexp.MustCompile(`https?://[^\s"'<>)\]]+`)
+	anchorHrefRe   = regexp.MustCompile(`(?is)<a\s[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// Parse parses a message fetched from IMAP. traceID is the TraceID of its envelope when it was read first (see
+// ParseEnvelope), a new one is created when empty.
+func Parse(msg *imap.Message, traceID string) (*models.Email, error) {
 	section := &imap.BodySectionName{}
 	r := msg.GetBody(section)
 	if r == nil {
 		return nil, io.EOF
 	}
 
-	mr, err := mail.CreateReader(r)
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := ParseRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	email.UID = msg.Uid
+	email.InternalDate = msg.InternalDate
+	if traceID != "" {
+		email.TraceID = traceID
+	}
+
+	return email, nil
+}
+
+// ParseRaw parses a raw RFC 5322 message that was not fetched from IMAP (e.g. an .eml file)
+func ParseRaw(raw []byte) (*models.Email, error) {
+	email, err := parseMessage(raw)
 	if err != nil {
 		return nil, err
 	}
 
-	email := &models.Email{
-		UID:          msg.SeqNum,
-		InternalDate: msg.InternalDate,
-		TraceID:      uuid.New().String(),
+	email.TraceID = uuid.New().String()
+	return email, nil
+}
+
+// parseMessage reads an RFC 5322 message (headers, bodies, forwarded content and links)
+func parseMessage(raw []byte) (*models.Email, error) {
+	// Unknown charsets are not fatal: the raw bytes are kept and links can still be extracted
+	mr, err := mail.CreateReader(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) {
+		return nil, err
 	}
 
+	email := &models.Email{Raw: raw}
+
 	header := mr.Header
 
 	// Extract From
@@ -52,38 +94,112 @@ func Parse(msg *imap.Message) (*models.Email, error) {
 	}
 	email.Subject = decodedSubject
 
-	// Extract body text/plain
+	if date, err := header.Date(); err == nil {
+		email.Date = date
+	}
+
+	if messageID, err := header.MessageID(); err == nil {
+		email.MessageID = messageID
+	}
+
+	// Extract text/plain and text/html bodies (nested multiparts are walked by the mail reader)
+	attached, err := readBodies(mr, email)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forwarded emails are evaluated against the original message
+	unwrapForwarded(email, attached)
+
+	email.Links = collectLinks(email)
+
+	return email, nil
+}
+
+// readBodies walks all parts of the message and concatenates the inline text/plain and text/html parts.
+// The first attached message/rfc822 part, if any, is parsed and returned.
+func readBodies(mr *mail.Reader, email *models.Email) (*models.Email, error) {
+	var textParts, htmlParts []string
+	var attached *models.Email
+
 	for {
 		p, err := mr.NextPart()
 		if err == io.EOF {
 			break
-		} else if err != nil {
+		} else if err != nil && !message.IsUnknownCharset(err) {
 			return nil, err
 		}
 
+		var contentType string
 		switch h := p.Header.(type) {
 		case *mail.InlineHeader:
-			contentType, _, err := h.ContentType()
-			if err != nil {
-				continue
-			}
-			if contentType == "text/plain" {
-				body, err := io.ReadAll(p.Body)
-				if err != nil {
-					continue
+			contentType, _, err = h.ContentType()
+		case *mail.AttachmentHeader:
+			contentType, _, err = h.ContentType()
+		}
+		if err != nil {
+			continue
+		}
+
+		if contentType == "message/rfc822" {
+			if attached == nil {
+				if raw, err := io.ReadAll(p.Body); err == nil {
+					if inner, err := parseMessage(raw); err == nil {
+						attached = inner
+					}
 				}
-				email.BodyText = string(body)
 			}
+			continue
+		}
+
+		if _, ok := p.Header.(*mail.InlineHeader); !ok {
+			continue
+		}
+		if contentType != "text/plain" && contentType != "text/html" {
+			continue
+		}
+
+		body, err := io.ReadAll(p.Body)
+		if err != nil {
+			continue
+		}
+
+		if contentType == "text/plain" {
+			textParts = append(textParts, string(body))
+		} else {
+			htmlParts = append(htmlParts, string(body))
+		}
+	}
+
+	email.BodyText = strings.Join(textParts, "\n")
+	email.BodyHTML = strings.Join(htmlParts, "\n")
+	return attached, nil
+}
+
+// collectLinks returns the anchor links of the HTML body followed by the links found in the text body, without duplicates
+func collectLinks(email *models.Email) []string {
+	var links []string
+	seen := make(map[string]struct{})
+
+	add := func(candidates []string) {
+		for _, link := range candidates {
+			if _, ok := seen[link]; ok {
+				continue
+			}
+			seen[link] = struct{}{}
+			links = append(links, link)
 		}
 	}
 
-	return email, nil
+	add(ExtractHTMLLinks(email.BodyHTML))
+	add(ExtractLinks(email.BodyText))
+
+	return links
 }
 
 // Simple regex to extract email address from "From" header, which may contain name and email
 func extractEmailAddress(fromHeader string) string {
-	re := regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
-	return re.FindString(fromHeader)
+	return emailAddressRe.FindString(fromHeader)
 }
 
 // DecodeHeader decodes MIME-encoded headers (e.g., "=?UTF-8?B?...?=") to plain text
@@ -98,6 +214,18 @@ func DecodeHeader(encoded string) (string, error) {
 
 // ExtractLinks uses a regex to find all URLs in the given text
 func ExtractLinks(text string) []string {
-	re := regexp.MustCompile(`https?://[^\s"'<>)\]]+`)
-	return re.FindAllString(text, -1)
+	return linkRe.FindAllString(text, -1)
+}
+
+// ExtractHTMLLinks returns the http(s) targets of the <a href> attributes found in the given HTML, with entities decoded
+func ExtractHTMLLinks(body string) []string {
+	var links []string
+	for _, m := range anchorHrefRe.FindAllStringSubmatch(body, -1) {
+		href := strings.TrimSpace(html.UnescapeString(m[1] + m[2] + m[3]))
+		lower := strings.ToLower(href)
+		if strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://") {
+			links = append(links, href)
+		}
+	}
+	return links
 }
@@ -1,8 +1,11 @@
 package mailparse
 
 import (
+	"bytes"
 	"strings"
 	"testing"
+
+	"github.com/emersion/go-imap"
 )
 
 func TestDecodeHeader(t *testing.T) {
@@ -165,3 +168,174 @@ func TestExtractLinks_Netflix(t *testing.T) {
 		t.Errorf("Expected link to contain 'update-primary-location', got %s", links[0])
 	}
 }
+
+// newTestMessage wraps a raw RFC 5322 message into an imap.Message as returned by a BODY[] fetch
+func newTestMessage(raw string) *imap.Message {
+	msg := imap.NewMessage(1, nil)
+	msg.Body = map[*imap.BodySectionName]imap.Literal{
+		{}: bytes.NewBufferString(strings.ReplaceAll(raw, "\n", "\r\n")),
+	}
+	return msg
+}
+
+func TestParse_HTMLOnly(t *testing.T) {
+	raw := `From: Netflix <info@account.netflix.com>
+To: user@example.com
+Subject: Test Subject
+Content-Type: text/html; charset=utf-8
+Content-Transfer-Encoding: quoted-printable
+
+<html><body><a class=3D"btn" href=3D"https://www.netflix.com/account/update-p=
+rimary-location?nftoken=3DABC&amp;g=3D1">Oui, c'=C3=A9tait moi</a></body></html>
+`
+
+	email, err := Parse(newTestMessage(raw), "")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if email.BodyText != "" {
+		t.Errorf("Expected empty text body, got %q", email.BodyText)
+	}
+	if !strings.Contains(email.BodyHTML, "c'était moi") {
+		t.Errorf("Expected decoded HTML body, got %q", email.BodyHTML)
+	}
+
+	expected := "https://www.netflix.com/account/update-primary-location?nftoken=ABC&g=1"
+	if len(email.Links) != 1 || email.Links[0] != expected {
+		t.Errorf("Links = %v, want [%s]", email.Links, expected)
+	}
+}
+
+func TestParseRaw(t *testing.T) {
+	raw := "From: Netflix <info@account.netflix.com>\r\nTo: user@example.com\r\nSubject: Test Subject\r\n\r\n" +
+		"https://www.netflix.com/account/update-primary-location?nftoken=ABC\r\n"
+
+	email, err := ParseRaw([]byte(raw))
+	if err != nil {
+		t.Fatalf("ParseRaw() error: %v", err)
+	}
+
+	if email.From != "info@account.netflix.com" || email.ToPrimary != "user@example.com" {
+		t.Errorf("From, ToPrimary = %q, %q", email.From, email.ToPrimary)
+	}
+	if email.TraceID == "" {
+		t.Error("Expected a trace ID")
+	}
+	if len(email.Links) != 1 {
+		t.Errorf("Links = %v, want 1 link", email.Links)
+	}
+}
+
+func TestParse_NestedMultipart(t *testing.T) {
+	raw := `From: info@account.netflix.com
+To: First <first@example.com>, second@example.com
+Subject: =?UTF-8?Q?Important_:_comment_mettre_=C3=A0_jour?=
+MIME-Version: 1.0
+Content-Type: multipart/mixed; boundary="outer"
+
+--outer
+Content-Type: multipart/related; boundary="related"
+
+--related
+Content-Type: multipart/alternative; boundary="alt"
+
+--alt
+Content-Type: text/plain; charset=utf-8
+
+Text part https://www.netflix.com/account/update-primary-location?nftoken=TXT
+--alt
+Content-Type: text/html; charset=utf-8
+
+<p><a href='https://www.netflix.com/account/update-primary-location?nftoken=HTML'>Update</a>
+<a href="mailto:help@netflix.com">Help</a></p>
+--alt--
+--related
+Content-Type: image/png
+Content-Disposition: inline; filename="logo.png"
+Content-Transfer-Encoding: base64
+
+iVBORw0KGgo=
+--related--
+--outer
+Content-Type: text/plain; charset=utf-8
+Content-Disposition: attachment; filename="notes.txt"
+
+https://attachment.example.com/ignored
+--outer--
+`
+
+	email, err := Parse(newTestMessage(raw), "")
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+
+	if email.From != "info@account.netflix.com" || email.ToPrimary != "first@example.com" || len(email.To) != 2 {
+		t.Errorf("Unexpected addresses: from=%s to=%v primary=%s", email.From, email.To, email.ToPrimary)
+	}
+	if email.Subject != "Important : comment mettre à jour" {
+		t.Errorf("Unexpected subject %q", email.Subject)
+	}
+	if !strings.Contains(email.BodyText, "Text part") {
+		t.Errorf("Expected text body, got %q", email.BodyText)
+	}
+	if !strings.Contains(email.BodyHTML, "nftoken=HTML") {
+		t.Errorf("Expected HTML body, got %q", email.BodyHTML)
+	}
+
+	expected := []string{
+		"https://www.netflix.com/account/update-primary-location?nftoken=HTML",
+		"https://www.netflix.com/account/update-primary-location?nftoken=TXT",
+	}
+	if len(email.Links) != len(expected) {
+		t.Fatalf("Links = %v, want %v", email.Links, expected)
+	}
+	for i := range expected {
+		if email.Links[i] != expected[i] {
+			t.Errorf("Links[%d] = %s, want %s", i, email.Links[i], expected[i])
+		}
+	}
+}
+
+func TestExtractHTMLLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+	}{
+		{
+			name:     "Double quotes with entities",
+			body:     `<a href="https://netflix.com/a?x=1&amp;y=2">A</a>`,
+			expected: []string{"https://netflix.com/a?x=1&y=2"},
+		},
+		{
+			name:     "Single quotes and extra attributes",
+			body:     `<A style="color:red" HREF='https://netflix.com/b'>B</A>`,
+			expected: []string{"https://netflix.com/b"},
+		},
+		{
+			name:     "Unquoted",
+			body:     `<a href=https://netflix.com/c>C</a>`,
+			expected: []string{"https://netflix.com/c"},
+		},
+		{
+			name:     "Non-http schemes are skipped",
+			body:     `<a href="mailto:x@example.com">M</a><a href="#top">T</a>`,
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ExtractHTMLLinks(tt.body)
+			if len(got) != len(tt.expected) {
+				t.Fatalf("ExtractHTMLLinks() = %v, want %v", got, tt.expected)
+			}
+			for i := range got {
+				if got[i] != tt.expected[i] {
+					t.Errorf("ExtractHTMLLinks()[%d] = %v, want %v", i, got[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package mailparse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"netflix-household-validator/internal/models"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/textproto"
+	"github.com/google/uuid"
+)
+
+// ParseEnvelope returns the email described by the ENVELOPE of a message fetched from IMAP. It has no body: it is
+// only used to screen the sender and subject before the message is downloaded.
+func ParseEnvelope(msg *imap.Message) (*models.Email, error) {
+	envelope := msg.Envelope
+	if envelope == nil {
+		return nil, errors.New("no envelope retrieved")
+	}
+
+	email := &models.Email{
+		UID:          msg.Uid,
+		MessageID:    strings.Trim(envelope.MessageId, "<>"),
+		Date:         envelope.Date,
+		InternalDate: msg.InternalDate,
+		TraceID:      uuid.New().String(),
+	}
+	if len(envelope.From) > 0 {
+		email.From = envelope.From[0].Address()
+	}
+	for _, addr := range envelope.To {
+		email.To = append(email.To, addr.Address())
+	}
+	if len(email.To) > 0 {
+		email.ToPrimary = email.To[0]
+	}
+
+	// go-imap decodes the subject when it knows the charset
+	email.Subject = envelope.Subject
+	if decoded, err := DecodeHeader(envelope.Subject); err == nil {
+		email.Subject = decoded
+	}
+	return email, nil
+}
+
+// TextParts returns the paths of the inline text/plain and text/html parts of a multipart message, the only ones
+// ParseParts needs. ok is false when the whole message must be downloaded: single part messages, and messages
+// with an attached message (forwarded as attachment).
+func TextParts(structure *imap.BodyStructure) (paths [][]int, ok bool) {
+	if structure == nil || !strings.EqualFold(structure.MIMEType, "multipart") {
+		return nil, false
+	}
+
+	ok = true
+	structure.Walk(func(path []int, part *imap.BodyStructure) bool {
+		switch {
+		case strings.EqualFold(part.MIMEType, "message"):
+			ok = false
+		case strings.EqualFold(part.MIMEType, "text") && !strings.EqualFold(part.Disposition, "attachment") &&
+			(strings.EqualFold(part.MIMESubType, "plain") || strings.EqualFold(part.MIMESubType, "html")):
+			paths = append(paths, path)
+		}
+		return ok
+	})
+	return paths, ok && len(paths) > 0
+}
+
+// ParseParts parses a multipart message of which only the header and the given parts (see TextParts) were fetched
+// from IMAP. Its Raw is rebuilt from these parts only, so it cannot be used to verify DKIM signatures. traceID is
+// the TraceID of the envelope, so that the logs of the message follow on from the screening.
+func ParseParts(msg *imap.Message, paths [][]int, traceID string) (*models.Email, error) {
+	rawHeader, err := sectionBytes(msg, imap.BodyPartName{Specifier: imap.HeaderSpecifier})
+	if err != nil {
+		return nil, err
+	}
+	header, err := textproto.ReadHeader(bufio.NewReader(bytes.NewReader(rawHeader)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	boundary := "part-" + uuid.New().String()
+	header.Set("Content-Type", `multipart/mixed; boundary="`+boundary+`"`)
+	header.Del("Content-Transfer-Encoding")
+
+	var raw bytes.Buffer
+	if err := textproto.WriteHeader(&raw, header); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		mimeHeader, err := sectionBytes(msg, imap.BodyPartName{Specifier: imap.MIMESpecifier, Path: path})
+		if err != nil {
+			return nil, err
+		}
+		content, err := sectionBytes(msg, imap.BodyPartName{Path: path})
+		if err != nil {
+			return nil, err
+		}
+		raw.WriteString("--" + boundary + "\r\n")
+		raw.Write(mimeHeader)
+		raw.Write(content)
+		raw.WriteString("\r\n")
+	}
+	raw.WriteString("--" + boundary + "--\r\n")
+
+	email, err := ParseRaw(raw.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	email.UID = msg.Uid
+	email.InternalDate = msg.InternalDate
+	if traceID != "" {
+		email.TraceID = traceID
+	}
+	return email, nil
+}
+
+// sectionBytes returns a fetched body section
+func sectionBytes(msg *imap.Message, part imap.BodyPartName) ([]byte, error) {
+	r := msg.GetBody(&imap.BodySectionName{BodyPartName: part})
+	if r == nil {
+		return nil, fmt.Errorf("body section %s %v not retrieved", part.Specifier, part.Path)
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,103 @@
+package mailparse
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+)
+
+func TestTextParts(t *testing.T) {
+	text := &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain"}
+	html := &imap.BodyStructure{MIMEType: "text", MIMESubType: "html"}
+	pdf := &imap.BodyStructure{MIMEType: "application", MIMESubType: "pdf", Disposition: "attachment"}
+	attachedText := &imap.BodyStructure{MIMEType: "text", MIMESubType: "plain", Disposition: "attachment"}
+	forwarded := &imap.BodyStructure{MIMEType: "message", MIMESubType: "rfc822"}
+
+	tests := []struct {
+		name      string
+		structure *imap.BodyStructure
+		want      string
+		wantOK    bool
+	}{
+		{"single part", text, "", false},
+		{"alternative with attachments", &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{
+			{MIMEType: "multipart", MIMESubType: "alternative", Parts: []*imap.BodyStructure{text, html}},
+			pdf, attachedText,
+		}}, "[[1 1] [1 2]]", true},
+		{"forwarded as attachment", &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{text, forwarded}}, "", false},
+		{"no text part", &imap.BodyStructure{MIMEType: "multipart", MIMESubType: "mixed", Parts: []*imap.BodyStructure{pdf}}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, ok := TextParts(tt.structure)
+			if ok != tt.wantOK || (ok && fmt.Sprint(paths) != tt.want) {
+				t.Errorf("TextParts() = %v, %v, want %s, %v", paths, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseParts(t *testing.T) {
+	section := func(specifier imap.PartSpecifier, path ...int) *imap.BodySectionName {
+		return &imap.BodySectionName{BodyPartName: imap.BodyPartName{Specifier: specifier, Path: path}}
+	}
+	received := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+
+	msg := imap.NewMessage(0, nil)
+	msg.Uid = 42
+	msg.InternalDate = received
+	msg.Body = map[*imap.BodySectionName]imap.Literal{
+		section(imap.HeaderSpecifier): bytes.NewBufferString("From: Netflix <info@account.netflix.com>\r\n" +
+			"To: user@example.com\r\nSubject: Test Subject\r\nMIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/mixed; boundary=\"outer\"\r\n\r\n"),
+		section(imap.MIMESpecifier, 1, 1): bytes.NewBufferString("Content-Type: text/plain; charset=utf-8\r\n\r\n"),
+		section("", 1, 1):                 bytes.NewBufferString("Confirm: https://www.netflix.com/account/update-primary-location?nftoken=TOKEN\r\n"),
+		section(imap.MIMESpecifier, 1, 2): bytes.NewBufferString("Content-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n"),
+		section("", 1, 2):                 bytes.NewBufferString("<a href=3D\"https://www.netflix.com/account/update-primary-location?nftoken=TOKEN\">Yes</a>\r\n"),
+	}
+
+	email, err := ParseParts(msg, [][]int{{1, 1}, {1, 2}}, "trace-1")
+	if err != nil {
+		t.Fatalf("ParseParts() error = %v", err)
+	}
+	if email.UID != 42 || !email.InternalDate.Equal(received) || email.From != "info@account.netflix.com" || email.Subject != "Test Subject" {
+		t.Errorf("email = UID %d, date %v, from %q, subject %q", email.UID, email.InternalDate, email.From, email.Subject)
+	}
+	if !strings.Contains(email.BodyText, "nftoken=TOKEN") || !strings.Contains(email.BodyHTML, `href="https://www.netflix.com`) {
+		t.Errorf("bodies = %q, %q", email.BodyText, email.BodyHTML)
+	}
+	if len(email.Links) == 0 {
+		t.Error("no link extracted")
+	}
+	if email.TraceID != "trace-1" {
+		t.Errorf("TraceID = %q, want the envelope's", email.TraceID)
+	}
+
+	if _, err := ParseParts(msg, [][]int{{2}}, ""); err == nil {
+		t.Error("ParseParts() with a part not fetched: expected error")
+	}
+}
+
+func TestParseEnvelope(t *testing.T) {
+	msg := imap.NewMessage(0, nil)
+	msg.Uid = 7
+	msg.Envelope = &imap.Envelope{
+		Subject:   "=?UTF-8?Q?Important_:_comment_mettre_=C3=A0_jour?=",
+		From:      []*imap.Address{{PersonalName: "Netflix", MailboxName: "info", HostName: "account.netflix.com"}},
+		To:        []*imap.Address{{MailboxName: "user", HostName: "example.com"}},
+		MessageId: "<abc@netflix.com>",
+	}
+
+	email, err := ParseEnvelope(msg)
+	if err != nil {
+		t.Fatalf("ParseEnvelope() error = %v", err)
+	}
+	if email.UID != 7 || email.From != "info@account.netflix.com" || email.ToPrimary != "user@example.com" ||
+		email.Subject != "Important : comment mettre à jour" || email.MessageID != "abc@netflix.com" {
+		t.Errorf("email = %+v", email)
+	}
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"netflix-household-validator/internal/models"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "netflix_validator"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	emailsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "emails_total",
+		Help:      "Emails seen, by account and outcome.",
+	}, []string{"account", "outcome"})
+
+	browserAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "browser_attempts_total",
+		Help:      "Browser attempts (fresh browser and profile each), by result.",
+	}, []string{"result"})
+
+	browserResultsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "browser_results_total",
+		Help:      "Final browser results after retries, by result.",
+	}, []string{"result"})
+
+	browserAttemptDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "browser_attempt_duration_seconds",
+		Help:      "Duration of a single browser attempt, by result.",
+		Buckets:   []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 90},
+	}, []string{"result"})
+
+	browserDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "browser_duration_seconds",
+		Help:      "Duration of the whole link validation including retries, by result.",
+		Buckets:   []float64{1, 2.5, 5, 10, 20, 30, 60, 90, 120, 180},
+	}, []string{"result"})
+
+	imapReconnectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "imap_reconnects_total",
+		Help:      "IMAP connection failures leading to a reconnect, by account.",
+	}, []string{"account"})
+
+	imapBackoff = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "imap_backoff_seconds",
+		Help:      "Current wait before the next IMAP reconnect attempt (0 when connected), by account.",
+	}, []string{"account"})
+
+	idleUptimeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "imap", "idle_uptime_seconds"),
+		"Time since the current IMAP session entered its IDLE loop (0 when disconnected), by account.",
+		[]string{"account"}, nil,
+	)
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		emailsTotal,
+		browserAttemptsTotal,
+		browserResultsTotal,
+		browserAttemptDuration,
+		browserDuration,
+		imapReconnectsTotal,
+		imapBackoff,
+		idle,
+	)
+}
+
+// Handler returns the HTTP handler exposing the metrics in the Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// RegisterActiveSessions exposes the number of running browser sessions, read from fn at scrape time
+func RegisterActiveSessions(fn func() int32) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "browser_active_sessions",
+		Help:      "Browser sessions currently running.",
+	}, func() float64 { return float64(fn()) }))
+}
+
+// ObserveEmail counts an email processed by the given account
+func ObserveEmail(account string, outcome models.Outcome) {
+	emailsTotal.WithLabelValues(account, string(outcome)).Inc()
+}
+
+// ObserveBrowserAttempt records the result and duration of a single browser attempt
+func ObserveBrowserAttempt(result models.BrowserResult, duration time.Duration) {
+	browserAttemptsTotal.WithLabelValues(result.String()).Inc()
+	browserAttemptDuration.WithLabelValues(result.String()).Observe(duration.Seconds())
+}
+
+// ObserveBrowserResult records the final result and total duration of a link validation
+func ObserveBrowserResult(result models.BrowserResult, duration time.Duration) {
+	browserResultsTotal.WithLabelValues(result.String()).Inc()
+	browserDuration.WithLabelValues(result.String()).Observe(duration.Seconds())
+}
+
+// ObserveIMAPReconnect counts an IMAP failure of the account and records the wait before the next attempt
+func ObserveIMAPReconnect(account string, backoff time.Duration) {
+	imapReconnectsTotal.WithLabelValues(account).Inc()
+	imapBackoff.WithLabelValues(account).Set(backoff.Seconds())
+}
+
+// SetIDLEStarted marks the account IMAP session as connected and idling since now
+func SetIDLEStarted(account string) {
+	imapBackoff.WithLabelValues(account).Set(0)
+	idle.set(account, time.Now())
+}
+
+// SetIDLEStopped marks the account IMAP session as disconnected
+func SetIDLEStopped(account string) {
+	idle.set(account, time.Time{})
+}
+
+// idleCollector computes the IDLE uptime of every account at scrape time
+type idleCollector struct {
+	mu    sync.Mutex
+	since map[string]time.Time
+}
+
+var idle = &idleCollector{since: make(map[string]time.Time)}
+
+func (c *idleCollector) set(account string, since time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.since[account] = since
+}
+
+// Describe implements prometheus.Collector
+func (c *idleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- idleUptimeDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *idleCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for account, since := range c.since {
+		uptime := 0.0
+		if !since.IsZero() {
+			uptime = time.Since(since).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(idleUptimeDesc, prometheus.GaugeValue, uptime, account)
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"netflix-household-validator/internal/models"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveEmail("family", models.OutcomeValidated)
+	ObserveBrowserAttempt(models.ResultFailed, 2*time.Second)
+	ObserveBrowserResult(models.ResultSuccess, 5*time.Second)
+	ObserveIMAPReconnect("family", 10*time.Second)
+	SetIDLEStarted("grandparents")
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	expected := []string{
+		`netflix_validator_emails_total{account="family",outcome="validated"} 1`,
+		`netflix_validator_browser_attempts_total{result="failed"} 1`,
+		`netflix_validator_browser_results_total{result="success"} 1`,
+		`netflix_validator_browser_duration_seconds_count{result="success"} 1`,
+		`netflix_validator_imap_reconnects_total{account="family"} 1`,
+		`netflix_validator_imap_backoff_seconds{account="family"} 10`,
+		`netflix_validator_imap_idle_uptime_seconds{account="grandparents"}`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(string(body), line) {
+			t.Errorf("metrics output does not contain %q", line)
+		}
+	}
+}
@@ -9,3 +9,17 @@ const (
 	ResultExpired
 	ResultAbort
 )
+
+// String returns the lowercase name of the result, used in logs and metric labels
+func (r BrowserResult) String() string {
+	switch r {
+	case ResultSuccess:
+		return "success"
+	case ResultExpired:
+		return "expired"
+	case ResultAbort:
+		return "abort"
+	default:
+		return "failed"
+	}
+}
@@ -2,9 +2,45 @@ package models
 
 // Config represents the application configuration
 type Config struct {
-	Email         EmailConfig `yaml:"email"`
-	TargetFrom    string      `yaml:"targetFrom"`
-	TargetSubject string      `yaml:"targetSubject"`
+	Email         EmailConfig     `yaml:"email"`
+	Maildir       MaildirConfig   `yaml:"maildir"`
+	POP3          POP3Config      `yaml:"pop3"`
+	TargetFrom    string          `yaml:"targetFrom"`
+	TargetSubject string          `yaml:"targetSubject"`
+	Accounts      []AccountConfig `yaml:"accounts"`
+
+	// Recipients lists the addresses of the default account accepted by the inbound SMTP/LMTP listener
+	Recipients StringList `yaml:"recipients"`
+
+	// SubjectLocales selects entries of the built-in subject catalog (e.g. [fr, en] or "all")
+	SubjectLocales StringList `yaml:"subjectLocales"`
+	// TargetSubjects lists additional accepted subjects
+	TargetSubjects []string `yaml:"targetSubjects"`
+	// SubjectPatterns lists regular expressions matched against the subject
+	SubjectPatterns []string `yaml:"subjectPatterns"`
+
+	// TrustedForwarders lists the addresses whose forwarded Netflix emails are accepted
+	TrustedForwarders StringList `yaml:"trustedForwarders"`
+
+	Authentication AuthConfig `yaml:"authentication"`
+
+	// AllowedLinkHosts lists the hosts update links may point to (default: netflix.com, www.netflix.com)
+	AllowedLinkHosts StringList `yaml:"allowedLinkHosts"`
+
+	History HistoryConfig `yaml:"history"`
+
+	HTTP HTTPConfig `yaml:"http"`
+
+	Notifications NotificationsConfig `yaml:"notifications"`
+
+	Inbound InboundConfig `yaml:"inbound"`
+
+	Webhook WebhookConfig `yaml:"webhook"`
+
+	Reply ReplyConfig `yaml:"reply"`
+
+	// DryRun runs the whole pipeline but only logs the link that would be opened, and never marks emails as seen
+	DryRun bool `yaml:"dryRun"`
 }
 
 // EmailConfig represents IMAP email configuration
@@ -13,4 +49,253 @@ type EmailConfig struct {
 	Login    string `yaml:"login"`
 	Password string `yaml:"password"`
 	MailBox  string `yaml:"mailbox"`
+	// Mailboxes lists the watched mailboxes (default: MailBox): names, or special-use aliases resolved with LIST
+	// (\Junk, \All...). The first one is watched with IDLE.
+	Mailboxes StringList `yaml:"mailboxes"`
+	// PollSeconds is the interval between two checks of all the mailboxes when several are watched, as IDLE only
+	// covers the selected one and NOTIFY events may be missed (default: 60)
+	PollSeconds int `yaml:"pollSeconds"`
+	// MarkSeen also marks the handled emails as seen when the server allows keywords (they always are otherwise)
+	MarkSeen bool `yaml:"markSeen"`
+
+	// Actions are applied to the processed messages according to their outcome (or browser result name)
+	Actions map[string]MailboxAction `yaml:"actions"`
+
+	// OAuth2 replaces the password with an access token when its client ID is set
+	OAuth2 OAuth2Config `yaml:"oauth2"`
+}
+
+// MailboxAction is applied to an IMAP message once processed. Labels are added first, then the message is
+// copied, then moved or deleted. Missing folders are created.
+type MailboxAction struct {
+	// Labels are Gmail labels added with X-GM-LABELS
+	Labels StringList `yaml:"labels"`
+	Copy   string     `yaml:"copy"`
+	Move   string     `yaml:"move"`
+	Delete bool       `yaml:"delete"`
+}
+
+// OAuth2 SASL mechanisms
+const (
+	OAuth2MechanismXOAUTH2     = "xoauth2"
+	OAuth2MechanismOAUTHBEARER = "oauthbearer"
+)
+
+// OAuth2 providers whose token endpoint is known
+const (
+	OAuth2ProviderGoogle    = "google"
+	OAuth2ProviderMicrosoft = "microsoft"
+)
+
+// OAuth2Config represents the OAuth2 client used to authenticate to the IMAP server with an access token obtained
+// from a refresh token
+type OAuth2Config struct {
+	// Mechanism is the SASL mechanism: xoauth2 (default, Gmail and Microsoft 365) or oauthbearer (RFC 7628)
+	Mechanism string `yaml:"mechanism"`
+	// Provider selects a known token endpoint (google or microsoft), TokenURL sets any other
+	Provider string `yaml:"provider"`
+	TokenURL string `yaml:"tokenUrl"`
+
+	ClientID     string `yaml:"clientId"`
+	ClientSecret string `yaml:"clientSecret"`
+	RefreshToken string `yaml:"refreshToken"`
+	// ClientSecretFile and RefreshTokenFile read the secrets from files (e.g. Docker secrets). A refresh token
+	// rotated by the provider is written back to RefreshTokenFile.
+	ClientSecretFile string `yaml:"clientSecretFile"`
+	RefreshTokenFile string `yaml:"refreshTokenFile"`
+	// Scopes are requested with the refresh, when the provider needs them
+	Scopes StringList `yaml:"scopes"`
+}
+
+// Enabled reports whether OAuth2 is configured
+func (c OAuth2Config) Enabled() bool {
+	return c.ClientID != ""
+}
+
+// MaildirConfig represents a local Maildir watched instead of an IMAP mailbox
+type MaildirConfig struct {
+	// Path is the Maildir folder (containing new/, cur/ and tmp/), empty to use IMAP
+	Path string `yaml:"path"`
+	// Flags are the Maildir info flags set on handled messages moved to cur/ (default: "S")
+	Flags string `yaml:"flags"`
+}
+
+// POP3Config represents a POP3 mailbox polled instead of an IMAP mailbox, with the login and password of the email settings
+type POP3Config struct {
+	// Server is the POP3 server ("host:port"), empty to use IMAP
+	Server string `yaml:"server"`
+	// Security is the connection security, as for the replies: tls (default), starttls or none
+	Security string `yaml:"security"`
+	// PollSeconds is the interval between two polls (default: 60)
+	PollSeconds int `yaml:"pollSeconds"`
+	// Delete removes the handled messages from the server, they are left otherwise
+	Delete bool `yaml:"delete"`
+}
+
+// AccountConfig represents a single watched mailbox with its own IMAP (or Maildir, POP3) settings and filters.
+// Empty TargetFrom / TargetSubject fall back to the top-level values.
+type AccountConfig struct {
+	Name          string        `yaml:"name"`
+	Email         EmailConfig   `yaml:"email"`
+	Maildir       MaildirConfig `yaml:"maildir"`
+	POP3          POP3Config    `yaml:"pop3"`
+	TargetFrom    string        `yaml:"targetFrom"`
+	TargetSubject string        `yaml:"targetSubject"`
+	// Recipients lists the addresses of the account accepted by the inbound SMTP/LMTP listener
+	Recipients StringList `yaml:"recipients"`
+}
+
+// ForAccount returns a copy of the configuration scoped to the given account, so that
+// components written against a single mailbox (e.g. netflix.Service) can be reused as is.
+func (c *Config) ForAccount(account AccountConfig) *Config {
+	scoped := *c
+	scoped.Accounts = nil
+	scoped.Email = account.Email
+	scoped.Maildir = account.Maildir
+	scoped.POP3 = account.POP3
+	scoped.Recipients = account.Recipients
+	scoped.TargetFrom = account.TargetFrom
+	scoped.TargetSubject = account.TargetSubject
+	return &scoped
+}
+
+// Authentication modes
+const (
+	AuthModeEnforce = "enforce"
+	AuthModeReport  = "report"
+	AuthModeOff     = "off"
+)
+
+// AuthConfig represents the email authenticity checks (DKIM, ARC, Authentication-Results) run before any link is opened
+type AuthConfig struct {
+	// Mode is "enforce" (reject unauthenticated emails), "report" (only log) or "off". Attachments are only left on
+	// the IMAP server with "off": DKIM signatures are verified over the whole message.
+	Mode string `yaml:"mode"`
+	// ModeDefaulted is set when Mode was not configured and defaults to "enforce"
+	ModeDefaulted bool `yaml:"-"`
+	// Domain is the domain Netflix emails must be authenticated for
+	Domain string `yaml:"domain"`
+	// TrustedAuthServIDs lists the authserv-ids of the Authentication-Results headers added by your own mail servers
+	TrustedAuthServIDs StringList `yaml:"trustedAuthServIds"`
+	// TrustedARCSealers lists the domains whose ARC seals are trusted (e.g. google.com, outlook.com)
+	TrustedARCSealers StringList `yaml:"trustedArcSealers"`
+}
+
+// HistoryConfig represents the persistent processing history, used to never open the same link twice
+type HistoryConfig struct {
+	// Path is the SQLite database file (default: history.db)
+	Path string `yaml:"path"`
+	// Disabled turns the history off
+	Disabled bool `yaml:"disabled"`
+}
+
+// HTTPConfig represents the optional HTTP listener exposing the Prometheus metrics
+type HTTPConfig struct {
+	// Listen is the listen address (e.g. ":9090"), empty disables the listener
+	Listen string `yaml:"listen"`
+}
+
+// NotificationsConfig represents the notification targets and their settings
+type NotificationsConfig struct {
+	// IMAPDownMinutes is how long an IMAP connection must be down before "imap_down" is notified (0: never)
+	IMAPDownMinutes int                  `yaml:"imapDownMinutes"`
+	Targets         []NotificationTarget `yaml:"targets"`
+}
+
+// NotificationTarget represents a notification destination given as an Apprise-like URL
+// (json://, jsons://, ntfy://, ntfys://, gotify://, gotifys://, discord://)
+type NotificationTarget struct {
+	URL string `yaml:"url"`
+	// Events lists the notified events: success, expired, abort, failure, imap_down (default: abort, failure, imap_down)
+	Events StringList `yaml:"events"`
+	// Title and Message override the default text/template of the notifications
+	Title   string `yaml:"title"`
+	Message string `yaml:"message"`
+}
+
+// SMTP security modes
+const (
+	SMTPSecurityTLS      = "tls"
+	SMTPSecuritySTARTTLS = "starttls"
+	SMTPSecurityNone     = "none"
+)
+
+// ReplyConfig represents the email sent back to the household member who requested the update
+type ReplyConfig struct {
+	// SMTP is the SMTP server ("host:port"), empty disables the replies
+	SMTP string `yaml:"smtp"`
+	// Security is "tls" (implicit TLS), "starttls" or "none" (default: tls on port 465, starttls otherwise)
+	Security string `yaml:"security"`
+	// Login and Password default to the IMAP credentials of the account
+	Login    string `yaml:"login"`
+	Password string `yaml:"password"`
+	// From is the sender address (default: the login)
+	From string `yaml:"from"`
+	// Language selects the built-in templates: en, fr, de, es (default: en)
+	Language string `yaml:"language"`
+	// Outcomes lists the replied outcomes: validated, expired, aborted, failed (default: all)
+	Outcomes StringList `yaml:"outcomes"`
+	// Recipients maps the Netflix recipient (the To of the Netflix email) to the address the reply is sent to
+	Recipients map[string]string `yaml:"recipients"`
+	// Subject and Body override the built-in text/template of the replies
+	Subject string `yaml:"subject"`
+	Body    string `yaml:"body"`
+}
+
+// InboundConfig represents the embedded SMTP/LMTP listener receiving the Netflix emails directly.
+// Only the recipients of the accounts are accepted.
+type InboundConfig struct {
+	// SMTP and LMTP are the listen addresses ("host:port", or "unix:/path" for a socket), empty disables them
+	SMTP string `yaml:"smtp"`
+	LMTP string `yaml:"lmtp"`
+	// Hostname is announced in the greeting (default: the system host name)
+	Hostname string `yaml:"hostname"`
+	// Username and Password, when set, are required with AUTH PLAIN before any mail is accepted
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// AllowInsecureAuth allows AUTH without STARTTLS
+	AllowInsecureAuth bool `yaml:"allowInsecureAuth"`
+	// AllowedNetworks lists the IP addresses or CIDR networks clients may connect from (default: any)
+	AllowedNetworks StringList `yaml:"allowedNetworks"`
+	// TLSCert and TLSKey are the PEM files enabling STARTTLS
+	TLSCert string `yaml:"tlsCert"`
+	TLSKey  string `yaml:"tlsKey"`
+	// MaxSize is the maximum message size in bytes (default: 10 MiB)
+	MaxSize int64 `yaml:"maxSize"`
+	// Spool is a directory where every accepted message is written for audit, empty disables it
+	Spool string `yaml:"spool"`
+}
+
+// WebhookConfig represents the HTTP endpoint receiving the emails posted by cloud email-routing services.
+// A provider is enabled once its credentials are set.
+type WebhookConfig struct {
+	// Listen is the listen address (e.g. ":8080"), empty disables the endpoint
+	Listen string `yaml:"listen"`
+	// MaxSize is the maximum request size in bytes (default: 25 MiB)
+	MaxSize int64 `yaml:"maxSize"`
+
+	Mailgun    MailgunWebhookConfig    `yaml:"mailgun"`
+	SendGrid   SendGridWebhookConfig   `yaml:"sendgrid"`
+	Cloudflare CloudflareWebhookConfig `yaml:"cloudflare"`
+}
+
+// MailgunWebhookConfig represents the Mailgun routes posting to /inbound/mailgun
+type MailgunWebhookConfig struct {
+	// SigningKey is the HTTP webhook signing key of the Mailgun account
+	SigningKey string `yaml:"signingKey"`
+}
+
+// SendGridWebhookConfig represents the SendGrid Inbound Parse posting to /inbound/sendgrid
+type SendGridWebhookConfig struct {
+	// Username and Password are the basic auth credentials put in the Inbound Parse URL
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PublicKey is the base64 ECDSA verification key of signed webhooks
+	PublicKey string `yaml:"publicKey"`
+}
+
+// CloudflareWebhookConfig represents the Cloudflare Email Worker posting to /inbound/cloudflare
+type CloudflareWebhookConfig struct {
+	// Secret is the HMAC-SHA256 key shared with the Worker
+	Secret string `yaml:"secret"`
 }
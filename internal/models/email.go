@@ -5,11 +5,29 @@ import "time"
 // Email represents a normalized parsed email message
 type Email struct {
 	UID          uint32
+	MessageID    string
 	From         string
 	To           []string
 	ToPrimary    string
 	Subject      string
+	Date         time.Time
 	BodyText     string
+	BodyHTML     string
+	Links        []string
 	InternalDate time.Time
 	TraceID      string
+
+	// Mailbox identifies the IMAP mailbox of the message (e.g. imap://login@server/INBOX) and UIDValidity its
+	// UIDVALIDITY, which make the UID a stable identifier
+	Mailbox     string
+	UIDValidity uint32
+
+	// ForwardedBy is the address of the person who forwarded the email. From, To, Subject, Date and
+	// bodies then describe the original (forwarded) message.
+	ForwardedBy string
+
+	// Raw is the full RFC 5322 message as received, used for authenticity checks
+	Raw []byte
+	// ForwardedRaw is the original message when it was forwarded as a message/rfc822 attachment
+	ForwardedRaw []byte
 }
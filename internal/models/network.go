@@ -0,0 +1,26 @@
+package models
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseNetwork parses an IP address or a CIDR network; a single address is a network of one address
+func ParseNetwork(value string) (netip.Prefix, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("not an IP address or CIDR network")
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
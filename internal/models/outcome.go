@@ -0,0 +1,68 @@
+package models
+
+// Outcome describes what happened to a processed email
+type Outcome string
+
+const (
+	OutcomeValidated          Outcome = "validated"
+	OutcomeExpired            Outcome = "expired"
+	OutcomeAborted            Outcome = "aborted"
+	OutcomeFailed             Outcome = "failed"
+	OutcomeBrowserError       Outcome = "browser_error"
+	OutcomeWrongSender        Outcome = "wrong_sender"
+	OutcomeUntrustedForwarder Outcome = "untrusted_forwarder"
+	OutcomeWrongSubject       Outcome = "wrong_subject"
+	OutcomeEmptyBody          Outcome = "empty_body"
+	OutcomeNoLink             Outcome = "no_link"
+	OutcomeRejectedLink       Outcome = "rejected_link"
+	OutcomeTooOld             Outcome = "too_old"
+	OutcomeUnauthenticated    Outcome = "unauthenticated"
+	OutcomeDuplicate          Outcome = "duplicate"
+	OutcomeDryRun             Outcome = "dry_run"
+)
+
+// outcomes lists the outcomes by name
+var outcomes = map[string]Outcome{}
+
+func init() {
+	for _, o := range []Outcome{
+		OutcomeValidated, OutcomeExpired, OutcomeAborted, OutcomeFailed, OutcomeBrowserError, OutcomeWrongSender,
+		OutcomeUntrustedForwarder, OutcomeWrongSubject, OutcomeEmptyBody, OutcomeNoLink, OutcomeRejectedLink,
+		OutcomeTooOld, OutcomeUnauthenticated, OutcomeDuplicate, OutcomeDryRun,
+	} {
+		outcomes[string(o)] = o
+	}
+}
+
+// ParseOutcome returns the outcome with the given name. The names of the browser results ("success", "abort"...)
+// are accepted for the outcomes they map to.
+func ParseOutcome(name string) (Outcome, bool) {
+	if o, ok := outcomes[name]; ok {
+		return o, true
+	}
+	for _, result := range []BrowserResult{ResultSuccess, ResultExpired, ResultAbort, ResultFailed} {
+		if result.String() == name {
+			return OutcomeFromBrowserResult(result), true
+		}
+	}
+	return "", false
+}
+
+// Handled reports whether the email is done with and should not be processed again
+func (o Outcome) Handled() bool {
+	return o == OutcomeValidated || o == OutcomeExpired || o == OutcomeDuplicate
+}
+
+// OutcomeFromBrowserResult maps the result of a browser automation attempt to an outcome
+func OutcomeFromBrowserResult(result BrowserResult) Outcome {
+	switch result {
+	case ResultSuccess:
+		return OutcomeValidated
+	case ResultExpired:
+		return OutcomeExpired
+	case ResultAbort:
+		return OutcomeAborted
+	default:
+		return OutcomeFailed
+	}
+}
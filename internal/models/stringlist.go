@@ -0,0 +1,34 @@
+package models
+
+import "strings"
+
+// StringList is a list of strings that can be written in YAML either as a sequence
+// or as a single comma-separated scalar (e.g. `subjectLocales: all` or `subjectLocales: fr, en`).
+type StringList []string
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var items []string
+	if err := unmarshal(&items); err == nil {
+		*l = items
+		return nil
+	}
+
+	var scalar string
+	if err := unmarshal(&scalar); err != nil {
+		return err
+	}
+	*l = SplitList(scalar)
+	return nil
+}
+
+// SplitList splits a comma-separated value into its trimmed, non-empty items
+func SplitList(value string) StringList {
+	var items StringList
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/metrics"
 
 	"github.com/go-rod/rod"
 	"github.com/go-rod/rod/lib/launcher"
@@ -18,6 +19,11 @@ import (
 
 var activeRodSessions atomic.Int32
 
+// ActiveSessions returns the number of browser sessions currently running
+func ActiveSessions() int32 {
+	return activeRodSessions.Load()
+}
+
 type RodBrowser struct{}
 
 // NewRodBrowser creates a new instance of RodBrowser
@@ -26,16 +32,21 @@ func NewRodBrowser() *RodBrowser {
 }
 
 // OpenUpdatePrimaryLocation attempts to open the provided link using Rod, handling login if necessary.
-func (rb *RodBrowser) OpenUpdatePrimaryLocation(link, traceID string) (models.BrowserResult, error) {
+func (rb *RodBrowser) OpenUpdatePrimaryLocation(link, traceID string) (result models.BrowserResult, err error) {
 	const maxAttempts = 3
 
-	sanitizedLink := sanitizeURL(link)
+	start := time.Now()
+	defer func() { metrics.ObserveBrowserResult(result, time.Since(start)) }()
+
+	sanitizedLink := SanitizeURL(link)
 	logging.Log.WithField("trace_id", traceID).Info("Open page with rod: ", sanitizedLink)
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
 		logging.Log.WithField("trace_id", traceID).Infof("Attempt %d/%d (fresh browser & profile)", attempt, maxAttempts)
 
+		attemptStart := time.Now()
 		result, err := rb.attemptOpenLink(link, attempt, traceID)
+		metrics.ObserveBrowserAttempt(result, time.Since(attemptStart))
 		if err != nil {
 			logging.Log.WithField("trace_id", traceID).WithError(err).Warnf("Attempt %d error", attempt)
 		}
@@ -99,15 +110,7 @@ func (rb *RodBrowser) attemptOpenLink(
 		}
 	}()
 
-	u := launcher.New().
-		Headless(true).
-		NoSandbox(true).
-		UserDataDir(tmpDir)
-
-	const systemChromium = "/usr/bin/chromium"
-	if _, err := os.Stat(systemChromium); err == nil {
-		u = u.Bin(systemChromium)
-	}
+	u := newLauncher(tmpDir)
 
 	launchURL, err := u.Launch()
 	if err != nil {
@@ -192,6 +195,52 @@ func racePageElements(page *rod.Page, timeout time.Duration) (pageOutcome, error
 	return outcome, err
 }
 
+// newLauncher returns a headless Chromium launcher using the given profile directory,
+// preferring the system Chromium when installed
+func newLauncher(userDataDir string) *launcher.Launcher {
+	u := launcher.New().
+		Headless(true).
+		NoSandbox(true).
+		UserDataDir(userDataDir)
+
+	const systemChromium = "/usr/bin/chromium"
+	if _, err := os.Stat(systemChromium); err == nil {
+		u = u.Bin(systemChromium)
+	}
+	return u
+}
+
+// SelfTest launches Chromium, opens a blank page and closes it, to check the browser can run in this environment
+func (rb *RodBrowser) SelfTest() error {
+	activeRodSessions.Add(1)
+	defer activeRodSessions.Add(-1)
+
+	tmpDir, err := os.MkdirTemp("", "rod-netflix-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp user data dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	u := newLauncher(tmpDir)
+	launchURL, err := u.Launch()
+	if err != nil {
+		return fmt.Errorf("failed to launch browser: %w", err)
+	}
+	defer u.Cleanup()
+
+	browser := rod.New().Timeout(30 * time.Second)
+	defer func() { _ = browser.Close() }()
+	if err := browser.ControlURL(launchURL).Connect(); err != nil {
+		return fmt.Errorf("failed to connect to browser: %w", err)
+	}
+
+	page, err := browser.Page(proto.TargetCreateTarget{URL: "about:blank"})
+	if err != nil {
+		return fmt.Errorf("failed to open page: %w", err)
+	}
+	return page.Close()
+}
+
 // StartCleanup starts a background goroutine that cleans up old Rod temp directories
 func StartCleanup() {
 	go func() {
@@ -222,8 +271,8 @@ func StartCleanup() {
 	}()
 }
 
-// sanitizeURL redacts sensitive query parameters from the URL for safe logging
-func sanitizeURL(raw string) string {
+// SanitizeURL redacts sensitive query parameters from the URL for safe logging
+func SanitizeURL(raw string) string {
 	u, err := url.Parse(raw)
 	if err != nil {
 		return raw
@@ -0,0 +1,255 @@
+package netflix
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// maxUnwrapDepth bounds the number of nested wrappers (e.g. SafeLinks around Proofpoint) that are unwrapped
+const maxUnwrapDepth = 5
+
+// defaultAllowedLinkHosts are the hosts update links may point to when allowedLinkHosts is not configured
+var defaultAllowedLinkHosts = []string{"netflix.com", "www.netflix.com"}
+
+var (
+	proofpointV3Re  = regexp.MustCompile(`^/v3/__(.+?)__;([^!]*)!!`)
+	proofpointRunRe = regexp.MustCompile(`\*(\*.)?`)
+)
+
+// proofpointRunLengths maps the Proofpoint v3 run-length markers to the number of replaced characters
+var proofpointRunLengths = func() map[byte]int {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	lengths := make(map[byte]int, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		lengths[alphabet[i]] = i + 2
+	}
+	return lengths
+}()
+
+// redirectTimeout bounds the request resolving an opaque wrapper link
+const redirectTimeout = 5 * time.Second
+
+// redirectDomains are the domains (with their subdomains) of the opaque wrapper links resolved over the network
+var redirectDomains = []string{"mimecast.com"}
+
+// RedirectResolver returns the target of an opaque wrapper link that does not embed it (Mimecast)
+type RedirectResolver interface {
+	ResolveRedirect(link string) (string, error)
+}
+
+// httpRedirectResolver reads the Location of an opaque wrapper link with a HEAD request, without following it
+type httpRedirectResolver struct {
+	client  *http.Client
+	domains []string
+}
+
+// newHTTPRedirectResolver creates a resolver sending its requests with client, only to the hosts of the domains
+func newHTTPRedirectResolver(client *http.Client, domains []string) *httpRedirectResolver {
+	client.Timeout = redirectTimeout
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return &httpRedirectResolver{client: client, domains: domains}
+}
+
+// ResolveRedirect implements RedirectResolver. Only https links on the allowed domains are requested.
+func (r *httpRedirectResolver) ResolveRedirect(link string) (string, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(parsed.Hostname())
+	allowed := false
+	for _, domain := range r.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			allowed = true
+			break
+		}
+	}
+	if parsed.Scheme != "https" || parsed.User != nil || !allowed {
+		return "", fmt.Errorf("redirect of %s://%s not resolved", parsed.Scheme, parsed.Host)
+	}
+
+	resp, err := r.client.Head(link)
+	if err != nil {
+		return "", err
+	}
+	_ = resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", fmt.Errorf("no redirect location (status %d)", resp.StatusCode)
+	}
+	return location, nil
+}
+
+// linkUnwrapper recovers the original URL from the rewritten links of mail security gateways
+// (Outlook SafeLinks, Proofpoint URL Defense, Mimecast, Google redirects).
+type linkUnwrapper struct {
+	// resolver resolves the opaque wrapper links, which are rejected when it is nil
+	resolver RedirectResolver
+}
+
+// Unwrap returns the final URL behind any number of nested wrappers. Links that are not wrapped are returned as is.
+func (u *linkUnwrapper) Unwrap(link string) (string, error) {
+	for i := 0; i < maxUnwrapDepth; i++ {
+		inner, wrapped, err := u.unwrapOnce(link)
+		if err != nil {
+			return "", err
+		}
+		if !wrapped {
+			return link, nil
+		}
+		link = inner
+	}
+	return "", errors.New("too many nested link wrappers")
+}
+
+// unwrapOnce removes one wrapper layer, reporting whether the link was wrapped
+func (u *linkUnwrapper) unwrapOnce(link string) (string, bool, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", false, err
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	switch {
+	case strings.HasSuffix(host, ".safelinks.protection.outlook.com"):
+		return requiredQueryParam(parsed, "url")
+
+	case host == "urldefense.proofpoint.com" && strings.HasPrefix(parsed.Path, "/v1/"):
+		return requiredQueryParam(parsed, "u")
+
+	case host == "urldefense.proofpoint.com" && strings.HasPrefix(parsed.Path, "/v2/"):
+		encoded := parsed.Query().Get("u")
+		if encoded == "" {
+			return "", false, errors.New("proofpoint v2 link without u parameter")
+		}
+		decoded, err := url.QueryUnescape(strings.NewReplacer("-", "%", "_", "/").Replace(encoded))
+		if err != nil {
+			return "", false, fmt.Errorf("invalid proofpoint v2 link: %w", err)
+		}
+		return decoded, true, nil
+
+	case host == "urldefense.com" && strings.HasPrefix(parsed.EscapedPath(), "/v3/"):
+		decoded, err := decodeProofpointV3(link[strings.Index(link, "/v3/"):])
+		return decoded, err == nil, err
+
+	case (host == "www.google.com" || host == "google.com") && parsed.Path == "/url":
+		return requiredQueryParam(parsed, "q")
+
+	case strings.HasSuffix(host, ".mimecast.com") && strings.HasPrefix(parsed.Path, "/s/"):
+		if u.resolver == nil {
+			return "", false, errors.New("cannot resolve mimecast link: network resolution disabled")
+		}
+		location, err := u.resolver.ResolveRedirect(link)
+		if err != nil {
+			return "", false, fmt.Errorf("cannot resolve mimecast link: %w", err)
+		}
+		return location, true, nil
+	}
+
+	return link, false, nil
+}
+
+// requiredQueryParam returns the wrapped URL stored in a query parameter
+func requiredQueryParam(parsed *url.URL, key string) (string, bool, error) {
+	value := parsed.Query().Get(key)
+	if value == "" {
+		return "", false, fmt.Errorf("wrapper link on %s without %s parameter", parsed.Hostname(), key)
+	}
+	return value, true, nil
+}
+
+// decodeProofpointV3 decodes a Proofpoint URL Defense v3 path ("/v3/__<url>__;<base64 replaced chars>!!...").
+// Characters of the original URL are replaced by "*" (or "**X" runs) and stored base64url-encoded after "__;".
+func decodeProofpointV3(path string) (string, error) {
+	m := proofpointV3Re.FindStringSubmatch(path)
+	if m == nil {
+		return "", errors.New("invalid proofpoint v3 link")
+	}
+
+	encodedURL, encodedChars := m[1], m[2]
+	decodedChars, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encodedChars, "="))
+	if err != nil {
+		return "", fmt.Errorf("invalid proofpoint v3 link: %w", err)
+	}
+	chars := []rune(string(decodedChars))
+
+	marker := 0
+	var decodeErr error
+	decoded := proofpointRunRe.ReplaceAllStringFunc(encodedURL, func(token string) string {
+		length := 1
+		if len(token) == 3 {
+			runLength, ok := proofpointRunLengths[token[2]]
+			if !ok {
+				decodeErr = fmt.Errorf("invalid proofpoint v3 run marker %q", token)
+				return ""
+			}
+			length = runLength
+		}
+		if marker+length > len(chars) {
+			decodeErr = errors.New("invalid proofpoint v3 link: not enough replaced characters")
+			return ""
+		}
+		run := string(chars[marker : marker+length])
+		marker += length
+		return run
+	})
+	if decodeErr != nil {
+		return "", decodeErr
+	}
+
+	return decoded, nil
+}
+
+// linkChecker decides whether an (unwrapped) link may be opened in the browser
+type linkChecker struct {
+	allowedHosts map[string]struct{}
+}
+
+// newLinkChecker creates a checker accepting https links to the given hosts (defaults to netflix.com / www.netflix.com)
+func newLinkChecker(hosts []string) *linkChecker {
+	if len(hosts) == 0 {
+		hosts = defaultAllowedLinkHosts
+	}
+
+	allowed := make(map[string]struct{}, len(hosts))
+	for _, host := range hosts {
+		allowed[strings.ToLower(strings.TrimSpace(host))] = struct{}{}
+	}
+	return &linkChecker{allowedHosts: allowed}
+}
+
+// Check returns an error describing why the link must not be opened
+func (c *linkChecker) Check(link string) error {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if parsed.Scheme != "https" {
+		return fmt.Errorf("scheme %q is not https", parsed.Scheme)
+	}
+	if parsed.User != nil {
+		return errors.New("URL contains user info")
+	}
+
+	host := strings.ToLower(parsed.Hostname())
+	if _, ok := c.allowedHosts[host]; !ok {
+		return fmt.Errorf("host %q is not allowed", host)
+	}
+	if parsed.Port() != "" && parsed.Port() != "443" {
+		return fmt.Errorf("port %q is not allowed", parsed.Port())
+	}
+	if !strings.Contains(parsed.Path, "update-primary-location") {
+		return errors.New("path is not an update-primary-location path")
+	}
+
+	return nil
+}
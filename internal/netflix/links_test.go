@@ -0,0 +1,233 @@
+package netflix
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"netflix-household-validator/internal/models"
+)
+
+const netflixUpdateLink = "https://www.netflix.com/account/update-primary-location?nftoken=ABC"
+
+// redirectFunc is a RedirectResolver without network
+type redirectFunc func(link string) (string, error)
+
+func (f redirectFunc) ResolveRedirect(link string) (string, error) {
+	return f(link)
+}
+
+func TestLinkUnwrapper_Unwrap(t *testing.T) {
+	unwrapper := &linkUnwrapper{
+		resolver: redirectFunc(func(link string) (string, error) {
+			if link == "https://protect-eu.mimecast.com/s/AbCdEf?domain=netflix.com" {
+				return netflixUpdateLink, nil
+			}
+			return "", errors.New("unexpected redirect lookup")
+		}),
+	}
+
+	tests := []struct {
+		name     string
+		link     string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "Not wrapped",
+			link:     netflixUpdateLink,
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "Outlook SafeLinks",
+			link:     "https://eur01.safelinks.protection.outlook.com/?url=https%3A%2F%2Fwww.netflix.com%2Faccount%2Fupdate-primary-location%3Fnftoken%3DABC&data=05%7C01&reserved=0",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "Proofpoint v1",
+			link:     "https://urldefense.proofpoint.com/v1/url?u=https%3A%2F%2Fwww.netflix.com%2Faccount%2Fupdate-primary-location%3Fnftoken%3DABC&k=abc",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "Proofpoint v2",
+			link:     "https://urldefense.proofpoint.com/v2/url?u=https-3A__www.netflix.com_account_update-2Dprimary-2Dlocation-3Fnftoken-3DABC&d=DwMFaQ&c=abc&r=def",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "Proofpoint v3",
+			link:     "https://urldefense.com/v3/__https://www.netflix.com/account/update-primary-location*nftoken*ABC__;Pz0!!AbCd$",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "Proofpoint v3 with run-length marker",
+			link:     "https://urldefense.com/v3/__https://www.netflix.com/account/update-primary-location**Anftoken=ABC__;Pz8!!AbCd$",
+			expected: "https://www.netflix.com/account/update-primary-location??nftoken=ABC",
+		},
+		{
+			name:     "Mimecast",
+			link:     "https://protect-eu.mimecast.com/s/AbCdEf?domain=netflix.com",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "Google redirect",
+			link:     "https://www.google.com/url?q=https://www.netflix.com/account/update-primary-location?nftoken%3DABC&sa=D",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:     "SafeLinks around Proofpoint v2",
+			link:     "https://nam02.safelinks.protection.outlook.com/?url=https%3A%2F%2Furldefense.proofpoint.com%2Fv2%2Furl%3Fu%3Dhttps-3A__www.netflix.com_account_update-2Dprimary-2Dlocation-3Fnftoken-3DABC%26d%3DDwMFaQ",
+			expected: netflixUpdateLink,
+		},
+		{
+			name:    "SafeLinks without url parameter",
+			link:    "https://eur01.safelinks.protection.outlook.com/?data=05",
+			wantErr: true,
+		},
+		{
+			name:    "Unresolvable Mimecast link",
+			link:    "https://protect-us.mimecast.com/s/Unknown",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unwrapper.Unwrap(tt.link)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unwrap() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.expected {
+				t.Errorf("Unwrap() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLinkUnwrapper_ResolutionDisabled(t *testing.T) {
+	unwrapper := &linkUnwrapper{}
+	if _, err := unwrapper.Unwrap("https://protect-eu.mimecast.com/s/AbCdEf?domain=netflix.com"); err == nil {
+		t.Error("Unwrap() without resolver: expected error")
+	}
+	if got, err := unwrapper.Unwrap(netflixUpdateLink); err != nil || got != netflixUpdateLink {
+		t.Errorf("Unwrap() = %q, %v, want the link as is", got, err)
+	}
+}
+
+func TestHTTPRedirectResolver(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != http.MethodHead {
+			t.Errorf("method = %s, want HEAD", r.Method)
+		}
+		http.Redirect(w, r, netflixUpdateLink, http.StatusFound)
+	}))
+	defer server.Close()
+
+	resolver := newHTTPRedirectResolver(server.Client(), []string{"127.0.0.1"})
+	if got, err := resolver.ResolveRedirect(server.URL + "/s/AbCdEf"); err != nil || got != netflixUpdateLink {
+		t.Errorf("ResolveRedirect() = %q, %v, want %q", got, err, netflixUpdateLink)
+	}
+
+	// Only https links on the allowed domains are requested
+	for _, link := range []string{
+		strings.Replace(server.URL, "https://", "http://", 1) + "/s/AbCdEf",
+		strings.Replace(server.URL, "127.0.0.1", "localhost", 1) + "/s/AbCdEf",
+		strings.Replace(server.URL, "https://", "https://user@", 1) + "/s/AbCdEf",
+	} {
+		if _, err := resolver.ResolveRedirect(link); err == nil {
+			t.Errorf("ResolveRedirect(%q): expected error", link)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("%d request(s) sent, want 1", requests)
+	}
+}
+
+func TestLinkChecker_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		link    string
+		allowed bool
+	}{
+		{name: "Default host", link: netflixUpdateLink, allowed: true},
+		{name: "Apex domain", link: "https://netflix.com/account/update-primary-location?nftoken=ABC", allowed: true},
+		{name: "Foreign host", link: "https://evil.example/update-primary-location", allowed: false},
+		{name: "Lookalike subdomain", link: "https://www.netflix.com.evil.example/update-primary-location", allowed: false},
+		{name: "Plain http", link: "http://www.netflix.com/account/update-primary-location", allowed: false},
+		{name: "User info", link: "https://www.netflix.com@evil.example/update-primary-location", allowed: false},
+		{name: "Keyword only in query", link: "https://www.netflix.com/browse?next=update-primary-location", allowed: false},
+		{name: "Custom allowlist", hosts: []string{"help.netflix.com"}, link: "https://help.netflix.com/update-primary-location", allowed: true},
+		{name: "Custom allowlist excludes default", hosts: []string{"help.netflix.com"}, link: netflixUpdateLink, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newLinkChecker(tt.hosts).Check(tt.link)
+			if (err == nil) != tt.allowed {
+				t.Errorf("Check(%q) error = %v, allowed %v", tt.link, err, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestProcess_RejectedLink(t *testing.T) {
+	cfg := &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	}
+
+	mockBrowser := &MockBrowser{Result: models.ResultSuccess}
+	svc := NewService(mockBrowser, cfg)
+
+	email := &models.Email{
+		From:      "info@account.netflix.com",
+		Subject:   "Test Subject",
+		BodyText:  "https://evil.example/update-primary-location?nftoken=abc",
+		ToPrimary: "user@example.com",
+		TraceID:   "test-trace",
+	}
+
+	if outcome := svc.Process(email); outcome != models.OutcomeRejectedLink {
+		t.Errorf("Process() = %s, want %s", outcome, models.OutcomeRejectedLink)
+	}
+}
+
+func TestProcess_WrappedLinkIsUnwrapped(t *testing.T) {
+	cfg := &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	}
+
+	browser := &recordingBrowser{}
+	svc := NewService(browser, cfg)
+
+	email := &models.Email{
+		From:      "info@account.netflix.com",
+		Subject:   "Test Subject",
+		Links:     []string{"https://eur01.safelinks.protection.outlook.com/?url=https%3A%2F%2Fwww.netflix.com%2Faccount%2Fupdate-primary-location%3Fnftoken%3DABC"},
+		BodyHTML:  "<a>wrapped</a>",
+		ToPrimary: "user@example.com",
+		TraceID:   "test-trace",
+	}
+
+	if outcome := svc.Process(email); outcome != models.OutcomeValidated {
+		t.Errorf("Process() = %s, want %s", outcome, models.OutcomeValidated)
+	}
+	if browser.link != netflixUpdateLink {
+		t.Errorf("Browser opened %q, want %q", browser.link, netflixUpdateLink)
+	}
+}
+
+// recordingBrowser records the link it was asked to open
+type recordingBrowser struct {
+	link string
+}
+
+func (b *recordingBrowser) OpenUpdatePrimaryLocation(link, _ string) (models.BrowserResult, error) {
+	b.link = link
+	return models.ResultSuccess, nil
+}
@@ -0,0 +1,71 @@
+package netflix
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// subjectPrefixRe matches tags and forwarding prefixes added by mail gateways and clients
+// (e.g. "[EXTERNAL]", "Fwd:", "TR:", "WG:") at the start of a subject.
+var subjectPrefixRe = regexp.MustCompile(`(?i)^(\[[^\]]*\]|(fwd?|fw|tr|wg|rv|enc|doorst|vb|vs)\s*:)\s*`)
+
+// spaceBeforeColonRe matches the (possibly non-breaking) space French typography puts before a colon
+var spaceBeforeColonRe = regexp.MustCompile(`\s+:`)
+
+// caseFolder folds case for caseless comparisons (handles e.g. German "ß")
+var caseFolder = cases.Fold()
+
+// normalizeSubject returns the canonical form used to compare subjects: NFC normalization, invisible
+// characters removed, whitespace (including non-breaking spaces) collapsed, gateway/forward prefixes
+// stripped, no space before colons and case folded.
+func normalizeSubject(subject string) string {
+	s := collapseSpaces(norm.NFC.String(subject))
+
+	for {
+		stripped := subjectPrefixRe.ReplaceAllString(s, "")
+		if stripped == s {
+			break
+		}
+		s = stripped
+	}
+
+	s = spaceBeforeColonRe.ReplaceAllString(s, ":")
+	return caseFolder.String(s)
+}
+
+// normalizeSender returns the canonical form used to compare sender addresses
+func normalizeSender(sender string) string {
+	s := collapseSpaces(norm.NFC.String(sender))
+	s = strings.TrimPrefix(strings.ToLower(s), "mailto:")
+	return strings.Trim(s, "<> ")
+}
+
+// collapseSpaces replaces every Unicode space (NBSP, narrow NBSP, tabs, folded header line breaks...)
+// with a single ASCII space, drops zero-width characters and trims the result.
+func collapseSpaces(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	pendingSpace := false
+	for _, r := range s {
+		switch {
+		case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\ufeff':
+			continue
+		case unicode.IsSpace(r) || unicode.Is(unicode.Zs, r):
+			pendingSpace = b.Len() > 0
+			continue
+		}
+
+		if pendingSpace {
+			b.WriteByte(' ')
+			pendingSpace = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package netflix
+
+import (
+	"testing"
+
+	"netflix-household-validator/internal/models"
+)
+
+func TestNormalizeSubject(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Non-breaking space before colon",
+			input:    "Important\u00a0: comment mettre à jour votre foyer Netflix",
+			expected: "important: comment mettre à jour votre foyer netflix",
+		},
+		{
+			name:     "Narrow non-breaking space before colon",
+			input:    "Important\u202f: comment mettre à jour votre foyer Netflix",
+			expected: "important: comment mettre à jour votre foyer netflix",
+		},
+		{
+			name:     "Folded header with tabs and repeated spaces",
+			input:    "Important :\r\n\tcomment  mettre à jour   votre foyer Netflix ",
+			expected: "important: comment mettre à jour votre foyer netflix",
+		},
+		{
+			name:     "Decomposed accents are composed (NFC)",
+			input:    "Important : comment mettre a\u0300 jour votre foyer Netflix",
+			expected: "important: comment mettre à jour votre foyer netflix",
+		},
+		{
+			name:     "Gateway tag and forward prefixes",
+			input:    "[EXTERNAL] Fwd: TR: Important: How to update your Netflix Household",
+			expected: "important: how to update your netflix household",
+		},
+		{
+			name:     "Zero-width characters",
+			input:    "Important:\u200b How to update your Netflix Household",
+			expected: "important: how to update your netflix household",
+		},
+		{
+			name:     "Case folding",
+			input:    "WICHTIG: SO AKTUALISIERST DU DEINEN NETFLIX-HAUSHALT",
+			expected: "wichtig: so aktualisierst du deinen netflix-haushalt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeSubject(tt.input); got != tt.expected {
+				t.Errorf("normalizeSubject(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestNormalizeSender(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{input: "info@account.netflix.com", expected: "info@account.netflix.com"},
+		{input: " Info@Account.Netflix.com ", expected: "info@account.netflix.com"},
+		{input: "<info@account.netflix.com>", expected: "info@account.netflix.com"},
+		{input: "mailto:info@account.netflix.com", expected: "info@account.netflix.com"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeSender(tt.input); got != tt.expected {
+			t.Errorf("normalizeSender(%q) = %q, want %q", tt.input, got, tt.expected)
+		}
+	}
+}
+
+func TestHandleEmail_NormalizedSubjectAndSender(t *testing.T) {
+	cfg := &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Important : comment mettre à jour votre foyer Netflix",
+	}
+
+	mockBrowser := &MockBrowser{Result: models.ResultSuccess}
+	svc := NewService(mockBrowser, cfg)
+
+	email := &models.Email{
+		From:      "Info@Account.Netflix.com",
+		Subject:   "[EXTERNAL] Important\u00a0:  comment mettre à jour votre foyer Netflix",
+		BodyText:  "https://www.netflix.com/account/update-primary-location?nftoken=abc",
+		ToPrimary: "user@example.com",
+		TraceID:   "test-trace",
+	}
+
+	if !svc.HandleEmail(email) {
+		t.Error("Expected email with normalized subject and sender to be handled")
+	}
+}
@@ -1,6 +1,7 @@
 package netflix
 
 import (
+	"net/http"
 	"netflix-household-validator/internal/models"
 	"strings"
 
@@ -9,66 +10,201 @@ import (
 )
 
 type Service struct {
-	browser Browser
-	config  *models.Config
+	browser   Browser
+	config    *models.Config
+	subjects  *subjectMatcher
+	unwrapper *linkUnwrapper
+	links     *linkChecker
+}
+
+// Option configures optional Service dependencies
+type Option func(*Service)
+
+// WithRedirectResolver sets the resolver of the opaque wrapper links (Mimecast), a HEAD request to the Mimecast
+// host by default. nil disables their resolution, e.g. in dry-run mode: these links are then rejected.
+func WithRedirectResolver(resolver RedirectResolver) Option {
+	return func(s *Service) {
+		s.unwrapper.resolver = resolver
+	}
 }
 
 // NewService creates a new instance of the Netflix Service with the provided browser and configuration
-func NewService(browser Browser, cfg *models.Config) *Service {
-	return &Service{
-		browser: browser,
-		config:  cfg,
+func NewService(browser Browser, cfg *models.Config, opts ...Option) *Service {
+	s := &Service{
+		browser:   browser,
+		config:    cfg,
+		subjects:  newSubjectMatcher(cfg),
+		unwrapper: &linkUnwrapper{resolver: newHTTPRedirectResolver(&http.Client{}, redirectDomains)},
+		links:     newLinkChecker(cfg.AllowedLinkHosts),
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // HandleEmail processes the given email, applying filters and using the browser to handle valid emails.
+// It returns true when the email is done with (validated or expired link).
 func (s *Service) HandleEmail(email *models.Email) bool {
+	return s.Process(email).Handled()
+}
+
+// Decision is the result of evaluating an email before any browser action: either the update link to open,
+// or the outcome explaining why the email is not acted upon. Reason describes the decision in plain words.
+type Decision struct {
+	Link    string
+	Outcome models.Outcome
+	Reason  string
+}
+
+// Process applies the filters to the given email, opens its update link with the browser and returns the outcome.
+func (s *Service) Process(email *models.Email) models.Outcome {
+	decision := s.Evaluate(email)
+	if decision.Link == "" {
+		return decision.Outcome
+	}
+	return s.Validate(email, decision.Link)
+}
+
+// Evaluate applies the sender, subject and link filters to the given email without opening anything.
+func (s *Service) Evaluate(email *models.Email) Decision {
 	locallog := logging.Log.WithField("trace_id", email.TraceID)
 
+	// Forwarded emails are only accepted from trusted forwarders
+	if email.ForwardedBy != "" {
+		if !s.isTrustedForwarder(email.ForwardedBy) {
+			locallog.Infof("Email forwarded by untrusted sender %s, skip ...", email.ForwardedBy)
+			return Decision{Outcome: models.OutcomeUntrustedForwarder, Reason: "forwarded by untrusted sender " + email.ForwardedBy}
+		}
+		locallog.Infof("Email forwarded by trusted sender %s", email.ForwardedBy)
+	}
+
 	// Filter by sender
-	if email.From != s.config.TargetFrom {
+	if normalizeSender(email.From) != normalizeSender(s.config.TargetFrom) {
 		locallog.Infof("Email received from %s, skip ...", email.From)
-		return false
+		return Decision{Outcome: models.OutcomeWrongSender, Reason: "sender " + email.From + " is not " + s.config.TargetFrom}
 	}
 
 	// Filter by subject
-	if email.Subject != s.config.TargetSubject {
-		locallog.Infof("Email subject not recognized: %s", email.Subject)
-		return false
+	if !s.subjects.Match(email.Subject) {
+		locallog.WithField("normalized_subject", normalizeSubject(email.Subject)).
+			Infof("Email subject not recognized: %s", email.Subject)
+		return Decision{Outcome: models.OutcomeWrongSubject, Reason: "subject not recognized: " + normalizeSubject(email.Subject)}
 	}
 
 	// Check body not empty
-	if email.BodyText == "" {
+	if email.BodyText == "" && email.BodyHTML == "" {
 		locallog.Info("Empty email body, nothing to process")
-		return false
+		return Decision{Outcome: models.OutcomeEmptyBody, Reason: "empty body"}
 	}
 
-	// Extract and process links
-	links := mailparse.ExtractLinks(email.BodyText)
-	for _, link := range links {
-		if !strings.Contains(link, "update-primary-location") {
-			continue
+	// Process links (extracted by the parser from both parts, or from the text body as a fallback)
+	links := email.Links
+	if len(links) == 0 {
+		links = mailparse.ExtractLinks(email.BodyText)
+	}
+
+	link, rejected := s.selectLink(links, email.TraceID)
+	if link == "" {
+		if rejected {
+			locallog.Warn("Only rejected update-primary-location links found in email")
+			return Decision{Outcome: models.OutcomeRejectedLink, Reason: "only rejected update-primary-location links"}
+		}
+		locallog.Info("No update-primary-location link found in email")
+		return Decision{Outcome: models.OutcomeNoLink, Reason: "no update-primary-location link"}
+	}
+
+	return Decision{Link: link, Reason: "sender and subject match, update link found"}
+}
+
+// Screen applies the sender and subject filters to the envelope of an email, before its body is downloaded. It
+// returns the outcome of an email that cannot match, or "" when the email must be evaluated in full. Emails from
+// other senders whose subject matches may be forwarded, and trusted forwarders may reword the subject: both are
+// evaluated in full.
+func (s *Service) Screen(from, subject string) models.Outcome {
+	switch normalizeSender(from) {
+	case normalizeSender(s.config.TargetFrom):
+		if !s.subjects.Match(subject) {
+			return models.OutcomeWrongSubject
+		}
+	default:
+		if !s.isTrustedForwarder(from) && !s.subjects.Match(subject) {
+			return models.OutcomeWrongSender
 		}
+	}
+	return ""
+}
+
+// SearchTerms returns the senders (the expected sender and the trusted forwarders) and the subject word the IMAP
+// search can be restricted to. Both are empty when the emails cannot be selected that way. There is no subject
+// word with trusted forwarders, which may reword the subject.
+func (s *Service) SearchTerms() (senders []string, subject string) {
+	if s.config.TargetFrom != "" {
+		senders = append([]string{s.config.TargetFrom}, s.config.TrustedForwarders...)
+	}
+	if len(s.config.TrustedForwarders) > 0 {
+		return senders, ""
+	}
+	return senders, s.subjects.Keyword()
+}
+
+// Validate opens the update link selected by Evaluate with the browser and returns the outcome.
+func (s *Service) Validate(email *models.Email, link string) models.Outcome {
+	locallog := logging.Log.WithField("trace_id", email.TraceID)
 
-		locallog.Infof("Email received for %s", email.ToPrimary)
+	locallog.Infof("Email received for %s", email.ToPrimary)
 
-		// Open link with browser
-		result, err := s.browser.OpenUpdatePrimaryLocation(link, email.TraceID)
+	// Open link with browser
+	result, err := s.browser.OpenUpdatePrimaryLocation(link, email.TraceID)
+	if err != nil {
+		locallog.WithError(err).Error("Browser error")
+		return models.OutcomeBrowserError
+	}
+
+	return models.OutcomeFromBrowserResult(result)
+}
+
+// selectLink unwraps the candidate links and returns the first update-primary-location link that passes the
+// host allowlist. rejected reports whether update links were found but refused.
+func (s *Service) selectLink(links []string, traceID string) (link string, rejected bool) {
+	locallog := logging.Log.WithField("trace_id", traceID)
+
+	for _, candidate := range links {
+		unwrapped, err := s.unwrapper.Unwrap(candidate)
 		if err != nil {
-			locallog.WithError(err).Error("Browser error")
-			return false
+			if strings.Contains(candidate, "update-primary-location") {
+				rejected = true
+			}
+			locallog.WithError(err).Warnf("Rejected link %s: cannot unwrap", SanitizeURL(candidate))
+			continue
 		}
 
-		switch result {
-		case models.ResultSuccess, models.ResultExpired:
-			return true
-		case models.ResultAbort:
-			return false
-		case models.ResultFailed:
-			return false
+		if !strings.Contains(unwrapped, "update-primary-location") {
+			continue
+		}
+
+		if err := s.links.Check(unwrapped); err != nil {
+			rejected = true
+			locallog.WithField("reason", err.Error()).Warnf("Rejected link %s", SanitizeURL(unwrapped))
+			continue
+		}
+
+		if unwrapped != candidate {
+			locallog.Infof("Unwrapped link %s", SanitizeURL(unwrapped))
 		}
+		return unwrapped, false
 	}
 
-	locallog.Info("No update-primary-location link found in email")
+	return "", rejected
+}
+
+// isTrustedForwarder reports whether the address is one of the configured trusted forwarders
+func (s *Service) isTrustedForwarder(address string) bool {
+	normalized := normalizeSender(address)
+	for _, forwarder := range s.config.TrustedForwarders {
+		if normalizeSender(forwarder) == normalized {
+			return true
+		}
+	}
 	return false
 }
@@ -176,3 +176,136 @@ func TestHandleEmail_BrowserResultAbort(t *testing.T) {
 		t.Error("Expected abort result to return false")
 	}
 }
+
+func TestHandleEmail_HTMLOnlyBody(t *testing.T) {
+	cfg := &models.Config{
+		TargetFrom:    "info@account.netflix.com",
+		TargetSubject: "Test Subject",
+	}
+
+	mockBrowser := &MockBrowser{Result: models.ResultSuccess}
+	svc := NewService(mockBrowser, cfg)
+
+	email := &models.Email{
+		From:      "info@account.netflix.com",
+		Subject:   "Test Subject",
+		BodyHTML:  `<a href="https://netflix.com/update-primary-location?token=abc">Update</a>`,
+		Links:     []string{"https://netflix.com/update-primary-location?token=abc"},
+		ToPrimary: "user@example.com",
+		TraceID:   "test-trace",
+	}
+
+	handled := svc.HandleEmail(email)
+	if !handled {
+		t.Error("Expected HTML-only email to be handled")
+	}
+}
+
+func TestHandleEmail_ForwardedByTrustedForwarder(t *testing.T) {
+	cfg := &models.Config{
+		TargetFrom:        "info@account.netflix.com",
+		TargetSubject:     "Test Subject",
+		TrustedForwarders: models.StringList{"member@example.com"},
+	}
+
+	mockBrowser := &MockBrowser{Result: models.ResultSuccess}
+	svc := NewService(mockBrowser, cfg)
+
+	email := &models.Email{
+		From:        "info@account.netflix.com",
+		ForwardedBy: "Member@Example.com",
+		Subject:     "Test Subject",
+		BodyText:    "https://netflix.com/update-primary-location?token=abc",
+		ToPrimary:   "member@example.com",
+		TraceID:     "test-trace",
+	}
+
+	handled := svc.HandleEmail(email)
+	if !handled {
+		t.Error("Expected email forwarded by a trusted forwarder to be handled")
+	}
+}
+
+func TestHandleEmail_ForwardedByUntrustedForwarder(t *testing.T) {
+	cfg := &models.Config{
+		TargetFrom:        "info@account.netflix.com",
+		TargetSubject:     "Test Subject",
+		TrustedForwarders: models.StringList{"member@example.com"},
+	}
+
+	mockBrowser := &MockBrowser{Result: models.ResultSuccess}
+	svc := NewService(mockBrowser, cfg)
+
+	email := &models.Email{
+		From:        "info@account.netflix.com",
+		ForwardedBy: "stranger@example.com",
+		Subject:     "Test Subject",
+		BodyText:    "https://netflix.com/update-primary-location?token=abc",
+		ToPrimary:   "member@example.com",
+		TraceID:     "test-trace",
+	}
+
+	handled := svc.HandleEmail(email)
+	if handled {
+		t.Error("Expected email forwarded by an untrusted forwarder to be rejected")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	svc := NewService(&MockBrowser{}, &models.Config{
+		TargetFrom:        "info@account.netflix.com",
+		TargetSubject:     "Test Subject",
+		TrustedForwarders: models.StringList{"partner@example.com"},
+	})
+
+	tests := []struct {
+		name    string
+		from    string
+		subject string
+		want    models.Outcome
+	}{
+		{"Netflix email", "info@account.netflix.com", "Test Subject", ""},
+		{"Other Netflix email", "info@account.netflix.com", "New sign-in", models.OutcomeWrongSubject},
+		{"Possible forward", "someone@example.com", "Fwd: Test Subject", ""},
+		{"Trusted forwarder", "partner@example.com", "look at this", ""},
+		{"Newsletter", "news@example.com", "Weekly digest", models.OutcomeWrongSender},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := svc.Screen(tt.from, tt.subject); got != tt.want {
+				t.Errorf("Screen() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	senders, subject := svc.SearchTerms()
+	if len(senders) != 2 || senders[0] != "info@account.netflix.com" || subject != "" {
+		t.Errorf("SearchTerms() = %v, %q", senders, subject)
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		name        string
+		forwarders  models.StringList
+		wantSenders int
+		wantSubject string
+	}{
+		{"Netflix only", nil, 1, "Netflix"},
+		// Trusted forwarders may reword the subject: it cannot be searched
+		{"Trusted forwarders", models.StringList{"partner@example.com"}, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := NewService(&MockBrowser{}, &models.Config{
+				TargetFrom:        "info@account.netflix.com",
+				TargetSubject:     "Important : comment mettre à jour votre foyer Netflix",
+				TrustedForwarders: tt.forwarders,
+			})
+			senders, subject := svc.SearchTerms()
+			if len(senders) != tt.wantSenders || subject != tt.wantSubject {
+				t.Errorf("SearchTerms() = %v, %q, want %d sender(s), %q", senders, subject, tt.wantSenders, tt.wantSubject)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package netflix
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+)
+
+// AllLocales is the subjectLocales value selecting the whole catalog
+const AllLocales = "all"
+
+// subjectCatalog lists the known subjects of the "update your Netflix Household" email per locale. It only holds
+// subjects seen in real emails: other locales (e.g. ja, ko, tr) need targetSubjects or subjectPatterns. Netflix
+// occasionally rewords these too, so users can extend the catalog the same way.
+var subjectCatalog = map[string][]string{
+	"da": {"Vigtigt: Sådan opdaterer du din Netflix-husstand"},
+	"de": {"Wichtig: So aktualisierst du deinen Netflix-Haushalt"},
+	"en": {"Important: How to update your Netflix Household"},
+	"es": {"Importante: Cómo actualizar tu hogar con Netflix"},
+	"fi": {"Tärkeää: Näin päivität Netflix-kotitaloutesi"},
+	"fr": {"Important : comment mettre à jour votre foyer Netflix"},
+	"it": {"Importante: come aggiornare il tuo nucleo domestico Netflix"},
+	"nb": {"Viktig: Slik oppdaterer du Netflix-husstanden din"},
+	"nl": {"Belangrijk: zo werk je je Netflix-huishouden bij"},
+	"pl": {"Ważne: jak zaktualizować gospodarstwo domowe Netflix"},
+	"pt": {
+		"Importante: como atualizar sua residência Netflix",
+		"Importante: como atualizar o seu agregado familiar Netflix",
+	},
+	"sv": {"Viktigt: Så uppdaterar du ditt Netflix-hushåll"},
+}
+
+// CatalogLocales returns the locales available in the built-in subject catalog, sorted
+func CatalogLocales() []string {
+	locales := make([]string, 0, len(subjectCatalog))
+	for locale := range subjectCatalog {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// subjectMatcher decides whether an email subject is a household update email. Subjects are compared
+// in their normalized form (see normalizeSubject); patterns are tried on both the raw and normalized forms.
+type subjectMatcher struct {
+	subjects map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// newSubjectMatcher builds the matcher from the catalog locales, user-supplied subjects and patterns.
+// When nothing is configured at all, the whole catalog is used.
+func newSubjectMatcher(cfg *models.Config) *subjectMatcher {
+	m := &subjectMatcher{subjects: make(map[string]struct{})}
+
+	locales := cfg.SubjectLocales
+	if cfg.TargetSubject == "" && len(cfg.TargetSubjects) == 0 && len(locales) == 0 && len(cfg.SubjectPatterns) == 0 {
+		locales = models.StringList{AllLocales}
+	}
+
+	for _, locale := range locales {
+		locale = strings.ToLower(strings.TrimSpace(locale))
+		if locale == AllLocales {
+			for _, subjects := range subjectCatalog {
+				m.add(subjects...)
+			}
+			continue
+		}
+
+		subjects, ok := subjectCatalog[locale]
+		if !ok {
+			logging.Log.Warnf("Unknown subject locale %q (available: %s)", locale, strings.Join(CatalogLocales(), ", "))
+			continue
+		}
+		m.add(subjects...)
+	}
+
+	if cfg.TargetSubject != "" {
+		m.add(cfg.TargetSubject)
+	}
+	m.add(cfg.TargetSubjects...)
+
+	for _, pattern := range cfg.SubjectPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logging.Log.WithError(err).Errorf("Ignoring invalid subject pattern %q", pattern)
+			continue
+		}
+		m.patterns = append(m.patterns, re)
+	}
+
+	return m
+}
+
+// add registers accepted subjects
+func (m *subjectMatcher) add(subjects ...string) {
+	for _, subject := range subjects {
+		m.subjects[normalizeSubject(subject)] = struct{}{}
+	}
+}
+
+// searchKeyword is the word every subject of the catalog contains
+const searchKeyword = "Netflix"
+
+// Keyword returns a word every accepted subject contains, usable for a server-side search, or "" when there is
+// none (e.g. with patterns)
+func (m *subjectMatcher) Keyword() string {
+	if len(m.patterns) > 0 || len(m.subjects) == 0 {
+		return ""
+	}
+	keyword := caseFolder.String(searchKeyword)
+	for subject := range m.subjects {
+		if !strings.Contains(subject, keyword) {
+			return ""
+		}
+	}
+	return searchKeyword
+}
+
+// Match reports whether the subject is one of the accepted subjects or matches one of the patterns
+func (m *subjectMatcher) Match(subject string) bool {
+	normalized := normalizeSubject(subject)
+	if _, ok := m.subjects[normalized]; ok {
+		return true
+	}
+
+	for _, re := range m.patterns {
+		if re.MatchString(subject) || re.MatchString(normalized) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,106 @@
+package netflix
+
+import (
+	"testing"
+
+	"netflix-household-validator/internal/models"
+)
+
+func TestSubjectMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *models.Config
+		subject  string
+		expected bool
+	}{
+		{
+			name:     "Legacy target subject",
+			cfg:      &models.Config{TargetSubject: "Custom Subject"},
+			subject:  "Custom Subject",
+			expected: true,
+		},
+		{
+			name:     "Legacy target subject does not enable catalog",
+			cfg:      &models.Config{TargetSubject: "Custom Subject"},
+			subject:  "Important: How to update your Netflix Household",
+			expected: false,
+		},
+		{
+			name:     "Nothing configured uses whole catalog",
+			cfg:      &models.Config{},
+			subject:  "Wichtig: So aktualisierst du deinen Netflix-Haushalt",
+			expected: true,
+		},
+		{
+			name:     "Selected locale",
+			cfg:      &models.Config{SubjectLocales: models.StringList{"fr", "en"}},
+			subject:  "Important: How to update your Netflix Household",
+			expected: true,
+		},
+		{
+			name:     "Locale not selected",
+			cfg:      &models.Config{SubjectLocales: models.StringList{"fr"}},
+			subject:  "Important: How to update your Netflix Household",
+			expected: false,
+		},
+		{
+			name:     "All locales",
+			cfg:      &models.Config{SubjectLocales: models.StringList{"all"}},
+			subject:  "Importante: come aggiornare il tuo nucleo domestico Netflix",
+			expected: true,
+		},
+		{
+			name:     "User-supplied subject list",
+			cfg:      &models.Config{SubjectLocales: models.StringList{"fr"}, TargetSubjects: []string{"New wording"}},
+			subject:  "New wording",
+			expected: true,
+		},
+		{
+			name:     "Pattern",
+			cfg:      &models.Config{SubjectPatterns: []string{`(?i)netflix household`}},
+			subject:  "Action required: update your NETFLIX HOUSEHOLD now",
+			expected: true,
+		},
+		{
+			name:     "Invalid pattern is ignored",
+			cfg:      &models.Config{SubjectPatterns: []string{`(`}},
+			subject:  "(",
+			expected: false,
+		},
+		{
+			name:     "Unknown locale is ignored",
+			cfg:      &models.Config{SubjectLocales: models.StringList{"xx"}},
+			subject:  "Important: How to update your Netflix Household",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newSubjectMatcher(tt.cfg)
+			if got := m.Match(tt.subject); got != tt.expected {
+				t.Errorf("Match(%q) = %v, want %v", tt.subject, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSubjectMatcher_Keyword(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *models.Config
+		want string
+	}{
+		{"Whole catalog", &models.Config{}, "Netflix"},
+		{"Custom subject with the keyword", &models.Config{TargetSubject: "Your NETFLIX household"}, "Netflix"},
+		{"Custom subject without the keyword", &models.Config{TargetSubjects: models.StringList{"Household update"}, SubjectLocales: models.StringList{"en"}}, ""},
+		{"Patterns", &models.Config{SubjectPatterns: models.StringList{"(?i)netflix"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newSubjectMatcher(tt.cfg).Keyword(); got != tt.want {
+				t.Errorf("Keyword() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
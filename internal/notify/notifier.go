@@ -0,0 +1,205 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+)
+
+// EventType identifies what a notification is about
+type EventType string
+
+const (
+	EventSuccess  EventType = "success"
+	EventExpired  EventType = "expired"
+	EventAbort    EventType = "abort"
+	EventFailure  EventType = "failure"
+	EventIMAPDown EventType = "imap_down"
+)
+
+// defaultEvents are notified when a target does not list its events
+var defaultEvents = []EventType{EventAbort, EventFailure, EventIMAPDown}
+
+const defaultTitle = `Netflix household: {{.Type}}`
+
+var defaultMessages = map[EventType]string{
+	EventSuccess:  `Household updated for {{.Recipient}} (account {{.Account}}, trace {{.TraceID}}).`,
+	EventExpired:  `The household update link for {{.Recipient}} had expired, request a new one from the TV (account {{.Account}}, trace {{.TraceID}}).`,
+	EventAbort:    `Netflix asked for a login, the household update for {{.Recipient}} was aborted: confirm it manually (account {{.Account}}, trace {{.TraceID}}).`,
+	EventFailure:  `The household update for {{.Recipient}} failed ({{.Outcome}}): confirm it manually (account {{.Account}}, trace {{.TraceID}}).`,
+	EventIMAPDown: `The IMAP connection of account {{.Account}} is down since {{.Detail}}.`,
+}
+
+// Event is the data available to the notification templates
+type Event struct {
+	Type      EventType
+	Account   string
+	Recipient string
+	TraceID   string
+	Outcome   models.Outcome
+	Detail    string
+	Time      time.Time
+}
+
+// EventForOutcome returns the event matching a processing outcome, if any
+func EventForOutcome(outcome models.Outcome) (EventType, bool) {
+	switch outcome {
+	case models.OutcomeValidated:
+		return EventSuccess, true
+	case models.OutcomeExpired:
+		return EventExpired, true
+	case models.OutcomeAborted:
+		return EventAbort, true
+	case models.OutcomeFailed, models.OutcomeBrowserError:
+		return EventFailure, true
+	}
+	return "", false
+}
+
+// target is a configured notification destination with its events and templates
+type target struct {
+	name     string
+	sender   sender
+	events   map[EventType]bool
+	title    *template.Template
+	messages map[EventType]*template.Template
+}
+
+// Notifier sends events to the configured targets. Sends run in the background, Close waits for them.
+type Notifier struct {
+	targets       []*target
+	client        *http.Client
+	imapDownAfter time.Duration
+	wg            sync.WaitGroup
+}
+
+// New creates a Notifier from the configuration, failing on invalid URLs, events or templates
+func New(cfg models.NotificationsConfig) (*Notifier, error) {
+	n := &Notifier{
+		client:        &http.Client{Timeout: 10 * time.Second},
+		imapDownAfter: time.Duration(cfg.IMAPDownMinutes) * time.Minute,
+	}
+
+	for i, tc := range cfg.Targets {
+		t, err := newTarget(tc)
+		if err != nil {
+			return nil, fmt.Errorf("notification target #%d: %w", i+1, err)
+		}
+		n.targets = append(n.targets, t)
+	}
+	return n, nil
+}
+
+func newTarget(tc models.NotificationTarget) (*target, error) {
+	s, err := parseTarget(tc.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &target{
+		name:     targetName(tc.URL),
+		sender:   s,
+		events:   make(map[EventType]bool),
+		messages: make(map[EventType]*template.Template),
+	}
+
+	events := defaultEvents
+	if len(tc.Events) > 0 {
+		events = nil
+		for _, e := range tc.Events {
+			event := EventType(e)
+			if _, ok := defaultMessages[event]; !ok {
+				return nil, fmt.Errorf("unknown event %q", e)
+			}
+			events = append(events, event)
+		}
+	}
+
+	titleText := defaultTitle
+	if tc.Title != "" {
+		titleText = tc.Title
+	}
+	if t.title, err = template.New("title").Parse(titleText); err != nil {
+		return nil, fmt.Errorf("invalid title template: %w", err)
+	}
+
+	for _, event := range events {
+		t.events[event] = true
+
+		messageText := defaultMessages[event]
+		if tc.Message != "" {
+			messageText = tc.Message
+		}
+		if t.messages[event], err = template.New(string(event)).Parse(messageText); err != nil {
+			return nil, fmt.Errorf("invalid message template: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// IMAPDownAfter returns how long an IMAP connection must be down before EventIMAPDown is sent (0: never)
+func (n *Notifier) IMAPDownAfter() time.Duration {
+	if n == nil {
+		return 0
+	}
+	return n.imapDownAfter
+}
+
+// Notify sends the event to every target subscribed to it, in the background. A nil Notifier does nothing.
+func (n *Notifier) Notify(event Event) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	locallog := logging.Log.WithField("trace_id", event.TraceID)
+
+	for _, t := range n.targets {
+		if !t.events[event.Type] {
+			continue
+		}
+
+		msg, err := t.render(event)
+		if err != nil {
+			locallog.WithError(err).Errorf("Failed to render %s notification", event.Type)
+			continue
+		}
+
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			if err := t.sender.send(n.client, msg); err != nil {
+				locallog.WithError(err).Errorf("Failed to send %s notification (%s)", event.Type, t.name)
+				return
+			}
+			locallog.Infof("Sent %s notification (%s)", event.Type, t.name)
+		}()
+	}
+}
+
+// Close waits for the pending notifications to be sent
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
+
+func (t *target) render(event Event) (message, error) {
+	var title, body bytes.Buffer
+	if err := t.title.Execute(&title, event); err != nil {
+		return message{}, err
+	}
+	if err := t.messages[event.Type].Execute(&body, event); err != nil {
+		return message{}, err
+	}
+	return message{Event: event, Title: title.String(), Body: body.String()}, nil
+}
@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"netflix-household-validator/internal/models"
+)
+
+// request is a request received by the test server
+type request struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// recorder is a test server recording the received requests
+type recorder struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []request
+}
+
+func newRecorder(t *testing.T) *recorder {
+	t.Helper()
+	r := &recorder{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, request{path: req.URL.RequestURI(), header: req.Header, body: string(body)})
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *recorder) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    sender
+		wantErr bool
+	}{
+		{
+			name: "json webhook with secret",
+			raw:  "jsons://hooks.example.com/netflix?secret=s3cr3t&x=1",
+			want: &webhookSender{url: "https://hooks.example.com/netflix?x=1", secret: "s3cr3t"},
+		},
+		{
+			name: "ntfy public topic",
+			raw:  "ntfy://my-topic",
+			want: &ntfySender{url: "https://ntfy.sh/my-topic"},
+		},
+		{
+			name: "ntfy self-hosted",
+			raw:  "ntfy://ntfy.local:8080/alerts",
+			want: &ntfySender{url: "http://ntfy.local:8080/alerts"},
+		},
+		{
+			name: "gotify with sub path",
+			raw:  "gotifys://push.example.com/gotify/AbCdEf",
+			want: &gotifySender{url: "https://push.example.com/gotify/message", token: "AbCdEf"},
+		},
+		{
+			name: "discord",
+			raw:  "discord://1234/token",
+			want: &discordSender{id: "1234", token: "token"},
+		},
+		{name: "discord without token", raw: "discord://1234", wantErr: true},
+		{name: "gotify without token", raw: "gotify://push.example.com", wantErr: true},
+		{name: "unsupported scheme", raw: "mailto://user@example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTarget(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if gotJSON, wantJSON := describe(got), describe(tt.want); gotJSON != wantJSON {
+				t.Errorf("parseTarget() = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// describe renders a sender with its unexported fields for comparison
+func describe(s sender) string {
+	switch v := s.(type) {
+	case *webhookSender:
+		return "webhook " + v.url + " " + v.secret
+	case *ntfySender:
+		return "ntfy " + v.url
+	case *gotifySender:
+		return "gotify " + v.url + " " + v.token
+	case *discordSender:
+		return "discord " + v.id + " " + v.token
+	}
+	return "unknown"
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	webhook := newRecorder(t)
+	ntfy := newRecorder(t)
+	discord := newRecorder(t)
+	discordAPI = discord.URL + "/api/webhooks"
+
+	notifier, err := New(models.NotificationsConfig{Targets: []models.NotificationTarget{
+		{URL: "json://" + webhook.host() + "/hook?secret=s3cr3t", Events: models.StringList{"success", "abort"}},
+		{URL: "ntfy://" + ntfy.host() + "/household", Message: "{{.Type}} for {{.Recipient}}"},
+		{URL: "discord://42/token", Events: models.StringList{"success"}},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if name := notifier.targets[2].name; name != "discord://xxxxx" {
+		t.Errorf("target name = %q, want the scheme without the webhook ID", name)
+	}
+
+	notifier.Notify(Event{Type: EventAbort, Account: "family", Recipient: "user@example.com", TraceID: "trace-1", Outcome: models.OutcomeAborted})
+	notifier.Notify(Event{Type: EventSuccess, Account: "family", Recipient: "user@example.com", TraceID: "trace-2", Outcome: models.OutcomeValidated})
+	notifier.Close()
+
+	// Webhook: both events, signed
+	if len(webhook.requests) != 2 {
+		t.Fatalf("webhook received %d request(s), want 2", len(webhook.requests))
+	}
+	for _, req := range webhook.requests {
+		if got, want := req.header.Get("X-Signature-256"), "sha256="+Sign("s3cr3t", []byte(req.body)); got != want {
+			t.Errorf("X-Signature-256 = %v, want %v", got, want)
+		}
+		if req.path != "/hook" {
+			t.Errorf("webhook path = %v, want /hook (secret removed)", req.path)
+		}
+		var payload map[string]any
+		if err := json.Unmarshal([]byte(req.body), &payload); err != nil {
+			t.Fatalf("webhook payload error = %v", err)
+		}
+		if payload["recipient"] != "user@example.com" || payload["account"] != "family" {
+			t.Errorf("webhook payload = %v", payload)
+		}
+	}
+
+	// ntfy: default events, custom template, success not subscribed
+	if len(ntfy.requests) != 1 {
+		t.Fatalf("ntfy received %d request(s), want 1", len(ntfy.requests))
+	}
+	if got := ntfy.requests[0].body; got != "abort for user@example.com" {
+		t.Errorf("ntfy body = %q, want %q", got, "abort for user@example.com")
+	}
+	if got := ntfy.requests[0].header.Get("Title"); got != "Netflix household: abort" {
+		t.Errorf("ntfy title = %q", got)
+	}
+
+	// Discord: success only, default template with the trace ID
+	if len(discord.requests) != 1 {
+		t.Fatalf("discord received %d request(s), want 1", len(discord.requests))
+	}
+	if discord.requests[0].path != "/api/webhooks/42/token" {
+		t.Errorf("discord path = %v", discord.requests[0].path)
+	}
+	if !strings.Contains(discord.requests[0].body, "trace-2") {
+		t.Errorf("discord body = %v, want trace ID", discord.requests[0].body)
+	}
+}
+
+func TestNew_InvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		target models.NotificationTarget
+	}{
+		{name: "unknown event", target: models.NotificationTarget{URL: "ntfy://topic", Events: models.StringList{"nope"}}},
+		{name: "invalid template", target: models.NotificationTarget{URL: "ntfy://topic", Message: "{{.Recipient"}},
+		{name: "invalid URL", target: models.NotificationTarget{URL: "smoke://signal"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(models.NotificationsConfig{Targets: []models.NotificationTarget{tt.target}}); err == nil {
+				t.Error("New() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestEventForOutcome(t *testing.T) {
+	tests := []struct {
+		outcome models.Outcome
+		want    EventType
+		wantOK  bool
+	}{
+		{models.OutcomeValidated, EventSuccess, true},
+		{models.OutcomeExpired, EventExpired, true},
+		{models.OutcomeAborted, EventAbort, true},
+		{models.OutcomeFailed, EventFailure, true},
+		{models.OutcomeBrowserError, EventFailure, true},
+		{models.OutcomeWrongSender, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := EventForOutcome(tt.outcome)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("EventForOutcome(%v) = %v, %v, want %v, %v", tt.outcome, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// discordAPI is the base URL of the Discord webhooks, a variable so that tests can point it to a local server
+var discordAPI = "https://discord.com/api/webhooks"
+
+// message is a rendered notification
+type message struct {
+	Event Event
+	Title string
+	Body  string
+}
+
+// sender delivers a rendered notification to a single target
+type sender interface {
+	send(client *http.Client, msg message) error
+}
+
+// parseTarget parses an Apprise-like notification URL:
+//   - json://host/path, jsons://host/path?secret=...  generic JSON webhook, HMAC-signed when a secret is given
+//   - ntfy://host/topic, ntfys://host/topic, ntfys://topic (ntfy.sh)
+//   - gotify://host/token, gotifys://host/token
+//   - discord://webhook_id/webhook_token
+func parseTarget(raw string) (sender, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notification URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "json", "jsons":
+		query := u.Query()
+		secret := query.Get("secret")
+		query.Del("secret")
+		u.RawQuery = query.Encode()
+		u.Scheme = httpScheme(u.Scheme == "jsons")
+		return &webhookSender{url: u.String(), secret: secret}, nil
+
+	case "ntfy", "ntfys":
+		topic := strings.Trim(u.Path, "/")
+		host := u.Host
+		if topic == "" {
+			// ntfys://topic targets the public ntfy.sh server
+			topic, host = u.Host, "ntfy.sh"
+		}
+		if topic == "" {
+			return nil, fmt.Errorf("missing ntfy topic in %s", u.Redacted())
+		}
+		endpoint := &url.URL{Scheme: httpScheme(u.Scheme == "ntfys" || host == "ntfy.sh"), Host: host, Path: "/" + topic}
+		return &ntfySender{url: endpoint.String(), user: u.User}, nil
+
+	case "gotify", "gotifys":
+		path := strings.Trim(u.Path, "/")
+		idx := strings.LastIndex(path, "/")
+		token := path[idx+1:]
+		if token == "" {
+			return nil, fmt.Errorf("missing gotify token in %s", u.Redacted())
+		}
+		endpoint := &url.URL{Scheme: httpScheme(u.Scheme == "gotifys"), Host: u.Host, Path: "/" + path[:idx+1] + "message"}
+		return &gotifySender{url: endpoint.String(), token: token}, nil
+
+	case "discord":
+		token := strings.Trim(u.Path, "/")
+		if u.Host == "" || token == "" {
+			return nil, fmt.Errorf("discord URL must be discord://webhook_id/webhook_token")
+		}
+		return &discordSender{id: u.Host, token: token}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported notification URL scheme %q", u.Scheme)
+}
+
+// targetName names a notification URL in the logs by its scheme, without the host or path that may hold secrets
+// (e.g. the Discord webhook ID and token)
+func targetName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid URL"
+	}
+	return u.Scheme + "://xxxxx"
+}
+
+func httpScheme(secure bool) string {
+	if secure {
+		return "https"
+	}
+	return "http"
+}
+
+// webhookSender posts the event as JSON. With a secret, the body is signed with HMAC-SHA256 in the
+// X-Signature-256 header ("sha256=<hex>"), as GitHub webhooks do.
+type webhookSender struct {
+	url    string
+	secret string
+}
+
+func (s *webhookSender) send(client *http.Client, msg message) error {
+	body, err := json.Marshal(map[string]any{
+		"event":     msg.Event.Type,
+		"account":   msg.Event.Account,
+		"recipient": msg.Event.Recipient,
+		"traceId":   msg.Event.TraceID,
+		"outcome":   msg.Event.Outcome,
+		"detail":    msg.Event.Detail,
+		"time":      msg.Event.Time,
+		"title":     msg.Title,
+		"message":   msg.Body,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.secret != "" {
+		req.Header.Set("X-Signature-256", "sha256="+Sign(s.secret, body))
+	}
+	return do(client, req)
+}
+
+// Sign returns the hex HMAC-SHA256 of body with the given secret, as sent in the X-Signature-256 header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ntfySender publishes the message to a ntfy topic
+type ntfySender struct {
+	url  string
+	user *url.Userinfo
+}
+
+func (s *ntfySender) send(client *http.Client, msg message) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, strings.NewReader(msg.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Title", msg.Title)
+	req.Header.Set("Tags", "tv")
+	if msg.Event.Type == EventAbort || msg.Event.Type == EventFailure || msg.Event.Type == EventIMAPDown {
+		req.Header.Set("Priority", "high")
+	}
+	if s.user != nil {
+		password, _ := s.user.Password()
+		req.SetBasicAuth(s.user.Username(), password)
+	}
+	return do(client, req)
+}
+
+// gotifySender pushes the message to a Gotify server
+type gotifySender struct {
+	url   string
+	token string
+}
+
+func (s *gotifySender) send(client *http.Client, msg message) error {
+	body, err := json.Marshal(map[string]any{"title": msg.Title, "message": msg.Body, "priority": 5})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", s.token)
+	return do(client, req)
+}
+
+// discordSender posts the message to a Discord (or compatible) webhook
+type discordSender struct {
+	id    string
+	token string
+}
+
+func (s *discordSender) send(client *http.Client, msg message) error {
+	body, err := json.Marshal(map[string]any{"content": "**" + msg.Title + "**\n" + msg.Body})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, discordAPI+"/"+s.id+"/"+s.token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return do(client, req)
+}
+
+// do sends the request and turns non-2xx responses into errors
+func do(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification target returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+)
+
+const (
+	// expiryMargin is how long before its expiry an access token is refreshed, so that it does not expire
+	// between the refresh and the authentication
+	expiryMargin = 5 * time.Minute
+	// defaultLifetime is the lifetime of an access token whose response does not tell it
+	defaultLifetime = time.Hour
+	// requestTimeout bounds the requests to the token endpoint
+	requestTimeout = 30 * time.Second
+)
+
+// tokenResponse is the successful response of the token endpoint (RFC 6749 section 5.1)
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// errorResponse is the error response of the token endpoint (RFC 6749 section 5.2)
+type errorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// TokenSource obtains access tokens from the refresh token of the configuration (RFC 6749 section 6) and caches
+// them until shortly before they expire. It is safe for concurrent use.
+type TokenSource struct {
+	cfg    models.OAuth2Config
+	client *http.Client
+
+	mu           sync.Mutex
+	refreshToken string
+	accessToken  string
+	expiry       time.Time
+	now          func() time.Time
+}
+
+// NewTokenSource creates the TokenSource of the configuration
+func NewTokenSource(cfg models.OAuth2Config) *TokenSource {
+	return &TokenSource{
+		cfg:          cfg,
+		client:       &http.Client{Timeout: requestTimeout},
+		refreshToken: cfg.RefreshToken,
+		now:          time.Now,
+	}
+}
+
+// Token returns a valid access token, refreshed when the cached one is missing or about to expire
+func (s *TokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && s.now().Before(s.expiry.Add(-expiryMargin)) {
+		return s.accessToken, nil
+	}
+	if err := s.refresh(); err != nil {
+		return "", err
+	}
+	return s.accessToken, nil
+}
+
+// Invalidate drops the cached access token, so that the next Token call refreshes it. It is called when the
+// server rejects the token, which may have been revoked before its expiry.
+func (s *TokenSource) Invalidate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accessToken, s.expiry = "", time.Time{}
+}
+
+// refresh exchanges the refresh token for a new access token
+func (s *TokenSource) refresh() error {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {s.refreshToken},
+		"client_id":     {s.cfg.ClientID},
+	}
+	if s.cfg.ClientSecret != "" {
+		form.Set("client_secret", s.cfg.ClientSecret)
+	}
+	if len(s.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.cfg.Scopes, " "))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("token response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var failure errorResponse
+		if json.Unmarshal(body, &failure) == nil && failure.Error != "" {
+			return fmt.Errorf("token endpoint error %s: %s", failure.Error, failure.Description)
+		}
+		return fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+
+	var token tokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("token response without access token")
+	}
+
+	lifetime := defaultLifetime
+	if token.ExpiresIn > 0 {
+		lifetime = time.Duration(token.ExpiresIn) * time.Second
+	}
+	s.accessToken, s.expiry = token.AccessToken, s.now().Add(lifetime)
+
+	// Some providers (e.g. Microsoft) rotate the refresh token
+	if token.RefreshToken != "" && token.RefreshToken != s.refreshToken {
+		s.refreshToken = token.RefreshToken
+		if s.cfg.RefreshTokenFile != "" {
+			if err := writeSecret(s.cfg.RefreshTokenFile, token.RefreshToken); err != nil {
+				logging.Log.Warnf("Error saving the rotated OAuth2 refresh token: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeSecret replaces the content of a secret file atomically
+func writeSecret(path, secret string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".refresh-token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(secret + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
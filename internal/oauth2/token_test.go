@@ -0,0 +1,101 @@
+package oauth2
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"netflix-household-validator/internal/models"
+)
+
+// tokenEndpoint is a stand-in for the token endpoint of a provider, which rotates the refresh token
+type tokenEndpoint struct {
+	mu       sync.Mutex
+	requests int
+	refresh  string
+}
+
+func (e *tokenEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_ = r.ParseForm()
+	w.Header().Set("Content-Type", "application/json")
+	if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("client_id") != "client" ||
+		r.PostForm.Get("client_secret") != "secret" || r.PostForm.Get("refresh_token") != e.refresh {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":"invalid_grant","error_description":"refresh token revoked"}`)
+		return
+	}
+
+	e.requests++
+	e.refresh = fmt.Sprintf("refresh-%d", e.requests)
+	fmt.Fprintf(w, `{"access_token":"access-%d","token_type":"Bearer","expires_in":3600,"refresh_token":%q}`,
+		e.requests, e.refresh)
+}
+
+func TestTokenSource(t *testing.T) {
+	endpoint := &tokenEndpoint{refresh: "refresh-0"}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "refresh-token")
+	source := NewTokenSource(models.OAuth2Config{
+		TokenURL:         server.URL,
+		ClientID:         "client",
+		ClientSecret:     "secret",
+		RefreshToken:     "refresh-0",
+		RefreshTokenFile: tokenFile,
+	})
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	source.now = func() time.Time { return now }
+
+	steps := []struct {
+		name       string
+		elapsed    time.Duration
+		invalidate bool
+		want       string
+	}{
+		{"first token", 0, false, "access-1"},
+		{"cached", 30 * time.Minute, false, "access-1"},
+		{"refreshed before expiry", 56 * time.Minute, false, "access-2"},
+		{"refreshed after an auth failure", 57 * time.Minute, true, "access-3"},
+	}
+	for _, step := range steps {
+		source.now = func() time.Time { return now.Add(step.elapsed) }
+		if step.invalidate {
+			source.Invalidate()
+		}
+		token, err := source.Token()
+		if err != nil || token != step.want {
+			t.Errorf("%s: Token() = %q, %v, want %q", step.name, token, err, step.want)
+		}
+	}
+
+	// The rotated refresh token is used for the next refresh and saved to the secret file
+	data, err := os.ReadFile(tokenFile)
+	if err != nil || strings.TrimSpace(string(data)) != "refresh-3" {
+		t.Errorf("refresh token file = %q, %v, want refresh-3", data, err)
+	}
+}
+
+func TestTokenSource_Error(t *testing.T) {
+	server := httptest.NewServer(&tokenEndpoint{refresh: "other"})
+	defer server.Close()
+
+	source := NewTokenSource(models.OAuth2Config{
+		TokenURL:     server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RefreshToken: "revoked",
+	})
+	if _, err := source.Token(); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("Token() error = %v, want the endpoint error", err)
+	}
+}
@@ -0,0 +1,179 @@
+package pop3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"netflix-household-validator/internal/models"
+)
+
+const (
+	// dialTimeout bounds the connection to the server
+	dialTimeout = 30 * time.Second
+	// commandTimeout bounds every command, including the download of a message
+	commandTimeout = 2 * time.Minute
+)
+
+// Entry is a message of the maildrop listed by UIDL
+type Entry struct {
+	// Number is the message number, only valid during the session
+	Number int
+	// UID is the unique ID of the message, stable across sessions
+	UID string
+}
+
+// Client is a minimal POP3 client (RFC 1939, RFC 2595)
+type Client struct {
+	conn net.Conn
+	text *textproto.Conn
+}
+
+// Dial connects to the server ("host:port") with the given security: models.SMTPSecurityTLS (implicit TLS),
+// models.SMTPSecuritySTARTTLS (STLS) or models.SMTPSecurityNone
+func Dial(addr, security string) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid POP3 server %q: %w", addr, err)
+	}
+	tlsConfig := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	var conn net.Conn
+	if security == models.SMTPSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{conn: conn, text: textproto.NewConn(conn)}
+	if _, err := c.response(); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("greeting: %w", err)
+	}
+
+	if security == models.SMTPSecuritySTARTTLS {
+		if _, err := c.cmd("STLS"); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("STLS: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("TLS handshake: %w", err)
+		}
+		c.conn, c.text = tlsConn, textproto.NewConn(tlsConn)
+	}
+	return c, nil
+}
+
+// Login authenticates with USER and PASS
+func (c *Client) Login(user, password string) error {
+	if _, err := c.cmd("USER %s", user); err != nil {
+		return fmt.Errorf("USER: %w", err)
+	}
+	if _, err := c.cmd("PASS %s", password); err != nil {
+		return fmt.Errorf("PASS: %w", err)
+	}
+	return nil
+}
+
+// UIDL lists the messages of the maildrop with their unique ID
+func (c *Client) UIDL() ([]Entry, error) {
+	if _, err := c.cmd("UIDL"); err != nil {
+		return nil, fmt.Errorf("UIDL: %w", err)
+	}
+	lines, err := c.text.ReadDotLines()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(lines))
+	for _, line := range lines {
+		number, uid, ok := strings.Cut(line, " ")
+		n, err := strconv.Atoi(number)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid UIDL line %q", line)
+		}
+		entries = append(entries, Entry{Number: n, UID: strings.TrimSpace(uid)})
+	}
+	return entries, nil
+}
+
+// Top returns the header and the first lines of the body of a message
+func (c *Client) Top(number, lines int) ([]byte, error) {
+	if _, err := c.cmd("TOP %d %d", number, lines); err != nil {
+		return nil, fmt.Errorf("TOP: %w", err)
+	}
+	return c.readMessage()
+}
+
+// Retr downloads a message
+func (c *Client) Retr(number int) ([]byte, error) {
+	if _, err := c.cmd("RETR %d", number); err != nil {
+		return nil, fmt.Errorf("RETR: %w", err)
+	}
+	return c.readMessage()
+}
+
+// Dele marks a message as deleted, it is removed when the session ends with Quit
+func (c *Client) Dele(number int) error {
+	if _, err := c.cmd("DELE %d", number); err != nil {
+		return fmt.Errorf("DELE: %w", err)
+	}
+	return nil
+}
+
+// Quit ends the session, which commits the deletions, and closes the connection
+func (c *Client) Quit() error {
+	_, err := c.cmd("QUIT")
+	_ = c.conn.Close()
+	return err
+}
+
+// Close closes the connection without ending the session: deletions are discarded
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// cmd sends a command and returns the text of its +OK response
+func (c *Client) cmd(format string, args ...any) (string, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(commandTimeout))
+	if err := c.text.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.response()
+}
+
+// response reads a single-line response
+func (c *Client) response() (string, error) {
+	line, err := c.text.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	if status, text, _ := strings.Cut(line, " "); status == "+OK" {
+		return text, nil
+	}
+	if strings.HasPrefix(line, "-ERR") {
+		return "", errors.New(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+	}
+	return "", fmt.Errorf("unexpected response %q", line)
+}
+
+// readMessage reads a dot-terminated message, restoring the CRLF line endings the DKIM signatures were computed on
+func (c *Client) readMessage() ([]byte, error) {
+	data, err := c.text.ReadDotBytes()
+	if err != nil {
+		return nil, err
+	}
+	return bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n")), nil
+}
@@ -0,0 +1,205 @@
+package pop3
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/mail"
+	"time"
+
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/models"
+)
+
+// HandledStore remembers the messages already handled across restarts (implemented by history.Store)
+type HandledStore interface {
+	RecordHandledUID(mailbox, uid string) error
+	HandledUID(mailbox, uid string) (bool, error)
+}
+
+// Mailbox is a POP3 maildrop used as message source. A session is opened for every poll; messages are identified
+// by their UIDL unique ID, and those handled are remembered (and deleted with Delete) so that they are not
+// processed again.
+type Mailbox struct {
+	server   string
+	security string
+	login    string
+	password string
+	delete   bool
+	store    HandledStore
+
+	client *Client
+	// numbers maps the unique IDs to the message numbers of the session
+	numbers map[string]int
+	// windowStart maps the unique IDs to the time their validity window starts: the Date header, or when the message
+	// was first listed. Handled messages have a zero time, outside any window.
+	windowStart map[string]time.Time
+	now         func() time.Time
+}
+
+// New creates the Mailbox of the configured server. store may be nil, handled messages are then only remembered
+// in memory.
+func New(cfg models.POP3Config, login, password string, store HandledStore) *Mailbox {
+	return &Mailbox{
+		server:      cfg.Server,
+		security:    cfg.Security,
+		login:       login,
+		password:    password,
+		delete:      cfg.Delete,
+		store:       store,
+		windowStart: make(map[string]time.Time),
+		now:         time.Now,
+	}
+}
+
+// Server returns the POP3 server
+func (m *Mailbox) Server() string {
+	return m.server
+}
+
+// key identifies the maildrop in the handled store
+func (m *Mailbox) key() string {
+	return "pop3://" + m.login + "@" + m.server
+}
+
+// Open starts a session
+func (m *Mailbox) Open() error {
+	client, err := Dial(m.server, m.security)
+	if err != nil {
+		return err
+	}
+	if err := client.Login(m.login, m.password); err != nil {
+		_ = client.Close()
+		return err
+	}
+	m.client = client
+	return nil
+}
+
+// Close ends the session, which removes the messages marked as processed in Delete mode
+func (m *Mailbox) Close() error {
+	if m.client == nil {
+		return nil
+	}
+	err := m.client.Quit()
+	m.client, m.numbers = nil, nil
+	return err
+}
+
+// List returns the unique IDs of the messages to process: not handled yet, and within the given validity window.
+// The header of new messages is read first, so that old messages are never downloaded.
+func (m *Mailbox) List(since time.Duration) ([]string, error) {
+	if m.client == nil {
+		return nil, errors.New("POP3 session not open")
+	}
+
+	entries, err := m.client.UIDL()
+	if err != nil {
+		return nil, err
+	}
+
+	now := m.now()
+	m.numbers = make(map[string]int, len(entries))
+	var uids []string
+	for _, entry := range entries {
+		m.numbers[entry.UID] = entry.Number
+
+		start, known := m.windowStart[entry.UID]
+		if !known {
+			handled, err := m.handled(entry.UID)
+			if err != nil {
+				return nil, err
+			}
+			if !handled {
+				start = m.headerDate(entry.Number, now)
+			}
+			m.windowStart[entry.UID] = start
+		}
+
+		if now.Sub(start) <= since {
+			uids = append(uids, entry.UID)
+		}
+	}
+
+	// Forget the messages no longer on the server
+	for uid := range m.windowStart {
+		if _, ok := m.numbers[uid]; !ok {
+			delete(m.windowStart, uid)
+		}
+	}
+	return uids, nil
+}
+
+// Fetch implements emailprocessor.Source: the message is downloaded and parsed, its internal date is the start of
+// its validity window
+func (m *Mailbox) Fetch(uid string) (*models.Email, error) {
+	number, err := m.number(uid)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := m.client.Retr(number)
+	if err != nil {
+		return nil, err
+	}
+	email, err := mailparse.ParseRaw(raw)
+	if err != nil {
+		return nil, err
+	}
+	email.InternalDate = m.windowStart[uid]
+	return email, nil
+}
+
+// MarkProcessed implements emailprocessor.Source: the message is remembered as handled, and marked as deleted in
+// Delete mode
+func (m *Mailbox) MarkProcessed(uid string) error {
+	number, err := m.number(uid)
+	if err != nil {
+		return err
+	}
+
+	m.windowStart[uid] = time.Time{}
+	if m.store != nil {
+		if err := m.store.RecordHandledUID(m.key(), uid); err != nil {
+			return err
+		}
+	}
+	if m.delete {
+		return m.client.Dele(number)
+	}
+	return nil
+}
+
+// number returns the message number of the unique ID in the current session
+func (m *Mailbox) number(uid string) (int, error) {
+	number, ok := m.numbers[uid]
+	if !ok || m.client == nil {
+		return 0, fmt.Errorf("unknown POP3 message %q", uid)
+	}
+	return number, nil
+}
+
+// handled reports whether the message was handled in a previous run
+func (m *Mailbox) handled(uid string) (bool, error) {
+	if m.store == nil {
+		return false, nil
+	}
+	return m.store.HandledUID(m.key(), uid)
+}
+
+// headerDate returns the Date header of a message, or now when it cannot be read
+func (m *Mailbox) headerDate(number int, now time.Time) time.Time {
+	header, err := m.client.Top(number, 0)
+	if err != nil {
+		return now
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(header))
+	if err != nil {
+		return now
+	}
+	date, err := msg.Header.Date()
+	if err != nil || date.After(now) {
+		return now
+	}
+	return date
+}
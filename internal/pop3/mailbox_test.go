@@ -0,0 +1,276 @@
+package pop3
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"netflix-household-validator/internal/models"
+)
+
+// fakeMessage is a message of the fake maildrop
+type fakeMessage struct {
+	uid  string
+	data string
+}
+
+// fakeServer is a minimal in-process POP3 server
+type fakeServer struct {
+	listener  net.Listener
+	mu        sync.Mutex
+	messages  []fakeMessage
+	retrieved []string
+}
+
+func newFakeServer(t *testing.T, messages ...fakeMessage) *fakeServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	s := &fakeServer{listener: l, messages: messages}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+	multi := func(data string) {
+		for _, line := range strings.Split(strings.TrimSuffix(data, "\r\n"), "\r\n") {
+			if strings.HasPrefix(line, ".") {
+				line = "." + line
+			}
+			reply(line)
+		}
+		reply(".")
+	}
+
+	s.mu.Lock()
+	session := append([]fakeMessage(nil), s.messages...)
+	s.mu.Unlock()
+	deleted := make(map[int]bool)
+	message := func(arg string) (fakeMessage, bool) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(session) || deleted[n] {
+			return fakeMessage{}, false
+		}
+		return session[n-1], true
+	}
+
+	reply("+OK fake POP3 ready")
+	authenticated := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(strings.TrimRight(line, "\r\n"))
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch cmd := strings.ToUpper(fields[0]); {
+		case cmd == "USER":
+			reply("+OK")
+		case cmd == "PASS":
+			if len(fields) < 2 || fields[1] != "secret" {
+				reply("-ERR invalid credentials")
+				continue
+			}
+			authenticated = true
+			reply("+OK logged in")
+		case cmd == "QUIT":
+			s.mu.Lock()
+			var kept []fakeMessage
+			for i, msg := range session {
+				if !deleted[i+1] {
+					kept = append(kept, msg)
+				}
+			}
+			s.messages = kept
+			s.mu.Unlock()
+			reply("+OK bye")
+			return
+		case !authenticated:
+			reply("-ERR not authenticated")
+		case cmd == "UIDL":
+			reply("+OK")
+			var lines []string
+			for i, msg := range session {
+				if !deleted[i+1] {
+					lines = append(lines, fmt.Sprintf("%d %s", i+1, msg.uid))
+				}
+			}
+			multi(strings.Join(lines, "\r\n") + "\r\n")
+		case cmd == "TOP" || cmd == "RETR":
+			msg, ok := message(fields[1])
+			if !ok {
+				reply("-ERR no such message")
+				continue
+			}
+			reply("+OK")
+			if cmd == "TOP" {
+				header, _, _ := strings.Cut(msg.data, "\r\n\r\n")
+				multi(header + "\r\n\r\n")
+				continue
+			}
+			s.mu.Lock()
+			s.retrieved = append(s.retrieved, msg.uid)
+			s.mu.Unlock()
+			multi(msg.data)
+		case cmd == "DELE":
+			if _, ok := message(fields[1]); !ok {
+				reply("-ERR no such message")
+				continue
+			}
+			n, _ := strconv.Atoi(fields[1])
+			deleted[n] = true
+			reply("+OK deleted")
+		default:
+			reply("-ERR unknown command")
+		}
+	}
+}
+
+func (s *fakeServer) state() (uids, retrieved []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, msg := range s.messages {
+		uids = append(uids, msg.uid)
+	}
+	return uids, append([]string(nil), s.retrieved...)
+}
+
+// memoryStore is an in-memory HandledStore
+type memoryStore map[string]bool
+
+func (s memoryStore) RecordHandledUID(mailbox, uid string) error {
+	s[mailbox+" "+uid] = true
+	return nil
+}
+
+func (s memoryStore) HandledUID(mailbox, uid string) (bool, error) {
+	return s[mailbox+" "+uid], nil
+}
+
+func testMessage(date time.Time, body string) string {
+	return "From: info@account.netflix.com\r\nTo: owner@example.com\r\nSubject: Netflix household\r\n" +
+		"Date: " + date.Format(time.RFC1123Z) + "\r\n\r\n" + body + "\r\n.hidden dot\r\n"
+}
+
+func TestMailbox(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	server := newFakeServer(t,
+		fakeMessage{uid: "old", data: testMessage(now.Add(-time.Hour), "old")},
+		fakeMessage{uid: "new-1", data: testMessage(now.Add(-time.Minute), "https://www.netflix.com/account/update-primary-location?nftoken=1")},
+		fakeMessage{uid: "new-2", data: testMessage(now.Add(-2*time.Minute), "ignored")},
+	)
+
+	for _, del := range []bool{false, true} {
+		t.Run(fmt.Sprintf("delete=%v", del), func(t *testing.T) {
+			store := memoryStore{}
+			cfg := models.POP3Config{Server: server.listener.Addr().String(), Security: models.SMTPSecurityNone, Delete: del}
+			m := New(cfg, "owner", "secret", store)
+			m.now = func() time.Time { return now }
+
+			if err := m.Open(); err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			uids, err := m.List(15 * time.Minute)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			if strings.Join(uids, ",") != "new-1,new-2" {
+				t.Fatalf("List() = %v, want the recent messages", uids)
+			}
+
+			email, err := m.Fetch("new-1")
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if !email.InternalDate.Equal(now.Add(-time.Minute)) || len(email.Links) != 1 {
+				t.Errorf("email = date %v, links %v", email.InternalDate, email.Links)
+			}
+			if !strings.Contains(string(email.Raw), "\r\n.hidden dot\r\n") {
+				t.Errorf("Raw not restored: %q", email.Raw)
+			}
+
+			if err := m.MarkProcessed("new-1"); err != nil {
+				t.Fatalf("MarkProcessed() error = %v", err)
+			}
+			if err := m.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			onServer, _ := server.state()
+			if deleted := !strings.Contains(strings.Join(onServer, ","), "new-1"); deleted != del {
+				t.Errorf("messages on the server = %v, delete = %v", onServer, del)
+			}
+
+			// A new Mailbox (restart) skips the handled message thanks to the store
+			m = New(cfg, "owner", "secret", store)
+			m.now = func() time.Time { return now.Add(5 * time.Minute) }
+			if err := m.Open(); err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer m.Close()
+			if uids, err = m.List(15 * time.Minute); err != nil || strings.Join(uids, ",") != "new-2" {
+				t.Errorf("List() after restart = %v, %v, want [new-2]", uids, err)
+			}
+		})
+	}
+
+	// Old messages are never downloaded, only their header is read
+	if _, retrieved := server.state(); strings.Contains(strings.Join(retrieved, ","), "old") {
+		t.Errorf("retrieved = %v, old message downloaded", retrieved)
+	}
+}
+
+func TestMailbox_WindowExpires(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	server := newFakeServer(t, fakeMessage{uid: "undated", data: "Subject: no date\r\n\r\nbody\r\n"})
+
+	m := New(models.POP3Config{Server: server.listener.Addr().String(), Security: models.SMTPSecurityNone}, "owner", "secret", nil)
+	m.now = func() time.Time { return now }
+
+	for _, tt := range []struct {
+		elapsed time.Duration
+		want    int
+	}{{0, 1}, {10 * time.Minute, 1}, {20 * time.Minute, 0}} {
+		m.now = func() time.Time { return now.Add(tt.elapsed) }
+		if err := m.Open(); err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		uids, err := m.List(15 * time.Minute)
+		_ = m.Close()
+		if err != nil || len(uids) != tt.want {
+			t.Errorf("List() after %v = %v, %v, want %d message(s)", tt.elapsed, uids, err, tt.want)
+		}
+	}
+}
+
+func TestMailbox_LoginFailure(t *testing.T) {
+	server := newFakeServer(t)
+	m := New(models.POP3Config{Server: server.listener.Addr().String(), Security: models.SMTPSecurityNone}, "owner", "wrong", nil)
+	if err := m.Open(); err == nil || !strings.Contains(err.Error(), "invalid credentials") {
+		t.Errorf("Open() error = %v, want the server error", err)
+	}
+}
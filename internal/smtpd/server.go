@@ -0,0 +1,290 @@
+package smtpd
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+
+	"github.com/emersion/go-smtp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxSize is the maximum message size when none is configured
+	defaultMaxSize = 10 << 20
+	// maxRecipients bounds the recipients of a single transaction
+	maxRecipients = 100
+	// maxLineLength bounds a command or message line, twice the limit of RFC 5321 section 4.5.3.1.6
+	maxLineLength = 2000
+	// commandTimeout bounds the wait for the next command, or for the message data
+	commandTimeout = 5 * time.Minute
+)
+
+var (
+	errAuthRequired = &smtp.SMTPError{Code: 530, EnhancedCode: smtp.EnhancedCode{5, 7, 0}, Message: "Authentication required"}
+	errInvalidAuth  = &smtp.SMTPError{Code: 535, EnhancedCode: smtp.EnhancedCode{5, 7, 8}, Message: "Authentication credentials invalid"}
+	errNoMailbox    = &smtp.SMTPError{Code: 550, EnhancedCode: smtp.EnhancedCode{5, 1, 1}, Message: "Mailbox unavailable"}
+	errTryLater     = &smtp.SMTPError{Code: 451, EnhancedCode: smtp.EnhancedCode{4, 3, 0}, Message: "Temporary failure, try again later"}
+)
+
+// Message is a message accepted by the server
+type Message struct {
+	From       string
+	Recipients []string
+	// Raw is the message as received, with its original line endings
+	Raw        []byte
+	RemoteAddr string
+	Received   time.Time
+}
+
+// Handler is given every accepted message, once the data has been received. An error is reported to the client
+// as a temporary failure so that it retries later.
+type Handler func(msg *Message) error
+
+// Server is an SMTP and LMTP server (RFC 5321, RFC 2033) accepting mail for a fixed set of recipients
+type Server struct {
+	hostname          string
+	username          string
+	password          string
+	allowInsecureAuth bool
+	networks          []netip.Prefix
+	tlsConfig         *tls.Config
+	maxSize           int64
+	accept            func(rcpt string) bool
+	handler           Handler
+
+	mu      sync.Mutex
+	servers []*smtp.Server
+}
+
+// New creates a Server from the configuration. accept reports whether a recipient address is served.
+func New(cfg models.InboundConfig, accept func(rcpt string) bool, handler Handler) (*Server, error) {
+	s := &Server{
+		hostname:          cfg.Hostname,
+		username:          cfg.Username,
+		password:          cfg.Password,
+		allowInsecureAuth: cfg.AllowInsecureAuth,
+		maxSize:           cfg.MaxSize,
+		accept:            accept,
+		handler:           handler,
+	}
+
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize
+	}
+
+	for _, network := range cfg.AllowedNetworks {
+		prefix, err := models.ParseNetwork(network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed network %q: %w", network, err)
+		}
+		s.networks = append(s.networks, prefix)
+	}
+
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		s.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+
+	return s, nil
+}
+
+// Listen opens a listener on "host:port", or on a Unix socket for "unix:/path"
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Remove the socket left by a previous run
+		_ = os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// Serve accepts connections on l until Close is called, speaking LMTP instead of SMTP when lmtp is true
+func (s *Server) Serve(l net.Listener, lmtp bool) error {
+	if err := s.CheckListener(l); err != nil {
+		return err
+	}
+
+	server := smtp.NewServer(&backend{server: s})
+	server.Domain = s.hostname
+	server.LMTP = lmtp
+	server.TLSConfig = s.tlsConfig
+	server.AllowInsecureAuth = s.allowInsecureAuth
+	server.AuthDisabled = s.username == ""
+	server.MaxMessageBytes = int(s.maxSize)
+	server.MaxLineLength = maxLineLength
+	server.MaxRecipients = maxRecipients
+	server.ReadTimeout = commandTimeout
+	server.WriteTimeout = commandTimeout
+	server.ErrorLog = logging.Log
+
+	s.mu.Lock()
+	s.servers = append(s.servers, server)
+	s.mu.Unlock()
+
+	return server.Serve(&allowedListener{Listener: l, server: s})
+}
+
+// CheckListener refuses a TCP listener reachable from other hosts when neither AUTH nor allowed networks restrict
+// the clients, as anyone could then inject messages. Loopback addresses and Unix sockets are always accepted.
+func (s *Server) CheckListener(l net.Listener) error {
+	tcpAddr, ok := l.Addr().(*net.TCPAddr)
+	if !ok || tcpAddr.IP.IsLoopback() || s.username != "" || len(s.networks) > 0 {
+		return nil
+	}
+	return fmt.Errorf("refusing to accept mail on %s from any host: set a username or allowed networks", l.Addr())
+}
+
+// Close stops the listeners and closes the open sessions
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, server := range s.servers {
+		_ = server.Close()
+	}
+	s.servers = nil
+	return nil
+}
+
+// allowed reports whether a client may connect from the given address. Unix socket clients are always allowed.
+func (s *Server) allowed(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || len(s.networks) == 0 {
+		return true
+	}
+
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, network := range s.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedListener turns away the clients connecting from outside the allowed networks before the greeting
+type allowedListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *allowedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.server.allowed(conn.RemoteAddr()) {
+			return conn, nil
+		}
+
+		logging.Log.WithField("remote", conn.RemoteAddr().String()).Warnf("Inbound connection refused: address not allowed")
+		go func() {
+			_ = conn.SetWriteDeadline(time.Now().Add(commandTimeout))
+			_, _ = io.WriteString(conn, "554 5.7.1 Access denied\r\n")
+			_ = conn.Close()
+		}()
+	}
+}
+
+// backend opens the sessions, after AUTH PLAIN when credentials are configured
+type backend struct {
+	server *Server
+}
+
+func (b *backend) Login(state *smtp.ConnectionState, username, password string) (smtp.Session, error) {
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(b.server.username)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(password), []byte(b.server.password)) == 1
+	if !userOK || !passOK {
+		logging.Log.WithField("remote", state.RemoteAddr.String()).Warnf("Inbound authentication failed for %q", username)
+		return nil, errInvalidAuth
+	}
+	return b.server.newSession(state), nil
+}
+
+func (b *backend) AnonymousLogin(state *smtp.ConnectionState) (smtp.Session, error) {
+	if b.server.username != "" {
+		return nil, errAuthRequired
+	}
+	return b.server.newSession(state), nil
+}
+
+// session is the mail transaction of a client connection
+type session struct {
+	server     *Server
+	remoteAddr string
+	log        *logrus.Entry
+
+	from       string
+	recipients []string
+}
+
+func (s *Server) newSession(state *smtp.ConnectionState) *session {
+	remoteAddr := state.RemoteAddr.String()
+	return &session{server: s, remoteAddr: remoteAddr, log: logging.Log.WithField("remote", remoteAddr)}
+}
+
+func (ses *session) Reset() {
+	ses.from, ses.recipients = "", nil
+}
+
+func (ses *session) Logout() error {
+	return nil
+}
+
+func (ses *session) Mail(from string, _ smtp.MailOptions) error {
+	ses.from = from
+	return nil
+}
+
+func (ses *session) Rcpt(rcpt string) error {
+	if !ses.server.accept(rcpt) {
+		ses.log.Infof("Inbound recipient rejected: %s", rcpt)
+		return errNoMailbox
+	}
+	ses.recipients = append(ses.recipients, rcpt)
+	return nil
+}
+
+// Data hands the message over to the handler. Over LMTP, its result is the reply for every recipient.
+func (ses *session) Data(r io.Reader) error {
+	// A message over MaxMessageBytes fails with smtp.ErrDataTooLarge, answered as such
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	msg := &Message{
+		From:       ses.from,
+		Recipients: ses.recipients,
+		Raw:        raw,
+		RemoteAddr: ses.remoteAddr,
+		Received:   time.Now(),
+	}
+	if err := ses.server.handler(msg); err != nil {
+		ses.log.Warnf("Inbound message not accepted: %v", err)
+		return errTryLater
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package smtpd
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+
+	"netflix-household-validator/internal/models"
+)
+
+const testMessage = "From: info@account.netflix.com\r\nTo: owner@example.com\r\nSubject: Test\r\n\r\nHello\r\n"
+
+// testServer runs a Server on a local listener and records the accepted messages
+type testServer struct {
+	addr     string
+	mu       sync.Mutex
+	messages []*Message
+	err      error
+}
+
+func newTestServer(t *testing.T, cfg models.InboundConfig, lmtp bool) *testServer {
+	t.Helper()
+
+	ts := &testServer{}
+	accept := func(rcpt string) bool { return strings.EqualFold(rcpt, "owner@example.com") }
+	handler := func(msg *Message) error {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		if ts.err != nil {
+			return ts.err
+		}
+		ts.messages = append(ts.messages, msg)
+		return nil
+	}
+
+	cfg.Hostname = "validator.test"
+	server, err := New(cfg, accept, handler)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	l, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	ts.addr = l.Addr().String()
+
+	go func() { _ = server.Serve(l, lmtp) }()
+	t.Cleanup(func() { _ = server.Close() })
+	return ts
+}
+
+func (ts *testServer) received() []*Message {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return append([]*Message(nil), ts.messages...)
+}
+
+func TestServer_SMTP(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{}, false)
+
+	if err := smtp.SendMail(ts.addr, nil, "relay@example.org", []string{"Owner@Example.com"}, []byte(testMessage)); err != nil {
+		t.Fatalf("SendMail() error = %v", err)
+	}
+
+	messages := ts.received()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	msg := messages[0]
+	if msg.From != "relay@example.org" || len(msg.Recipients) != 1 || msg.Recipients[0] != "Owner@Example.com" {
+		t.Errorf("envelope = %q -> %q", msg.From, msg.Recipients)
+	}
+	if string(msg.Raw) != testMessage {
+		t.Errorf("Raw = %q, want %q", msg.Raw, testMessage)
+	}
+	if msg.Received.IsZero() {
+		t.Error("Received is not set")
+	}
+}
+
+func TestServer_RejectedRecipient(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{}, false)
+
+	err := smtp.SendMail(ts.addr, nil, "relay@example.org", []string{"someone@example.com"}, []byte(testMessage))
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 550 {
+		t.Fatalf("SendMail() error = %v, want 550", err)
+	}
+	if len(ts.received()) != 0 {
+		t.Error("message accepted for an unknown recipient")
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{Username: "relay", Password: "secret", AllowInsecureAuth: true}, false)
+
+	// net/smtp only sends PLAIN credentials in clear to localhost
+	host, _, _ := net.SplitHostPort(ts.addr)
+	tests := []struct {
+		name     string
+		auth     smtp.Auth
+		wantCode int
+	}{
+		{name: "no credentials", auth: nil, wantCode: 530},
+		{name: "wrong password", auth: smtp.PlainAuth("", "relay", "wrong", host), wantCode: 535},
+		{name: "valid credentials", auth: smtp.PlainAuth("", "relay", "secret", host)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := smtp.SendMail(ts.addr, tt.auth, "relay@example.org", []string{"owner@example.com"}, []byte(testMessage))
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("SendMail() error = %v", err)
+				}
+				return
+			}
+			var protoErr *textproto.Error
+			if !errors.As(err, &protoErr) || protoErr.Code != tt.wantCode {
+				t.Fatalf("SendMail() error = %v, want %d", err, tt.wantCode)
+			}
+		})
+	}
+
+	if len(ts.received()) != 1 {
+		t.Errorf("received %d messages, want 1", len(ts.received()))
+	}
+}
+
+func TestServer_AuthRequiresTLS(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{Username: "relay", Password: "secret"}, false)
+
+	c := dial(t, ts.addr, 220)
+	cmd(t, c, "EHLO client", 250)
+	cmd(t, c, "AUTH PLAIN AHJlbGF5AHNlY3JldA==", 523)
+	cmd(t, c, "MAIL FROM:<relay@example.org>", 530)
+}
+
+func TestServer_AllowedNetworks(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{AllowedNetworks: models.StringList{"10.0.0.0/8"}}, false)
+	dial(t, ts.addr, 554)
+
+	ts = newTestServer(t, models.InboundConfig{AllowedNetworks: models.StringList{"10.0.0.0/8", "127.0.0.1"}}, false)
+	dial(t, ts.addr, 220)
+}
+
+func TestServer_MaxSize(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{MaxSize: 16}, false)
+
+	c := dial(t, ts.addr, 220)
+	cmd(t, c, "EHLO client", 250)
+	cmd(t, c, "MAIL FROM:<relay@example.org>", 250)
+	cmd(t, c, "RCPT TO:<owner@example.com>", 250)
+	cmd(t, c, "DATA", 354)
+	cmd(t, c, strings.ReplaceAll(strings.TrimSuffix(testMessage, "\r\n"), "\r\n", "\n")+"\n.", 552)
+	cmd(t, c, "NOOP", 250)
+
+	if len(ts.received()) != 0 {
+		t.Error("oversized message accepted")
+	}
+}
+
+func TestServer_MaxLineLength(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{}, false)
+
+	c := dial(t, ts.addr, 220)
+	cmd(t, c, "EHLO client", 250)
+	cmd(t, c, "NOOP "+strings.Repeat("x", maxLineLength), 500)
+}
+
+func TestServer_CheckListener(t *testing.T) {
+	l, err := Listen(":0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		name    string
+		cfg     models.InboundConfig
+		wantErr bool
+	}{
+		{name: "open to any host", cfg: models.InboundConfig{}, wantErr: true},
+		{name: "authentication", cfg: models.InboundConfig{Username: "relay", Password: "secret"}},
+		{name: "allowed networks", cfg: models.InboundConfig{AllowedNetworks: models.StringList{"10.0.0.0/8"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := New(tt.cfg, func(string) bool { return true }, func(*Message) error { return nil })
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if err := server.CheckListener(l); (err != nil) != tt.wantErr {
+				t.Errorf("CheckListener() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServer_HandlerError(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{}, false)
+	ts.err = errors.New("spool full")
+
+	err := smtp.SendMail(ts.addr, nil, "relay@example.org", []string{"owner@example.com"}, []byte(testMessage))
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 451 {
+		t.Fatalf("SendMail() error = %v, want 451", err)
+	}
+}
+
+func TestServer_LMTP(t *testing.T) {
+	ts := newTestServer(t, models.InboundConfig{}, true)
+
+	c := dial(t, ts.addr, 220)
+	cmd(t, c, "EHLO client", 500)
+	cmd(t, c, "LHLO client", 250)
+	cmd(t, c, "MAIL FROM:<relay@example.org>", 250)
+	cmd(t, c, "RCPT TO:<owner@example.com>", 250)
+	cmd(t, c, "RCPT TO:<OWNER@example.com>", 250)
+	cmd(t, c, "RCPT TO:<someone@example.com>", 550)
+	cmd(t, c, "DATA", 354)
+
+	// One reply per accepted recipient
+	if err := c.PrintfLine("%s.", testMessage); err != nil {
+		t.Fatalf("PrintfLine() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.ReadResponse(250); err != nil {
+			t.Fatalf("reply %d: %v", i+1, err)
+		}
+	}
+	cmd(t, c, "QUIT", 221)
+
+	if messages := ts.received(); len(messages) != 1 || len(messages[0].Recipients) != 2 {
+		t.Errorf("received = %+v, want one message for 2 recipients", messages)
+	}
+}
+
+func TestListen_Unix(t *testing.T) {
+	path := t.TempDir() + "/lmtp.sock"
+	l, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer l.Close()
+
+	if l.Addr().Network() != "unix" || l.Addr().String() != path {
+		t.Errorf("Addr() = %s %s", l.Addr().Network(), l.Addr())
+	}
+}
+
+// dial connects to the server and checks the greeting code
+func dial(t *testing.T, addr string, wantCode int) *textproto.Conn {
+	t.Helper()
+	c, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	if code, msg, err := c.ReadResponse(wantCode); err != nil {
+		t.Fatalf("greeting = %d %s, want %d", code, msg, wantCode)
+	}
+	return c
+}
+
+// cmd sends a command line and checks the reply code
+func cmd(t *testing.T, c *textproto.Conn, line string, wantCode int) {
+	t.Helper()
+	if err := c.PrintfLine("%s", line); err != nil {
+		t.Fatalf("PrintfLine(%q) error = %v", line, err)
+	}
+	if code, msg, err := c.ReadResponse(wantCode); err != nil {
+		t.Fatalf("%q = %d %s, want %d", line, code, msg, wantCode)
+	}
+}
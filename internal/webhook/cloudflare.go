@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"netflix-household-validator/internal/models"
+)
+
+// Headers set by the Cloudflare Email Worker
+const (
+	cloudflareSignatureHeader = "X-Webhook-Signature"
+	cloudflareTimestampHeader = "X-Webhook-Timestamp"
+	cloudflareFromHeader      = "X-Envelope-From"
+	cloudflareToHeader        = "X-Envelope-To"
+)
+
+// cloudflare handles the raw messages posted by a Cloudflare Email Worker (message.raw), with the envelope in
+// headers. Requests are signed with the shared secret: signature = hex(HMAC-SHA256(secret, timestamp + "." + body)).
+// A signature is accepted once.
+type cloudflare struct {
+	secret []byte
+	seen   *replayCache
+}
+
+func (c *cloudflare) verify(r *http.Request, body []byte) error {
+	timestamp := r.Header.Get(cloudflareTimestampHeader)
+	if err := checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	got, err := hex.DecodeString(r.Header.Get(cloudflareSignatureHeader))
+	if err != nil || len(got) == 0 {
+		return errors.New("missing or invalid webhook signature")
+	}
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+	if !hmac.Equal(got, want) {
+		return errors.New("webhook signature mismatch")
+	}
+	if !c.seen.use(string(want), timestamp) {
+		return errors.New("webhook request already received")
+	}
+	return nil
+}
+
+func (c *cloudflare) message(r *http.Request, body []byte) (*Message, error) {
+	if len(body) == 0 {
+		return nil, errors.New("no message in the request")
+	}
+	return &Message{
+		From:       r.Header.Get(cloudflareFromHeader),
+		Recipients: models.SplitList(r.Header.Get(cloudflareToHeader)),
+		Raw:        body,
+	}, nil
+}
+
+// rejectStatus is 406 Not Acceptable, on which the Worker rejects the message
+func (c *cloudflare) rejectStatus() int {
+	return http.StatusNotAcceptable
+}
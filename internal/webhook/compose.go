@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+)
+
+// headerField is a header field of the original message, kept in order for the authenticity checks
+type headerField struct {
+	name  string
+	value string
+}
+
+// parseHeaderBlock splits a raw header block into its fields, unfolding the continuation lines
+func parseHeaderBlock(block string) []headerField {
+	var fields []headerField
+	for _, line := range strings.Split(strings.ReplaceAll(block, "\r\n", "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1].value += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields = append(fields, headerField{name: strings.TrimSpace(name), value: strings.TrimSpace(value)})
+	}
+	return fields
+}
+
+// hasField reports whether the header field is present
+func hasField(fields []headerField, name string) bool {
+	for _, f := range fields {
+		if strings.EqualFold(f.name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// body is a parsed body posted by a provider, with its charset
+type body struct {
+	content string
+	charset string
+}
+
+// composeMIME rebuilds a message from the original header fields and the parsed bodies posted by a provider.
+// The content header fields are replaced by a multipart/alternative of the text and HTML bodies. DKIM signatures
+// cannot pass on a rebuilt message: its authenticity relies on the Authentication-Results of the provider.
+func composeMIME(fields []headerField, text, html body) []byte {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	for _, f := range fields {
+		name := textproto.CanonicalMIMEHeaderKey(f.name)
+		if name == "Mime-Version" || strings.HasPrefix(name, "Content-") {
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\nContent-Type: multipart/alternative; boundary=%q\r\n\r\n", mw.Boundary())
+
+	for _, part := range []struct {
+		mediaType string
+		body      body
+	}{{"text/plain", text}, {"text/html", html}} {
+		if part.body.content == "" {
+			continue
+		}
+		charset := part.body.charset
+		if charset == "" {
+			charset = "utf-8"
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", mime.FormatMediaType(part.mediaType, map[string]string{"charset": charset}))
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, _ := mw.CreatePart(header)
+		qp := quotedprintable.NewWriter(w)
+		_, _ = qp.Write([]byte(part.body.content))
+		_ = qp.Close()
+	}
+	_ = mw.Close()
+
+	return buf.Bytes()
+}
@@ -0,0 +1,95 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"netflix-household-validator/internal/models"
+)
+
+// mailgun handles the messages forwarded by a Mailgun route (forward("https://host/inbound/mailgun")).
+// Requests are signed with the HTTP webhook signing key: signature = hex(HMAC-SHA256(key, timestamp + token)).
+// Every token is accepted once: they are remembered until their timestamp leaves the tolerance window.
+type mailgun struct {
+	signingKey []byte
+	tokens     *replayCache
+}
+
+func newMailgun(signingKey string) *mailgun {
+	return &mailgun{signingKey: []byte(signingKey), tokens: newReplayCache()}
+}
+
+func (m *mailgun) verify(r *http.Request, _ []byte) error {
+	timestamp, token, signature := r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")
+	if timestamp == "" || token == "" || signature == "" {
+		return errors.New("missing Mailgun signature fields")
+	}
+	if err := checkTimestamp(timestamp); err != nil {
+		return err
+	}
+
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return errors.New("invalid Mailgun signature encoding")
+	}
+	mac := hmac.New(sha256.New, m.signingKey)
+	mac.Write([]byte(timestamp + token))
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return errors.New("Mailgun signature mismatch")
+	}
+	if !m.tokens.use(token, timestamp) {
+		return errors.New("Mailgun token already used")
+	}
+	return nil
+}
+
+// message uses the raw message posted to routes whose URL ends with "mime" (body-mime), or rebuilds it from the
+// parsed fields
+func (m *mailgun) message(r *http.Request, _ []byte) (*Message, error) {
+	msg := &Message{
+		From:       r.FormValue("sender"),
+		Recipients: models.SplitList(r.FormValue("recipient")),
+	}
+
+	if raw := r.FormValue("body-mime"); raw != "" {
+		msg.Raw = []byte(raw)
+		return msg, nil
+	}
+
+	var fields []headerField
+	if headers := r.FormValue("message-headers"); headers != "" {
+		var pairs [][2]string
+		if err := json.Unmarshal([]byte(headers), &pairs); err != nil {
+			return nil, fmt.Errorf("invalid message-headers: %w", err)
+		}
+		for _, pair := range pairs {
+			fields = append(fields, headerField{name: pair[0], value: pair[1]})
+		}
+	}
+	for _, fallback := range []headerField{
+		{"From", r.FormValue("from")},
+		{"To", r.FormValue("To")},
+		{"Subject", r.FormValue("subject")},
+	} {
+		if fallback.value != "" && !hasField(fields, fallback.name) {
+			fields = append(fields, fallback)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("no message in the request")
+	}
+
+	// Mailgun posts the bodies in UTF-8
+	msg.Raw = composeMIME(fields, body{content: r.FormValue("body-plain")}, body{content: r.FormValue("body-html")})
+	return msg, nil
+}
+
+// rejectStatus is 406 Not Acceptable, which Mailgun does not retry
+func (m *mailgun) rejectStatus() int {
+	return http.StatusNotAcceptable
+}
@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+
+	"netflix-household-validator/internal/models"
+)
+
+// Headers of the SendGrid signed webhooks
+const (
+	sendGridSignatureHeader = "X-Twilio-Email-Event-Webhook-Signature"
+	sendGridTimestampHeader = "X-Twilio-Email-Event-Webhook-Timestamp"
+)
+
+// sendGrid handles the messages posted by SendGrid Inbound Parse. Requests are authenticated with the basic auth
+// credentials of the URL, and/or with the ECDSA signature of signed webhooks: base64(ASN.1 ECDSA(SHA-256(timestamp + body))).
+// A signed request is accepted once: ECDSA signatures are not unique, so it is identified by its signed hash.
+type sendGrid struct {
+	username  string
+	password  string
+	publicKey *ecdsa.PublicKey
+	seen      *replayCache
+}
+
+func newSendGrid(cfg models.SendGridWebhookConfig) (*sendGrid, error) {
+	sg := &sendGrid{username: cfg.Username, password: cfg.Password}
+	if cfg.PublicKey == "" {
+		return sg, nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(cfg.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SendGrid public key: %w", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SendGrid public key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("invalid SendGrid public key: not an ECDSA key")
+	}
+	sg.publicKey, sg.seen = ecKey, newReplayCache()
+	return sg, nil
+}
+
+func (sg *sendGrid) verify(r *http.Request, body []byte) error {
+	if sg.password != "" {
+		username, password, ok := r.BasicAuth()
+		userOK := subtle.ConstantTimeCompare([]byte(username), []byte(sg.username)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(password), []byte(sg.password)) == 1
+		if !ok || !userOK || !passOK {
+			return errors.New("invalid SendGrid basic auth credentials")
+		}
+	}
+
+	if sg.publicKey != nil {
+		timestamp := r.Header.Get(sendGridTimestampHeader)
+		if err := checkTimestamp(timestamp); err != nil {
+			return err
+		}
+		signature, err := base64.StdEncoding.DecodeString(r.Header.Get(sendGridSignatureHeader))
+		if err != nil || len(signature) == 0 {
+			return errors.New("missing or invalid SendGrid signature")
+		}
+		hash := sha256.Sum256(append([]byte(timestamp), body...))
+		if !ecdsa.VerifyASN1(sg.publicKey, hash[:], signature) {
+			return errors.New("SendGrid signature mismatch")
+		}
+		if !sg.seen.use(string(hash[:]), timestamp) {
+			return errors.New("SendGrid request already received")
+		}
+	}
+	return nil
+}
+
+// message uses the raw message posted with "POST the raw, full MIME message" (email), or rebuilds it from the
+// parsed fields
+func (sg *sendGrid) message(r *http.Request, _ []byte) (*Message, error) {
+	msg := &Message{}
+
+	var envelope struct {
+		To   []string `json:"to"`
+		From string   `json:"from"`
+	}
+	if value := r.FormValue("envelope"); value != "" {
+		if err := json.Unmarshal([]byte(value), &envelope); err != nil {
+			return nil, fmt.Errorf("invalid envelope: %w", err)
+		}
+	}
+	msg.From, msg.Recipients = envelope.From, envelope.To
+	if len(msg.Recipients) == 0 {
+		if to, err := mail.ParseAddressList(r.FormValue("to")); err == nil {
+			for _, addr := range to {
+				msg.Recipients = append(msg.Recipients, addr.Address)
+			}
+		}
+	}
+
+	if raw := r.FormValue("email"); raw != "" {
+		msg.Raw = []byte(raw)
+		return msg, nil
+	}
+
+	fields := parseHeaderBlock(r.FormValue("headers"))
+	if len(fields) == 0 {
+		return nil, errors.New("no message in the request")
+	}
+
+	// The parsed fields keep the charset of the original parts
+	var charsets map[string]string
+	if value := r.FormValue("charsets"); value != "" {
+		_ = json.Unmarshal([]byte(value), &charsets)
+	}
+	msg.Raw = composeMIME(fields,
+		body{content: r.FormValue("text"), charset: charsets["text"]},
+		body{content: r.FormValue("html"), charset: charsets["html"]})
+	return msg, nil
+}
+
+// rejectStatus is 200 OK: SendGrid retries every other status for 3 days, the message is dropped instead
+func (sg *sendGrid) rejectStatus() int {
+	return http.StatusOK
+}
@@ -0,0 +1,239 @@
+package webhook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"netflix-household-validator/internal/logging"
+	"netflix-household-validator/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultMaxSize is the maximum request size when none is configured. It is larger than a message because
+	// providers post the attachments, and the parsed bodies next to the raw message.
+	defaultMaxSize = 25 << 20
+	// maxMemory is the part of a multipart form kept in memory, the rest is written to temporary files
+	maxMemory = 8 << 20
+	// timestampTolerance bounds the age of the signed timestamps, so that a captured request cannot be replayed later
+	timestampTolerance = 5 * time.Minute
+)
+
+// Provider names, also the last element of their endpoint path (/inbound/<provider>)
+const (
+	ProviderMailgun    = "mailgun"
+	ProviderSendGrid   = "sendgrid"
+	ProviderCloudflare = "cloudflare"
+)
+
+// now is the clock the signed timestamps are checked against, replaced by the tests
+var now = time.Now
+
+// ErrRejected is returned by a Handler when the message must not be retried (e.g. unknown recipient)
+var ErrRejected = errors.New("message rejected")
+
+// Message is a message posted by a provider, converted to raw MIME
+type Message struct {
+	Provider   string
+	From       string
+	Recipients []string
+	// Raw is the message as received, or rebuilt from the parsed fields when the provider does not post it
+	Raw      []byte
+	Received time.Time
+}
+
+// Handler is given every verified message. It must return quickly: the message is processed asynchronously.
+// An error other than ErrRejected makes the provider retry later.
+type Handler func(msg *Message) error
+
+// provider verifies and decodes the requests of one email-routing service
+type provider interface {
+	// verify checks the signature (or credentials) of the request; body is the raw request body, whose form
+	// fields are already parsed
+	verify(r *http.Request, body []byte) error
+	// message converts the request to a Message
+	message(r *http.Request, body []byte) (*Message, error)
+	// rejectStatus is the status telling the provider not to retry
+	rejectStatus() int
+}
+
+// Server serves the /inbound/<provider> endpoints of the configured providers
+type Server struct {
+	mux     *http.ServeMux
+	maxSize int64
+	accept  func(rcpt string) bool
+	handler Handler
+}
+
+// New creates the endpoints of the providers configured in cfg. accept reports whether a recipient address is served.
+func New(cfg models.WebhookConfig, accept func(rcpt string) bool, handler Handler) (*Server, error) {
+	s := &Server{
+		mux:     http.NewServeMux(),
+		maxSize: cfg.MaxSize,
+		accept:  accept,
+		handler: handler,
+	}
+	if s.maxSize <= 0 {
+		s.maxSize = defaultMaxSize
+	}
+
+	providers := make(map[string]provider)
+	if cfg.Mailgun.SigningKey != "" {
+		providers[ProviderMailgun] = newMailgun(cfg.Mailgun.SigningKey)
+	}
+	if cfg.SendGrid.Password != "" || cfg.SendGrid.PublicKey != "" {
+		sg, err := newSendGrid(cfg.SendGrid)
+		if err != nil {
+			return nil, err
+		}
+		providers[ProviderSendGrid] = sg
+	}
+	if cfg.Cloudflare.Secret != "" {
+		providers[ProviderCloudflare] = &cloudflare{secret: []byte(cfg.Cloudflare.Secret), seen: newReplayCache()}
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("no webhook provider configured")
+	}
+
+	for name, p := range providers {
+		s.mux.Handle("POST /inbound/"+name, s.endpoint(name, p))
+	}
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// endpoint returns the handler of a provider: verify → convert → filter recipients → hand over
+func (s *Server) endpoint(name string, p provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		locallog := logging.Log.WithFields(logrus.Fields{"provider": name, "remote": r.RemoteAddr})
+		r.Body = http.MaxBytesReader(w, r.Body, s.maxSize)
+
+		body, err := readBody(r)
+		if err != nil {
+			locallog.Warnf("Webhook request cannot be read: %v", err)
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		if r.MultipartForm != nil {
+			defer func() { _ = r.MultipartForm.RemoveAll() }()
+		}
+
+		if err := p.verify(r, body); err != nil {
+			locallog.Warnf("Webhook signature rejected: %v", err)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		msg, err := p.message(r, body)
+		if err != nil {
+			locallog.Warnf("Webhook payload rejected: %v", err)
+			http.Error(w, err.Error(), p.rejectStatus())
+			return
+		}
+		msg.Provider = name
+		msg.Received = now()
+
+		// Keep the recipients that belong to an account, like RCPT TO in SMTP
+		var recipients []string
+		for _, rcpt := range msg.Recipients {
+			if s.accept(rcpt) {
+				recipients = append(recipients, rcpt)
+			}
+		}
+		if len(recipients) == 0 {
+			locallog.Infof("Webhook recipient rejected: %s", strings.Join(msg.Recipients, ", "))
+			http.Error(w, "recipient not accepted", p.rejectStatus())
+			return
+		}
+		msg.Recipients = recipients
+
+		if err := s.handler(msg); err != nil {
+			locallog.Warnf("Webhook message not accepted: %v", err)
+			if errors.Is(err, ErrRejected) {
+				http.Error(w, err.Error(), p.rejectStatus())
+				return
+			}
+			http.Error(w, "temporary failure, try again later", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// readBody reads the request body, which signatures are computed on, and parses it when it is a form
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case strings.HasPrefix(contentType, "multipart/form-data"):
+		err = r.ParseMultipartForm(maxMemory)
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		err = r.ParseForm()
+	}
+	return body, err
+}
+
+// checkTimestamp verifies that a signed Unix timestamp is recent
+func checkTimestamp(value string) error {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", value)
+	}
+	if d := now().Sub(time.Unix(seconds, 0)); d > timestampTolerance || d < -timestampTolerance {
+		return fmt.Errorf("timestamp %s out of tolerance", time.Unix(seconds, 0).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// replayCache remembers the signed requests already accepted until their timestamp leaves the tolerance window, so
+// that a captured request cannot be replayed within it
+type replayCache struct {
+	mu   sync.Mutex
+	seen map[string]time.Time // key → expiry
+}
+
+func newReplayCache() *replayCache {
+	return &replayCache{seen: make(map[string]time.Time)}
+}
+
+// use records the key of a request signed at timestamp, already checked, and reports false when it was already used
+func (c *replayCache) use(key, timestamp string) bool {
+	seconds, _ := strconv.ParseInt(timestamp, 10, 64)
+	current := now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, expiry := range c.seen {
+		if current.After(expiry) {
+			delete(c.seen, k)
+		}
+	}
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = time.Unix(seconds, 0).Add(timestampTolerance)
+	return true
+}
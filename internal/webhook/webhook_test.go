@@ -0,0 +1,332 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"netflix-household-validator/internal/mailparse"
+	"netflix-household-validator/internal/models"
+)
+
+const (
+	testTimestamp = "1760000000"
+	testLink      = "https://www.netflix.com/account/update-primary-location?nftoken=abc123"
+	testRaw       = "From: Netflix <info@account.netflix.com>\r\nTo: owner@example.com\r\n" +
+		"Subject: Important: how to update your Netflix household\r\n\r\n" + testLink + "\r\n"
+)
+
+// recorder is a Handler recording the delivered messages
+type recorder struct {
+	messages []*Message
+	err      error
+}
+
+func (rec *recorder) handle(msg *Message) error {
+	if rec.err != nil {
+		return rec.err
+	}
+	rec.messages = append(rec.messages, msg)
+	return nil
+}
+
+func newTestServer(t *testing.T, cfg models.WebhookConfig) (*Server, *recorder) {
+	t.Helper()
+
+	now = func() time.Time { return time.Unix(1760000060, 0) }
+	t.Cleanup(func() { now = time.Now })
+
+	rec := &recorder{}
+	accept := func(rcpt string) bool { return strings.EqualFold(rcpt, "owner@example.com") }
+	s, err := New(cfg, accept, rec.handle)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s, rec
+}
+
+func serve(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func hmacHex(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// multipartRequest builds a multipart/form-data POST like the providers send
+func multipartRequest(t *testing.T, path string, fields map[string]string) *http.Request {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		if err := mw.WriteField(name, value); err != nil {
+			t.Fatalf("WriteField() error = %v", err)
+		}
+	}
+	_ = mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, path, &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func mailgunForm(timestamp, token, signature string, fields url.Values) *http.Request {
+	fields.Set("timestamp", timestamp)
+	fields.Set("token", token)
+	fields.Set("signature", signature)
+	r := httptest.NewRequest(http.MethodPost, "/inbound/mailgun", strings.NewReader(fields.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestMailgun_RawMIME(t *testing.T) {
+	s, rec := newTestServer(t, models.WebhookConfig{Mailgun: models.MailgunWebhookConfig{SigningKey: "key-1"}})
+	signature := hmacHex("key-1", testTimestamp+"6f3c1a2b9d")
+
+	tests := []struct {
+		name       string
+		timestamp  string
+		token      string
+		signature  string
+		recipient  string
+		wantStatus int
+	}{
+		{name: "valid", timestamp: testTimestamp, token: "6f3c1a2b9d", signature: signature, recipient: "Owner@example.com", wantStatus: http.StatusOK},
+		{name: "replayed token", timestamp: testTimestamp, token: "6f3c1a2b9d", signature: signature, recipient: "Owner@example.com", wantStatus: http.StatusUnauthorized},
+		{name: "wrong signature", timestamp: testTimestamp, token: "7a4d2b3c0e", signature: hmacHex("key-2", testTimestamp+"7a4d2b3c0e"), recipient: "owner@example.com", wantStatus: http.StatusUnauthorized},
+		{name: "stale timestamp", timestamp: "1759990000", token: "8b5e3c4d1f", signature: hmacHex("key-1", "1759990000"+"8b5e3c4d1f"), recipient: "owner@example.com", wantStatus: http.StatusUnauthorized},
+		{name: "unknown recipient", timestamp: testTimestamp, token: "9c6f4d5e2a", signature: hmacHex("key-1", testTimestamp+"9c6f4d5e2a"), recipient: "someone@example.com", wantStatus: http.StatusNotAcceptable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec.messages = nil
+			fields := url.Values{"recipient": {tt.recipient}, "sender": {"bounce@netflix.com"}, "body-mime": {testRaw}}
+			w := serve(s, mailgunForm(tt.timestamp, tt.token, tt.signature, fields))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if len(rec.messages) != 0 {
+					t.Error("message delivered")
+				}
+				return
+			}
+
+			if len(rec.messages) != 1 {
+				t.Fatalf("delivered %d messages, want 1", len(rec.messages))
+			}
+			msg := rec.messages[0]
+			if msg.Provider != ProviderMailgun || msg.From != "bounce@netflix.com" || string(msg.Raw) != testRaw ||
+				len(msg.Recipients) != 1 || msg.Recipients[0] != "Owner@example.com" {
+				t.Errorf("message = %+v", msg)
+			}
+		})
+	}
+}
+
+func TestMailgun_ParsedFields(t *testing.T) {
+	s, rec := newTestServer(t, models.WebhookConfig{Mailgun: models.MailgunWebhookConfig{SigningKey: "key-1"}})
+
+	fields := url.Values{
+		"recipient": {"owner@example.com"},
+		"sender":    {"bounce@netflix.com"},
+		"message-headers": {`[["Authentication-Results", "mx.mailgun.org; dkim=pass header.d=netflix.com"],` +
+			`["From", "Netflix <info@account.netflix.com>"], ["To", "owner@example.com"],` +
+			`["Subject", "Important : comment mettre à jour votre foyer Netflix"],` +
+			`["Content-Type", "multipart/alternative; boundary=\"xyz\""]]`},
+		"body-plain": {"Confirm: " + testLink},
+		"body-html":  {`<a href="` + testLink + `">Yes, it was me</a>`},
+	}
+	w := serve(s, mailgunForm(testTimestamp, "6f3c1a2b9d", hmacHex("key-1", testTimestamp+"6f3c1a2b9d"), fields))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body)
+	}
+	if len(rec.messages) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(rec.messages))
+	}
+
+	email, err := mailparse.ParseRaw(rec.messages[0].Raw)
+	if err != nil {
+		t.Fatalf("ParseRaw() error = %v", err)
+	}
+	if email.From != "info@account.netflix.com" || email.Subject != "Important : comment mettre à jour votre foyer Netflix" {
+		t.Errorf("From = %q, Subject = %q", email.From, email.Subject)
+	}
+	if len(email.Links) == 0 || email.Links[0] != testLink {
+		t.Errorf("Links = %v, want %s", email.Links, testLink)
+	}
+	if !bytes.Contains(rec.messages[0].Raw, []byte("Authentication-Results: mx.mailgun.org")) ||
+		bytes.Contains(rec.messages[0].Raw, []byte("boundary=\"xyz\"")) {
+		t.Errorf("header fields not rebuilt as expected:\n%s", rec.messages[0].Raw)
+	}
+}
+
+func TestSendGrid_BasicAuth(t *testing.T) {
+	s, rec := newTestServer(t, models.WebhookConfig{SendGrid: models.SendGridWebhookConfig{Username: "sendgrid", Password: "secret"}})
+	fields := map[string]string{
+		"envelope": `{"to":["owner@example.com"],"from":"bounce@netflix.com"}`,
+		"email":    testRaw,
+	}
+
+	r := multipartRequest(t, "/inbound/sendgrid", fields)
+	r.SetBasicAuth("sendgrid", "wrong")
+	if w := serve(s, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status with wrong credentials = %d, want 401", w.Code)
+	}
+
+	r = multipartRequest(t, "/inbound/sendgrid", fields)
+	r.SetBasicAuth("sendgrid", "secret")
+	if w := serve(s, r); w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body)
+	}
+	if len(rec.messages) != 1 || string(rec.messages[0].Raw) != testRaw || rec.messages[0].From != "bounce@netflix.com" {
+		t.Errorf("messages = %+v", rec.messages)
+	}
+
+	// Unknown recipients are acknowledged, so that SendGrid does not retry them
+	fields["envelope"] = `{"to":["someone@example.com"],"from":"bounce@netflix.com"}`
+	r = multipartRequest(t, "/inbound/sendgrid", fields)
+	r.SetBasicAuth("sendgrid", "secret")
+	if w := serve(s, r); w.Code != http.StatusOK || len(rec.messages) != 1 {
+		t.Errorf("status for an unknown recipient = %d, %d messages", w.Code, len(rec.messages))
+	}
+}
+
+func TestSendGrid_SignedParsedFields(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	s, rec := newTestServer(t, models.WebhookConfig{SendGrid: models.SendGridWebhookConfig{PublicKey: base64.StdEncoding.EncodeToString(der)}})
+
+	fields := map[string]string{
+		"headers":  "From: Netflix <info@account.netflix.com>\nTo: owner@example.com\nSubject: Important: how to\n update your Netflix household\nContent-Type: text/plain\n",
+		"to":       "owner@example.com",
+		"text":     "Confirm: " + testLink,
+		"charsets": `{"to":"UTF-8","subject":"UTF-8","from":"UTF-8","text":"iso-8859-1"}`,
+	}
+	sign := func(r *http.Request) {
+		body, _ := readAll(r)
+		hash := sha256.Sum256(append([]byte(testTimestamp), body...))
+		signature, _ := ecdsa.SignASN1(rand.Reader, key, hash[:])
+		r.Header.Set(sendGridTimestampHeader, testTimestamp)
+		r.Header.Set(sendGridSignatureHeader, base64.StdEncoding.EncodeToString(signature))
+	}
+
+	r := multipartRequest(t, "/inbound/sendgrid", fields)
+	if w := serve(s, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status without signature = %d, want 401", w.Code)
+	}
+
+	r = multipartRequest(t, "/inbound/sendgrid", fields)
+	sign(r)
+	body, _ := readAll(r)
+	if w := serve(s, r); w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body)
+	}
+	if len(rec.messages) != 1 {
+		t.Fatalf("delivered %d messages, want 1", len(rec.messages))
+	}
+
+	// The same request signed again (ECDSA signatures differ every time) is a replay
+	replayed := httptest.NewRequest(http.MethodPost, "/inbound/sendgrid", bytes.NewReader(body))
+	replayed.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	sign(replayed)
+	if w := serve(s, replayed); w.Code != http.StatusUnauthorized || len(rec.messages) != 1 {
+		t.Errorf("status of the replayed request = %d, want 401", w.Code)
+	}
+
+	email, err := mailparse.ParseRaw(rec.messages[0].Raw)
+	if err != nil {
+		t.Fatalf("ParseRaw() error = %v", err)
+	}
+	if email.Subject != "Important: how to update your Netflix household" || email.ToPrimary != "owner@example.com" {
+		t.Errorf("Subject = %q, ToPrimary = %q", email.Subject, email.ToPrimary)
+	}
+	if len(email.Links) == 0 || email.Links[0] != testLink {
+		t.Errorf("Links = %v", email.Links)
+	}
+	if !bytes.Contains(rec.messages[0].Raw, []byte("charset=iso-8859-1")) {
+		t.Errorf("text charset not kept:\n%s", rec.messages[0].Raw)
+	}
+}
+
+func TestCloudflare(t *testing.T) {
+	s, rec := newTestServer(t, models.WebhookConfig{Cloudflare: models.CloudflareWebhookConfig{Secret: "shared"}})
+
+	request := func(timestamp, secret string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/inbound/cloudflare", strings.NewReader(testRaw))
+		r.Header.Set("Content-Type", "message/rfc822")
+		r.Header.Set(cloudflareTimestampHeader, timestamp)
+		r.Header.Set(cloudflareSignatureHeader, hmacHex(secret, timestamp+"."+testRaw))
+		r.Header.Set(cloudflareFromHeader, "bounce@netflix.com")
+		r.Header.Set(cloudflareToHeader, "owner@example.com")
+		return r
+	}
+
+	if w := serve(s, request(testTimestamp, "other")); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status with wrong signature = %d, want 401", w.Code)
+	}
+	if w := serve(s, request(testTimestamp, "shared")); w.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", w.Code, w.Body)
+	}
+	if len(rec.messages) != 1 || string(rec.messages[0].Raw) != testRaw || rec.messages[0].Received.Unix() != 1760000060 {
+		t.Errorf("messages = %+v", rec.messages)
+	}
+	if w := serve(s, request(testTimestamp, "shared")); w.Code != http.StatusUnauthorized || len(rec.messages) != 1 {
+		t.Errorf("status of the replayed request = %d, want 401", w.Code)
+	}
+
+	// Handler errors: temporary unless rejected
+	rec.err = errors.New("inbox full")
+	if w := serve(s, request("1760000001", "shared")); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status on handler error = %d, want 503", w.Code)
+	}
+	rec.err = ErrRejected
+	if w := serve(s, request("1760000002", "shared")); w.Code != http.StatusNotAcceptable {
+		t.Errorf("status on rejection = %d, want 406", w.Code)
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(models.WebhookConfig{}, nil, nil); err == nil {
+		t.Error("New() without provider: expected error")
+	}
+	if _, err := New(models.WebhookConfig{SendGrid: models.SendGridWebhookConfig{PublicKey: "bm90IGEga2V5"}}, nil, nil); err == nil {
+		t.Error("New() with an invalid SendGrid key: expected error")
+	}
+
+	// Only the configured providers have an endpoint
+	s, _ := newTestServer(t, models.WebhookConfig{Cloudflare: models.CloudflareWebhookConfig{Secret: "shared"}})
+	if w := serve(s, httptest.NewRequest(http.MethodPost, "/inbound/mailgun", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("status of an unconfigured provider = %d, want 404", w.Code)
+	}
+}
+
+// readAll returns the body of a test request, which is then restored
+func readAll(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, err
+}